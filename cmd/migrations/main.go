@@ -33,6 +33,41 @@ func main() {
 	log.Println("Running migrations of tables...")
 	db.AutoMigrate(
 		&models.User{},
+		&models.SigningKey{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.WebAuthnCredential{},
+		&models.OAuthClient{},
+		&models.ExternalIdentity{},
+		&models.Role{},
+		&models.Permission{},
+		&models.PasswordResetToken{},
 	)
+	// Ключ постраничного списка пользователей при сортировке по времени создания
+	db.Model(&models.User{}).AddIndex("idx_users_created_at_id", "created_at", "id")
+
+	// Поиск контактов: триграммные индексы (pg_trgm) обслуживают и префиксный LIKE, и оператор сходства %
+	log.Println("Creating user search indexes...")
+	for _, statement := range []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS idx_users_login_trgm ON users USING gin (LOWER(login) gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING gin (LOWER(name) gin_trgm_ops)",
+		// Адрес почты уникален без учета регистра среди неудаленных пользователей. Индекс не дает двум
+		// одновременным регистрациям или подтверждениям смены почты занять один адрес
+		"DROP INDEX IF EXISTS idx_users_email_lower",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower_unique ON users (LOWER(email)) WHERE deleted_at IS NULL",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Fatalf("Could not create user search indexes: %v", err)
+			return
+		}
+	}
+
+	log.Println("Seeding built-in roles...")
+	if err := repositories.SeedRoles(db); err != nil {
+		log.Fatalf("Could not seed roles: %v", err)
+		return
+	}
 	log.Println("Migrations completed")
 }
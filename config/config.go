@@ -1,26 +1,117 @@
 package config
 
 import (
-	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
-	ServiceAddress         string `env:"SERVICE_ADDRESS" envDefault:"localhost:80"`
-	LogLevel               string `env:"SERVICE_LOG_LEVEL" envDefault:"info"`
-	DBHost                 string `env:"DB_HOST" envDefault:"localhost"`
-	DBPort                 string `env:"DB_PORT" envDefault:"5432"`
-	DBUser                 string `env:"DB_USER" envDefault:"postgres"`
-	DBPassword             string `env:"DB_PASSWORD" envDefault:"mysecretpassword"`
-	DBName                 string `env:"DB_NAME" envDefault:"mydb"`
-	RedisHost              string `env:"REDIS_HOST" envDefault:"localhost"`
-	RedisPort              string `env:"REDIS_PORT" envDefault:"5432"`
-	RedisPassword          string `env:"REDIS_PASSWORD" envDefault:"mysecretpassword"`
-	RedisEnabled           string `env:"REDIS_ENABLED" envDefault:"true"`
-	AuthServiceGRPCAddress string `env:"AUTH_SERVICE_GRPC_ADDRESS" envDefault:"localhost:50051"`
-	AuthEnabled            string `env:"AUTH_ENABLED" envDefault:"true"`
+	ServiceAddress string `env:"SERVICE_ADDRESS" envDefault:"localhost:80"`
+	LogLevel       string `env:"SERVICE_LOG_LEVEL" envDefault:"info"`
+	// Адреса или подсети обратных прокси, которым разрешено передавать адрес клиента в X-Forwarded-For.
+	// Пусто - заголовок не учитывается, адрес клиента берется из соединения
+	TrustedProxies         []string `env:"TRUSTED_PROXIES" envSeparator:","`
+	DBHost                 string   `env:"DB_HOST" envDefault:"localhost"`
+	DBPort                 string   `env:"DB_PORT" envDefault:"5432"`
+	DBUser                 string   `env:"DB_USER" envDefault:"postgres"`
+	DBPassword             string   `env:"DB_PASSWORD" envDefault:"mysecretpassword"`
+	DBName                 string   `env:"DB_NAME" envDefault:"mydb"`
+	RedisHost              string   `env:"REDIS_HOST" envDefault:"localhost"`
+	RedisPort              string   `env:"REDIS_PORT" envDefault:"5432"`
+	RedisPassword          string   `env:"REDIS_PASSWORD" envDefault:"mysecretpassword"`
+	RedisEnabled           string   `env:"REDIS_ENABLED" envDefault:"true"`
+	AuthServiceGRPCAddress string   `env:"AUTH_SERVICE_GRPC_ADDRESS" envDefault:"localhost:50051"`
+	AuthEnabled            string   `env:"AUTH_ENABLED" envDefault:"true"`
+	// JSON файл с токенами внутренних сервисов (config.ServiceToken). Без токена нельзя вызвать GetUsersById
+	GRPCServiceTokensFile string `env:"GRPC_SERVICE_TOKENS_FILE" envDefault:""`
+
+	JWTIssuer              string        `env:"JWT_ISSUER" envDefault:"messenger-auth"`
+	JWTAccessTokenTTL      time.Duration `env:"JWT_ACCESS_TOKEN_TTL" envDefault:"15m"`
+	JWTKeyRotationInterval time.Duration `env:"JWT_KEY_ROTATION_INTERVAL" envDefault:"24h"`
+	RefreshTokenTTL        time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	MaxSessionsPerUser     int64         `env:"MAX_SESSIONS_PER_USER" envDefault:"10"` // 0 - без ограничения, при превышении завершается самая старая сессия
+
+	// Защита входа по паролю от перебора (0 в Max* отключает соответствующую проверку)
+	LoginMaxAccountFailures  int64         `env:"LOGIN_MAX_ACCOUNT_FAILURES" envDefault:"20"`
+	LoginMaxIPFailures       int64         `env:"LOGIN_MAX_IP_FAILURES" envDefault:"100"`
+	LoginMaxPairFailures     int64         `env:"LOGIN_MAX_PAIR_FAILURES" envDefault:"5"`
+	LoginFailureWindow       time.Duration `env:"LOGIN_FAILURE_WINDOW" envDefault:"15m"`
+	LoginMaxAccountsPerIP    int64         `env:"LOGIN_MAX_ACCOUNTS_PER_IP" envDefault:"20"`
+	LoginAccountsPerIPWindow time.Duration `env:"LOGIN_ACCOUNTS_PER_IP_WINDOW" envDefault:"1h"`
+	LoginLockoutBase         time.Duration `env:"LOGIN_LOCKOUT_BASE" envDefault:"1m"`
+	LoginLockoutMax          time.Duration `env:"LOGIN_LOCKOUT_MAX" envDefault:"1h"`
+	LoginLockoutResetAfter   time.Duration `env:"LOGIN_LOCKOUT_RESET_AFTER" envDefault:"24h"`
+
+	// Ограничение частоты запросов по умолчанию: запросов за RateLimitPeriod для каждого вида клиента (0 - без ограничения).
+	// Ограничения отдельных маршрутов задаются в JSON файле RATE_LIMITS_FILE (config.RouteRateLimits)
+	RateLimitsFile     string        `env:"RATE_LIMITS_FILE" envDefault:""`
+	RateLimitPeriod    time.Duration `env:"RATE_LIMIT_PERIOD" envDefault:"1m"`
+	RateLimitAnonymous int64         `env:"RATE_LIMIT_ANONYMOUS" envDefault:"60"`
+	RateLimitUser      int64         `env:"RATE_LIMIT_USER" envDefault:"300"`
+	RateLimitService   int64         `env:"RATE_LIMIT_SERVICE" envDefault:"6000"`
+	// Поиск контактов ограничивается строже остальных запросов пользователя, чтобы затруднить перебор.
+	// Ограничение из RATE_LIMITS_FILE для маршрута поиска имеет приоритет
+	RateLimitUserSearch int64 `env:"RATE_LIMIT_USER_SEARCH" envDefault:"30"`
+
+	// Устаревание /user/api/v1 в пользу /user/api/v2 (даты в RFC 3339), объявляется заголовками Deprecation и Sunset
+	UserAPIV1DeprecatedAt string `env:"USER_API_V1_DEPRECATED_AT" envDefault:"2026-10-18T00:00:00Z"`
+	UserAPIV1Sunset       string `env:"USER_API_V1_SUNSET" envDefault:"2027-04-18T00:00:00Z"`
+
+	// Хеширование паролей: argon2id или bcrypt. Хеши другого алгоритма или с другими параметрами пересчитываются при входе
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM" envDefault:"argon2id"`
+	Argon2Memory          uint   `env:"ARGON2_MEMORY" envDefault:"65536"` // KiB
+	Argon2Iterations      uint   `env:"ARGON2_ITERATIONS" envDefault:"3"`
+	Argon2Parallelism     uint   `env:"ARGON2_PARALLELISM" envDefault:"2"`
+	BcryptCost            int    `env:"BCRYPT_COST" envDefault:"12"`
+	PasswordPepperFile    string `env:"PASSWORD_PEPPER_FILE" envDefault:""` // Файл с секретом (docker secret), пусто - без pepper
+
+	// Политика паролей. PASSWORD_BANNED_WORDS_FILE - запрещенные слова по одному в строке,
+	// PASSWORD_BREACHED_CORPUS_FILE - отсортированные SHA-1 хеши утекших паролей в формате Pwned Passwords ("HASH:COUNT")
+	PasswordMinLength          int    `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	PasswordMinStrength        int    `env:"PASSWORD_MIN_STRENGTH" envDefault:"2"` // 0-4, 0 - не проверять
+	PasswordBannedWordsFile    string `env:"PASSWORD_BANNED_WORDS_FILE" envDefault:""`
+	PasswordBreachedCorpusFile string `env:"PASSWORD_BREACHED_CORPUS_FILE" envDefault:""`
+	PasswordBreachedMinCount   int64  `env:"PASSWORD_BREACHED_MIN_COUNT" envDefault:"1"`
+
+	DefaultRole string `env:"DEFAULT_ROLE" envDefault:"user"`
+
+	PasswordResetURL string        `env:"PASSWORD_RESET_URL" envDefault:"http://localhost/reset-password"`
+	PasswordResetTTL time.Duration `env:"PASSWORD_RESET_TTL" envDefault:"30m"`
+
+	EmailVerificationMode         string        `env:"EMAIL_VERIFICATION_MODE" envDefault:"off"` // off, block или restrict
+	EmailVerificationSecret       string        `env:"EMAIL_VERIFICATION_SECRET" envDefault:""`  // Ключ подписи ссылок подтверждения, не короче 32 байт
+	EmailVerificationURL          string        `env:"EMAIL_VERIFICATION_URL" envDefault:"http://localhost/verify-email"`
+	EmailVerificationTTL          time.Duration `env:"EMAIL_VERIFICATION_TTL" envDefault:"24h"`
+	EmailVerificationResendLimit  int64         `env:"EMAIL_VERIFICATION_RESEND_LIMIT" envDefault:"3"`
+	EmailVerificationResendWindow time.Duration `env:"EMAIL_VERIFICATION_RESEND_WINDOW" envDefault:"1h"`
+
+	TOTPEncryptionKey   string        `env:"TOTP_ENCRYPTION_KEY" envDefault:""` // 32 байта в base64 для шифрования секретов TOTP
+	TOTPIssuer          string        `env:"TOTP_ISSUER" envDefault:"Messenger"`
+	MFATokenTTL         time.Duration `env:"MFA_TOKEN_TTL" envDefault:"5m"`
+	MFATokenMaxAttempts int64         `env:"MFA_TOKEN_MAX_ATTEMPTS" envDefault:"5"`
+
+	WebAuthnRPID         string        `env:"WEBAUTHN_RP_ID" envDefault:"localhost"`
+	WebAuthnRPName       string        `env:"WEBAUTHN_RP_NAME" envDefault:"Messenger"`
+	WebAuthnOrigins      []string      `env:"WEBAUTHN_ORIGINS" envSeparator:"," envDefault:"http://localhost"`
+	WebAuthnChallengeTTL time.Duration `env:"WEBAUTHN_CHALLENGE_TTL" envDefault:"5m"`
+	// Ключ для фиктивных ключей доступа, которые возвращаются для неизвестных логинов, не короче 32 байт
+	WebAuthnCredentialSecret string `env:"WEBAUTHN_CREDENTIAL_SECRET" envDefault:""`
+
+	OAuthCodeTTL time.Duration `env:"OAUTH_CODE_TTL" envDefault:"1m"`
+	OIDCIssuer   string        `env:"OIDC_ISSUER" envDefault:"http://localhost"` // Публичный адрес сервиса, от него строятся адреса в discovery
+
+	ExternalOIDCProvidersFile string        `env:"EXTERNAL_OIDC_PROVIDERS_FILE" envDefault:""` // JSON файл со списком внешних провайдеров (config.OIDCProvider)
+	ExternalLoginStateTTL     time.Duration `env:"EXTERNAL_LOGIN_STATE_TTL" envDefault:"10m"`
+
+	Mailer        string `env:"MAILER" envDefault:"outbox"`
+	MailFrom      string `env:"MAIL_FROM" envDefault:"no-reply@messenger.local"`
+	MailOutboxDir string `env:"MAIL_OUTBOX_DIR" envDefault:"./data/outbox"`
+	SMTPHost      string `env:"SMTP_HOST" envDefault:"localhost"`
+	SMTPPort      string `env:"SMTP_PORT" envDefault:"25"`
+	SMTPUsername  string `env:"SMTP_USERNAME" envDefault:""`
+	SMTPPassword  string `env:"SMTP_PASSWORD" envDefault:""`
 }
 
 func NewAppConfig() *Config {
@@ -30,6 +121,7 @@ func NewAppConfig() *Config {
 		slog.Error("Failed to process env var", slog.String("error", err.Error()))
 		return nil
 	}
-	fmt.Printf("Loaded config: %+v\n", AppConfig)
+	// Конфигурация содержит секреты (пароли, ключи), поэтому целиком в лог не выводится
+	slog.Info("Config was loaded", slog.String("service_address", AppConfig.ServiceAddress), slog.String("log_level", AppConfig.LogLevel))
 	return &AppConfig
 }
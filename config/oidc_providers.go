@@ -0,0 +1,44 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// OIDCProvider - внешний провайдер OpenID Connect (Google, GitLab и т.д.), через который можно войти в сервис
+type OIDCProvider struct {
+	Name         string   `json:"name"`         // Имя провайдера в адресах /auth/api/v1/external/:provider
+	Issuer       string   `json:"issuer"`       // Issuer провайдера, по нему загружается discovery и JWKS
+	ClientID     string   `json:"clientId"`     // Идентификатор клиента, зарегистрированного у провайдера
+	ClientSecret string   `json:"clientSecret"` // Секрет клиента
+	RedirectURL  string   `json:"redirectUrl"`  // Адрес возврата (по умолчанию OIDC_ISSUER/auth/api/v1/external/:provider/callback)
+	Scopes       []string `json:"scopes"`       // Дополнительные scope (openid запрашивается всегда)
+}
+
+// LoadOIDCProviders читает список провайдеров из JSON файла EXTERNAL_OIDC_PROVIDERS_FILE.
+// Переменные окружения в файле подставляются (${GOOGLE_CLIENT_SECRET}), чтобы не хранить секреты в файле
+func LoadOIDCProviders(path string) ([]OIDCProvider, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var providers []OIDCProvider
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &providers); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		if provider.Name == "" || provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q: name, issuer and clientId are required", provider.Name)
+		}
+		if names[provider.Name] {
+			return nil, fmt.Errorf("oidc provider %q is declared twice", provider.Name)
+		}
+		names[provider.Name] = true
+	}
+	return providers, nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RateLimit - token bucket: Requests запросов за Period, не больше Burst подряд (по умолчанию Burst = Requests).
+// Requests <= 0 снимает ограничение
+type RateLimit struct {
+	Requests int64    `json:"requests"`
+	Period   Duration `json:"period"`
+	Burst    int64    `json:"burst"`
+}
+
+// RouteRateLimits - ограничения маршрута для каждого вида клиента: anonymous (по IP), user, service (gRPC)
+type RouteRateLimits map[string]RateLimit
+
+// Duration - time.Duration, который в JSON записывается строкой ("1m", "30s")
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// LoadRateLimits читает ограничения отдельных маршрутов из JSON файла RATE_LIMITS_FILE.
+// Ключ - маршрут ("GET /user/api/v1/") или полное имя метода gRPC ("/authrpc.AuthService/GetUsersById")
+func LoadRateLimits(path string) (map[string]RouteRateLimits, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes map[string]RouteRateLimits
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, err
+	}
+	for route, limits := range routes {
+		for principal, limit := range limits {
+			if limit.Requests > 0 && limit.Period <= 0 {
+				return nil, fmt.Errorf("rate limit %q for %s: period is required", route, principal)
+			}
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Минимальная длина токена сервиса, короче которого токен можно подобрать
+const minServiceTokenLength = 32
+
+// ServiceToken - токен, которым внутренний сервис мессенджера подтверждает себя при вызовах gRPC
+type ServiceToken struct {
+	Name  string `json:"name"`  // Имя сервиса (используется в логах и как ключ ограничения частоты запросов)
+	Token string `json:"token"` // Секрет, передается в метаданных authorization: Bearer <token>
+}
+
+// LoadServiceTokens читает токены сервисов из JSON файла GRPC_SERVICE_TOKENS_FILE и возвращает имена сервисов по токенам.
+// Переменные окружения в файле подставляются (${CHAT_SERVICE_TOKEN}), чтобы не хранить секреты в файле
+func LoadServiceTokens(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []ServiceToken
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &tokens); err != nil {
+		return nil, err
+	}
+	services := make(map[string]string, len(tokens))
+	names := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if token.Name == "" {
+			return nil, fmt.Errorf("service token: name is required")
+		}
+		if len(token.Token) < minServiceTokenLength {
+			return nil, fmt.Errorf("service token %q: token must be at least %d bytes", token.Name, minServiceTokenLength)
+		}
+		if names[token.Name] || services[token.Token] != "" {
+			return nil, fmt.Errorf("service token %q is declared twice", token.Name)
+		}
+		names[token.Name] = true
+		services[token.Token] = token.Name
+	}
+	return services, nil
+}
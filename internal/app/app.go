@@ -1,30 +1,78 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
 	"messenger-auth/config"
+	"messenger-auth/internal/mailer"
+	"messenger-auth/internal/middleware"
 	"messenger-auth/internal/repositories"
 	"messenger-auth/internal/services"
+	"messenger-auth/pkg/authrpc"
 )
 
 type App struct {
 	Log           *slog.Logger
 	Router        *gin.Engine
+	GRPCServer    *grpc.Server
 	Database      *gorm.DB
 	RedisDatabase *redis.Client
 
-	UserRepo repositories.UserRepository
+	UserRepo       repositories.UserRepository
+	SigningKeyRepo repositories.SigningKeyRepository
+	RefreshRepo    repositories.RefreshTokenRepository
+	RoleRepo       repositories.RoleRepository
+	ResetRepo      repositories.PasswordResetRepository
+	CounterRepo    repositories.CounterRepository
+	KeyValueRepo   repositories.KeyValueRepository
+	RecoveryRepo   repositories.RecoveryCodeRepository
+	WebAuthnRepo   repositories.WebAuthnCredentialRepository
+	OAuthRepo      repositories.OAuthClientRepository
+	IdentityRepo   repositories.ExternalIdentityRepository
+	SessionRepo    repositories.SessionRepository
+	DenylistRepo   repositories.TokenDenylistRepository
+	AttemptRepo    repositories.LoginAttemptRepository
+	RateLimitRepo  repositories.RateLimitRepository
 
-	UserService services.UserService
+	UserService              services.UserService
+	AuthService              services.AuthService
+	TokenService             services.TokenService
+	RoleService              services.RoleService
+	PasswordService          services.PasswordService
+	EmailVerificationService services.EmailVerificationService
+	TwoFactorService         services.TwoFactorService
+	WebAuthnService          services.WebAuthnService
+	OAuthService             services.OAuthService
+	OIDCService              services.OIDCService
+	ExternalAuthService      services.ExternalAuthService
+	SessionService           services.SessionService
+	LoginThrottleService     services.LoginThrottleService
+	RateLimitService         services.RateLimitService
+
+	Mailer         mailer.Mailer
+	PasswordHasher services.PasswordHasher
+	PasswordPolicy services.PasswordPolicyService
 
 	Config *config.Config
 }
 
+// Минимальная длина секретов EMAIL_VERIFICATION_SECRET и WEBAUTHN_CREDENTIAL_SECRET
+const minSecretLength = 32
+
+// userSearchRoute - ключ ограничения частоты запросов для поиска контактов (см. middleware.RateLimit)
+const userSearchRoute = "GET /user/api/v2/search"
+
 func NewApp(log *slog.Logger, config *config.Config) *App {
 	return &App{
 		Log:    log,
@@ -38,16 +86,38 @@ func (a *App) Initialize() {
 	a.setupRepositories()
 	a.setupServices()
 	a.setupHandlersAndRoutes()
+	a.setupGRPC()
 }
 func (a *App) Run() {
+	a.TokenService.StartKeyRotation(context.Background())
+
+	// gRPC сервер работает параллельно с HTTP роутером
+	listener, err := net.Listen("tcp", a.Config.AuthServiceGRPCAddress)
+	if err != nil {
+		a.Log.Error("Failed to listen gRPC address", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	go func() {
+		if err := a.GRPCServer.Serve(listener); err != nil {
+			a.Log.Error("Failed to run gRPC server", slog.String("error", err.Error()))
+		}
+	}()
+
 	if err := a.Router.Run(a.Config.ServiceAddress); err != nil {
 		a.Log.Error("Failed to run user service", slog.String("error", err.Error()))
 	}
+	a.GRPCServer.GracefulStop()
 }
 
 func (a *App) setupRouter() {
 	r := gin.Default()
 	r.Use(gin.Recovery())
+	// Без списка gin доверяет X-Forwarded-For от любого адреса, и клиент мог бы подменить свой IP
+	// в ограничении частоты запросов и журналах
+	if err := r.SetTrustedProxies(a.Config.TrustedProxies); err != nil {
+		a.Log.Error(fmt.Sprintf("Failed to set trusted proxies. Error: %s", err.Error()))
+		os.Exit(1)
+	}
 	a.Router = r
 }
 
@@ -81,13 +151,276 @@ func (a *App) setupRepositories() {
 			repoLogger,
 		)
 	}
-	
+	a.SigningKeyRepo = repositories.NewSigningKeyRepoPostgres(a.Database, a.Log.With(slog.String("service", "token"), slog.String("module", "repository")))
+	a.RoleRepo = repositories.NewRoleRepoPostgres(a.Database, a.Log.With(slog.String("service", "role"), slog.String("module", "repository")))
+	a.RefreshRepo = repositories.NewRefreshTokenRepoPostgres(a.Database, a.Log.With(slog.String("service", "auth"), slog.String("module", "repository")))
+	a.SessionRepo = repositories.NewSessionRepoPostgres(a.Database, a.Log.With(slog.String("service", "session"), slog.String("module", "repository")))
+	a.ResetRepo = repositories.NewPasswordResetRepoPostgres(a.Database, a.Log.With(slog.String("service", "password"), slog.String("module", "repository")))
+	a.CounterRepo = repositories.NewCounterRepoRedis(a.RedisDatabase)
+	a.KeyValueRepo = repositories.NewKeyValueRepoRedis(a.RedisDatabase)
+	a.DenylistRepo = repositories.NewTokenDenylistRepoRedis(a.RedisDatabase)
+	a.AttemptRepo = repositories.NewLoginAttemptRepoRedis(a.RedisDatabase)
+	a.RateLimitRepo = repositories.NewRateLimitRepoRedis(a.RedisDatabase)
+	a.RecoveryRepo = repositories.NewRecoveryCodeRepoPostgres(a.Database, a.Log.With(slog.String("service", "two_factor"), slog.String("module", "repository")))
+	a.WebAuthnRepo = repositories.NewWebAuthnCredentialRepoPostgres(a.Database, a.Log.With(slog.String("service", "webauthn"), slog.String("module", "repository")))
+	a.OAuthRepo = repositories.NewOAuthClientRepoPostgres(a.Database, a.Log.With(slog.String("service", "oauth"), slog.String("module", "repository")))
+	a.IdentityRepo = repositories.NewExternalIdentityRepoPostgres(a.Database, a.Log.With(slog.String("service", "external_auth"), slog.String("module", "repository")))
 }
 
 func (a *App) setupServices() {
-	a.UserService = services.NewUserServiceGORM(a.UserRepo, a.Log.With(slog.String("service", "user"), slog.String("module", "service")))
+	mail, err := mailer.NewMailer(a.Config, a.Log.With(slog.String("service", "mailer"), slog.String("module", "mailer")))
+	if err != nil {
+		a.Log.Error("Failed to configure mailer", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	a.Mailer = mail
+
+	switch a.Config.EmailVerificationMode {
+	case services.EmailVerificationOff, services.EmailVerificationBlock, services.EmailVerificationRestrict:
+	default:
+		a.Log.Error("Unknown EMAIL_VERIFICATION_MODE", slog.String("mode", a.Config.EmailVerificationMode))
+		os.Exit(1)
+	}
+	// Ссылками подтверждения меняется почта аккаунта, поэтому ключ их подписи должен быть задан и достаточно длинным
+	if len(a.Config.EmailVerificationSecret) < minSecretLength {
+		a.Log.Error(fmt.Sprintf("EMAIL_VERIFICATION_SECRET must be at least %d bytes", minSecretLength))
+		os.Exit(1)
+	}
+
+	a.EmailVerificationService = services.NewEmailVerificationServiceGORM(
+		a.UserRepo,
+		a.CounterRepo,
+		a.Mailer,
+		a.Config.EmailVerificationSecret,
+		a.Config.EmailVerificationURL,
+		a.Config.EmailVerificationTTL,
+		a.Config.EmailVerificationResendLimit,
+		a.Config.EmailVerificationResendWindow,
+		a.Log.With(slog.String("service", "email"), slog.String("module", "service")),
+	)
+	a.TokenService = services.NewTokenServiceJWT(
+		a.SigningKeyRepo,
+		a.Config.JWTIssuer,
+		a.Config.JWTAccessTokenTTL,
+		a.Config.JWTKeyRotationInterval,
+		a.Log.With(slog.String("service", "token"), slog.String("module", "service")),
+	)
+	if err := a.TokenService.RotateKeys(); err != nil {
+		a.Log.Error("Failed to load signing keys", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	var pepper []byte
+	if a.Config.PasswordPepperFile != "" {
+		pepper, err = os.ReadFile(a.Config.PasswordPepperFile)
+		if err != nil {
+			a.Log.Error("Failed to read password pepper", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		pepper = bytes.TrimSpace(pepper)
+	}
+	a.PasswordHasher, err = services.NewPasswordHasher(
+		a.Config.PasswordHashAlgorithm,
+		&services.Argon2idHasher{
+			Memory:      uint32(a.Config.Argon2Memory),
+			Iterations:  uint32(a.Config.Argon2Iterations),
+			Parallelism: uint8(a.Config.Argon2Parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+			Pepper:      pepper,
+		},
+		&services.BcryptHasher{Cost: a.Config.BcryptCost, Pepper: pepper},
+	)
+	if err != nil {
+		a.Log.Error("Failed to configure password hashing", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	bannedWords, err := services.LoadBannedWords(a.Config.PasswordBannedWordsFile)
+	if err != nil {
+		a.Log.Error("Failed to load banned password words", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	breachCorpus, err := services.LoadBreachCorpus(a.Config.PasswordBreachedCorpusFile, a.Config.PasswordBreachedMinCount)
+	if err != nil {
+		a.Log.Error("Failed to open breached password corpus", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	a.PasswordPolicy = services.NewPasswordPolicyServiceLocal(
+		a.Config.PasswordMinLength,
+		a.Config.PasswordMinStrength,
+		bannedWords,
+		breachCorpus,
+		a.Log.With(slog.String("service", "password_policy"), slog.String("module", "service")),
+	)
+	a.RoleService = services.NewRoleServiceGORM(a.RoleRepo, a.UserRepo, a.Config.DefaultRole, a.Log.With(slog.String("service", "role"), slog.String("module", "service")))
+	// Секреты TOTP шифруются AES-256, поэтому ключ обязателен и должен быть ровно 32 байта
+	totpKey, err := base64.StdEncoding.DecodeString(a.Config.TOTPEncryptionKey)
+	if err != nil || len(totpKey) != 32 {
+		a.Log.Error("TOTP_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+		os.Exit(1)
+	}
+	a.TwoFactorService = services.NewTwoFactorServiceGORM(
+		a.UserRepo,
+		a.RecoveryRepo,
+		totpKey,
+		a.Config.TOTPIssuer,
+		a.Log.With(slog.String("service", "two_factor"), slog.String("module", "service")),
+	)
+	a.SessionService = services.NewSessionServiceGORM(
+		a.SessionRepo,
+		a.RefreshRepo,
+		a.DenylistRepo,
+		a.Config.JWTAccessTokenTTL,
+		a.Config.MaxSessionsPerUser,
+		a.Log.With(slog.String("service", "session"), slog.String("module", "service")),
+	)
+	a.UserService = services.NewUserServiceGORM(a.UserRepo, a.EmailVerificationService, a.SessionService, a.RoleService, a.PasswordHasher, a.PasswordPolicy, a.Log.With(slog.String("service", "user"), slog.String("module", "service")))
+	a.LoginThrottleService = services.NewLoginThrottleServiceRedis(
+		a.AttemptRepo,
+		services.LoginThrottleLimits{
+			MaxAccountFailures:  a.Config.LoginMaxAccountFailures,
+			MaxIPFailures:       a.Config.LoginMaxIPFailures,
+			MaxPairFailures:     a.Config.LoginMaxPairFailures,
+			FailureWindow:       a.Config.LoginFailureWindow,
+			MaxAccountsPerIP:    a.Config.LoginMaxAccountsPerIP,
+			AccountsPerIPWindow: a.Config.LoginAccountsPerIPWindow,
+			LockoutBase:         a.Config.LoginLockoutBase,
+			LockoutMax:          a.Config.LoginLockoutMax,
+			LockoutResetAfter:   a.Config.LoginLockoutResetAfter,
+		},
+		a.Log.With(slog.String("service", "login_throttle"), slog.String("module", "service")),
+	)
+	a.AuthService = services.NewAuthServiceGORM(
+		a.UserRepo,
+		a.RefreshRepo,
+		a.SessionService,
+		a.LoginThrottleService,
+		a.TokenService,
+		a.RoleService,
+		a.PasswordHasher,
+		a.PasswordPolicy,
+		a.EmailVerificationService,
+		a.TwoFactorService,
+		a.KeyValueRepo,
+		a.CounterRepo,
+		a.DenylistRepo,
+		a.Config.RefreshTokenTTL,
+		a.Config.EmailVerificationMode,
+		a.Config.MFATokenTTL,
+		a.Config.MFATokenMaxAttempts,
+		a.Log.With(slog.String("service", "auth"), slog.String("module", "service")),
+	)
+	if len(a.Config.WebAuthnCredentialSecret) < minSecretLength {
+		a.Log.Error(fmt.Sprintf("WEBAUTHN_CREDENTIAL_SECRET must be at least %d bytes", minSecretLength))
+		os.Exit(1)
+	}
+	a.WebAuthnService = services.NewWebAuthnServiceGORM(
+		a.UserRepo,
+		a.WebAuthnRepo,
+		a.KeyValueRepo,
+		a.Config.WebAuthnRPID,
+		a.Config.WebAuthnRPName,
+		a.Config.WebAuthnOrigins,
+		a.Config.WebAuthnChallengeTTL,
+		[]byte(a.Config.WebAuthnCredentialSecret),
+		a.Log.With(slog.String("service", "webauthn"), slog.String("module", "service")),
+	)
+	a.OIDCService = services.NewOIDCServiceGORM(
+		a.UserRepo,
+		a.TokenService,
+		a.Config.OIDCIssuer,
+		a.Log.With(slog.String("service", "oidc"), slog.String("module", "service")),
+	)
+	a.OAuthService = services.NewOAuthServiceGORM(
+		a.OAuthRepo,
+		a.UserRepo,
+		a.KeyValueRepo,
+		a.AuthService,
+		a.OIDCService,
+		a.Config.OAuthCodeTTL,
+		a.Log.With(slog.String("service", "oauth"), slog.String("module", "service")),
+	)
+	externalProviders, err := config.LoadOIDCProviders(a.Config.ExternalOIDCProvidersFile)
+	if err != nil {
+		a.Log.Error("Failed to load external oidc providers", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	a.ExternalAuthService = services.NewExternalAuthServiceGORM(
+		a.UserRepo,
+		a.IdentityRepo,
+		a.KeyValueRepo,
+		a.AuthService,
+		a.RoleService,
+		a.PasswordHasher,
+		externalProviders,
+		a.Config.OIDCIssuer,
+		a.Config.ExternalLoginStateTTL,
+		a.Log.With(slog.String("service", "external_auth"), slog.String("module", "service")),
+	)
+	routeRateLimits, err := config.LoadRateLimits(a.Config.RateLimitsFile)
+	if err != nil {
+		a.Log.Error("Failed to load rate limits", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if _, ok := routeRateLimits[userSearchRoute]; !ok {
+		if routeRateLimits == nil {
+			routeRateLimits = map[string]config.RouteRateLimits{}
+		}
+		routeRateLimits[userSearchRoute] = config.RouteRateLimits{
+			services.PrincipalUser: {Requests: a.Config.RateLimitUserSearch, Period: config.Duration(a.Config.RateLimitPeriod)},
+		}
+	}
+	a.RateLimitService = services.NewRateLimitServiceRedis(
+		a.RateLimitRepo,
+		repositories.NewRateLimitRepoMemory(),
+		map[string]config.RateLimit{
+			services.PrincipalAnonymous: {Requests: a.Config.RateLimitAnonymous, Period: config.Duration(a.Config.RateLimitPeriod)},
+			services.PrincipalUser:      {Requests: a.Config.RateLimitUser, Period: config.Duration(a.Config.RateLimitPeriod)},
+			services.PrincipalService:   {Requests: a.Config.RateLimitService, Period: config.Duration(a.Config.RateLimitPeriod)},
+		},
+		routeRateLimits,
+		a.Log.With(slog.String("service", "rate_limit"), slog.String("module", "service")),
+	)
+	a.PasswordService = services.NewPasswordServiceGORM(
+		a.UserRepo,
+		a.ResetRepo,
+		a.SessionService,
+		a.PasswordHasher,
+		a.PasswordPolicy,
+		a.Mailer,
+		a.Config.PasswordResetURL,
+		a.Config.PasswordResetTTL,
+		a.Log.With(slog.String("service", "password"), slog.String("module", "service")),
+	)
 }
 
 func (a *App) setupHandlersAndRoutes() {
-	SetupHandlers(a.Router, a.UserService, a.Log.With(slog.String("service", "user"), slog.String("module", "transport")))
+	if a.Config.AuthEnabled != "true" {
+		a.Log.Warn("Authentication is disabled (AUTH_ENABLED=false), do not use this mode outside local development")
+	}
+	userAPIV1DeprecatedAt, err := time.Parse(time.RFC3339, a.Config.UserAPIV1DeprecatedAt)
+	if err != nil {
+		a.Log.Error("Failed to parse USER_API_V1_DEPRECATED_AT", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	userAPIV1Sunset, err := time.Parse(time.RFC3339, a.Config.UserAPIV1Sunset)
+	if err != nil {
+		a.Log.Error("Failed to parse USER_API_V1_SUNSET", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	SetupHandlers(a.Router, a.UserService, a.AuthService, a.TokenService, a.RoleService, a.PasswordService, a.EmailVerificationService, a.TwoFactorService, a.WebAuthnService, a.OAuthService, a.OIDCService, a.ExternalAuthService, a.SessionService, a.LoginThrottleService, a.RateLimitService, userAPIV1DeprecatedAt, userAPIV1Sunset, a.Config.AuthEnabled == "true", a.Log.With(slog.String("service", "user"), slog.String("module", "transport")))
+}
+
+func (a *App) setupGRPC() {
+	serviceTokens, err := config.LoadServiceTokens(a.Config.GRPCServiceTokensFile)
+	if err != nil {
+		a.Log.Error("Failed to load gRPC service tokens", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// Данные пользователей (в том числе email) отдаются только сервисам, подтвердившим себя токеном
+	protected := []string{authrpc.AuthService_GetUsersById_FullMethodName}
+	a.GRPCServer = grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.ServiceAuthInterceptor(serviceTokens, protected, a.Log.With(slog.String("service", "service_auth"), slog.String("module", "grpc"))),
+		middleware.RateLimitInterceptor(a.RateLimitService, a.Log.With(slog.String("service", "rate_limit"), slog.String("module", "grpc"))),
+	))
+	SetupGRPCServices(a.GRPCServer, a.AuthService, a.UserService, a.Log.With(slog.String("service", "auth"), slog.String("module", "grpc")))
 }
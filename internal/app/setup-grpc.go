@@ -0,0 +1,14 @@
+package app
+
+import (
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"messenger-auth/internal/controllers"
+	"messenger-auth/internal/services"
+	"messenger-auth/pkg/authrpc"
+)
+
+func SetupGRPCServices(s *grpc.Server, authService services.AuthService, userService services.UserService, log *slog.Logger) {
+	authrpc.RegisterAuthServiceServer(s, &controllers.AuthGRPCServer{AuthService: authService, UserService: userService, Log: log})
+}
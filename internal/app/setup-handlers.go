@@ -2,16 +2,32 @@ package app
 
 import (
 	"log/slog"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"messenger-auth/internal/controllers"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/models"
 	"messenger-auth/internal/services"
 )
 
-func SetupHandlers(r *gin.Engine, userService services.UserService, log *slog.Logger) {
-	userHandler := &controllers.UserHandler{Service: userService, Log: log}
+func SetupHandlers(r *gin.Engine, userService services.UserService, authService services.AuthService, tokenService services.TokenService, roleService services.RoleService, passwordService services.PasswordService, verificationService services.EmailVerificationService, twoFactorService services.TwoFactorService, webAuthnService services.WebAuthnService, oauthService services.OAuthService, oidcService services.OIDCService, externalAuthService services.ExternalAuthService, sessionService services.SessionService, loginThrottleService services.LoginThrottleService, rateLimitService services.RateLimitService, userAPIV1DeprecatedAt time.Time, userAPIV1Sunset time.Time, authEnabled bool, log *slog.Logger) {
+	userHandler := &controllers.UserHandler{Service: userService, Roles: roleService, Log: log}
+	userHandlerV2 := &controllers.UserHandlerV2{Service: userService, Log: log}
+	authHandler := &controllers.AuthHandler{Service: authService, Log: log}
+	tokenHandler := &controllers.TokenHandler{Service: tokenService, Log: log}
+	roleHandler := &controllers.RoleHandler{Service: roleService, Log: log}
+	passwordHandler := &controllers.PasswordHandler{Service: passwordService, Log: log}
+	emailHandler := &controllers.EmailHandler{Service: verificationService, Log: log}
+	twoFactorHandler := &controllers.TwoFactorHandler{Service: twoFactorService, Log: log}
+	webAuthnHandler := &controllers.WebAuthnHandler{Service: webAuthnService, AuthService: authService, Log: log}
+	oauthHandler := &controllers.OAuthHandler{Service: oauthService, Log: log}
+	oidcHandler := &controllers.OIDCHandler{Service: oidcService, Log: log}
+	externalAuthHandler := &controllers.ExternalAuthHandler{Service: externalAuthService, Log: log}
+	sessionHandler := &controllers.SessionHandler{Service: sessionService, Log: log}
+	lockoutHandler := &controllers.LockoutHandler{Service: loginThrottleService, Log: log}
 
 	// Оповещение docker-compose о том, что контейнер готов к работе
 	r.GET("/health", func(c *gin.Context) {
@@ -20,14 +36,113 @@ func SetupHandlers(r *gin.Engine, userService services.UserService, log *slog.Lo
 		})
 	})
 	
-	v1 := r.Group("/user/api/v1")
+	authenticate := middleware.Authenticate(authService, authEnabled, log)
+	// Ограничение частоты запросов ставится после authenticate, чтобы считать запросы пользователя, а не IP
+	limit := middleware.RateLimit(rateLimitService, log)
+
+	// Проверки ролей объявляются для каждого маршрута отдельно
+	canReadUsers := middleware.RequirePermission(roleService, models.PermissionUsersRead, log)
+	isSelfOrCanReadUsers := middleware.RequireSelfOrPermission(roleService, "id", models.PermissionUsersRead, log)
+	canManageUsers := middleware.RequirePermission(roleService, models.PermissionUsersManage, log)
+	isSelfOrCanManageUsers := middleware.RequireSelfOrPermission(roleService, "id", models.PermissionUsersManage, log)
+	canManageRoles := middleware.RequirePermission(roleService, models.PermissionRolesManage, log)
+	canManageClients := middleware.RequirePermission(roleService, models.PermissionClientsManage, log)
+
+	// Ошибки проверки запроса называют поля так же, как в JSON
+	controllers.UseJSONFieldNames()
+
+	// CRUD пользователей v1 заменен на v2 и работает до даты USER_API_V1_SUNSET. Для ролей замены в v2 нет
+	deprecatedV1 := middleware.Deprecated(userAPIV1DeprecatedAt, userAPIV1Sunset, "/user/api/v2")
+
+	v1 := r.Group("/user/api/v1", authenticate, limit)
+	{
+		v1.POST("/", deprecatedV1, canManageUsers, userHandler.CreateUser)
+		v1.PUT("/:id", deprecatedV1, isSelfOrCanManageUsers, userHandler.UpdateUser)
+		v1.DELETE("/:id", deprecatedV1, isSelfOrCanManageUsers, userHandler.DeleteUser)
+		v1.GET("/:id", deprecatedV1, isSelfOrCanReadUsers, userHandler.GetUserByID)
+		v1.GET("/", deprecatedV1, canReadUsers, userHandler.GetUsers)
+		v1.PUT("/:id/roles/:roleId", canManageRoles, roleHandler.AssignRole)
+		v1.DELETE("/:id/roles/:roleId", canManageRoles, roleHandler.RevokeRole)
+	}
+
+	// Успешные ответы v2 приходят в конверте {"data": ...}, ошибки - в формате application/problem+json
+	v2 := r.Group("/user/api/v2", middleware.ProblemJSON(), authenticate, limit)
 	{
-		v1.POST("/", userHandler.CreateUser)
-		v1.PUT("/:id", userHandler.UpdateUser)
-		v1.DELETE("/:id", userHandler.DeleteUser)
-		v1.GET("/:id", userHandler.GetUserByID)
-		v1.GET("/", userHandler.GetUsers)
+		v2.POST("/", canManageUsers, userHandlerV2.CreateUser)
+		v2.PUT("/:id", isSelfOrCanManageUsers, userHandlerV2.UpdateUser)
+		v2.DELETE("/:id", isSelfOrCanManageUsers, userHandlerV2.DeleteUser)
+		// Поиск контактов намеренно доступен любому аутентифицированному пользователю, без users:read:
+		// он отдает только публичные поля и не находит тех, кто скрыл себя настройками приватности
+		v2.GET("/search", userHandlerV2.SearchUsers)
+		v2.GET("/:id", isSelfOrCanReadUsers, userHandlerV2.GetUserByID)
+		v2.GET("/", canReadUsers, userHandlerV2.GetUsers)
 	}
 
+	authV1 := r.Group("/auth/api/v1", limit)
+	{
+		authV1.POST("/register", authHandler.Register)
+		authV1.POST("/login", authHandler.Login)
+		authV1.POST("/login/2fa", authHandler.LoginTwoFactor)
+		authV1.POST("/refresh", authHandler.Refresh)
+		authV1.POST("/logout", authenticate, authHandler.Logout)
+		authV1.POST("/password/forgot", passwordHandler.ForgotPassword)
+		authV1.POST("/password/reset", passwordHandler.ResetPassword)
+		authV1.POST("/password/change", authenticate, passwordHandler.ChangePassword)
+		authV1.POST("/email/verify", emailHandler.VerifyEmail)
+		authV1.POST("/email/resend", emailHandler.ResendVerification)
+		authV1.GET("/roles", authenticate, canManageRoles, roleHandler.GetRoles)
+		authV1.DELETE("/lockouts", authenticate, canManageUsers, lockoutHandler.ClearLockout)
+	}
+
+	twoFactorV1 := r.Group("/auth/api/v1/2fa", authenticate, limit)
+	{
+		twoFactorV1.POST("/enroll", twoFactorHandler.Enroll)
+		twoFactorV1.POST("/confirm", twoFactorHandler.Confirm)
+		twoFactorV1.POST("/disable", twoFactorHandler.Disable)
+		twoFactorV1.POST("/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+	}
+
+	sessionsV1 := r.Group("/auth/api/v1/sessions", authenticate, limit)
+	{
+		sessionsV1.GET("", sessionHandler.GetSessions)
+		sessionsV1.DELETE("/:id", sessionHandler.RevokeSession)
+		sessionsV1.POST("/revoke-others", sessionHandler.RevokeOtherSessions)
+	}
+
+	webAuthnV1 := r.Group("/auth/api/v1/webauthn", limit)
+	{
+		webAuthnV1.POST("/register/begin", authenticate, webAuthnHandler.BeginRegistration)
+		webAuthnV1.POST("/register/finish", authenticate, webAuthnHandler.FinishRegistration)
+		webAuthnV1.POST("/login/begin", webAuthnHandler.BeginLogin)
+		webAuthnV1.POST("/login/finish", webAuthnHandler.FinishLogin)
+	}
+
+	// Вход через внешних провайдеров OpenID Connect
+	externalV1 := r.Group("/auth/api/v1/external", limit)
+	{
+		externalV1.GET("/providers", externalAuthHandler.GetProviders)
+		externalV1.GET("/:provider/login", externalAuthHandler.Begin)
+		externalV1.GET("/:provider/callback", externalAuthHandler.Callback)
+	}
+
+	// Сервер авторизации OAuth 2.0 (authorization code + PKCE)
+	oauth := r.Group("/oauth", limit)
+	{
+		oauth.GET("/authorize", authenticate, oauthHandler.Authorize)
+		oauth.POST("/authorize", authenticate, oauthHandler.Authorize)
+		oauth.POST("/token", oauthHandler.Token)
+		oauth.POST("/clients", authenticate, canManageClients, oauthHandler.CreateClient)
+		oauth.GET("/clients", authenticate, canManageClients, oauthHandler.GetClients)
+	}
+
+	// OpenID Connect поверх сервера авторизации OAuth
+	r.GET("/.well-known/openid-configuration", oidcHandler.Discovery)
+	// /userinfo - единственный эндпоинт для токенов клиентов OAuth, данные отдаются по scope токена
+	authenticateClient := middleware.AuthenticateClient(authService, authEnabled, log)
+	r.GET("/userinfo", authenticateClient, limit, oidcHandler.UserInfo)
+	r.POST("/userinfo", authenticateClient, limit, oidcHandler.UserInfo)
+
+	r.GET("/.well-known/jwks.json", tokenHandler.JWKS)
+
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 }
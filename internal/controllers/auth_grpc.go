@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/services"
+	"messenger-auth/internal/utils"
+	"messenger-auth/pkg/authrpc"
+)
+
+// AuthGRPCServer реализует authrpc.AuthService для других сервисов мессенджера
+type AuthGRPCServer struct {
+	authrpc.UnimplementedAuthServiceServer
+
+	AuthService services.AuthService
+	UserService services.UserService
+	Log         *slog.Logger
+}
+
+// Ограничение на число пользователей в одном запросе GetUsersById
+const maxUsersPerRequest = 1000
+
+func NewAuthGRPCServer(authService services.AuthService, userService services.UserService, log *slog.Logger) *AuthGRPCServer {
+	return &AuthGRPCServer{AuthService: authService, UserService: userService, Log: log}
+}
+
+func (s *AuthGRPCServer) VerifyToken(ctx context.Context, req *authrpc.VerifyTokenRequest) (*authrpc.VerifyTokenResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	claims, err := s.AuthService.VerifyAccessToken(req.GetToken())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTokenMalformed):
+			s.Log.Info("Failed to verify token. Error: token is malformed", slog.String("method", "VerifyToken"), slog.String("code", codes.InvalidArgument.String()))
+			return nil, status.Error(codes.InvalidArgument, services.ErrTokenMalformed.Error())
+		case errors.Is(err, services.ErrTokenExpired):
+			s.Log.Info("Failed to verify token. Error: token is expired", slog.String("method", "VerifyToken"), slog.String("code", codes.Unauthenticated.String()))
+			return nil, status.Error(codes.Unauthenticated, services.ErrTokenExpired.Error())
+		case errors.Is(err, services.ErrTokenThirdParty):
+			s.Log.Info("Failed to verify token. Error: token was issued to a third-party client", slog.String("method", "VerifyToken"), slog.String("code", codes.PermissionDenied.String()))
+			return nil, status.Error(codes.PermissionDenied, services.ErrTokenThirdParty.Error())
+		case errors.Is(err, services.ErrTokenRevoked):
+			s.Log.Info("Failed to verify token. Error: token is revoked", slog.String("method", "VerifyToken"), slog.String("code", codes.PermissionDenied.String()))
+			return nil, status.Error(codes.PermissionDenied, services.ErrTokenRevoked.Error())
+		}
+		s.Log.Error(fmt.Sprintf("Failed to verify token. Error: %s", err.Error()), slog.String("method", "VerifyToken"), slog.String("code", codes.Internal.String()))
+		return nil, status.Error(codes.Internal, "failed to verify token")
+	}
+
+	userID, _ := claims.UserID()
+	s.Log.Debug("Token was verified", slog.String("method", "VerifyToken"), slog.Uint64("user_id", uint64(userID)))
+
+	return &authrpc.VerifyTokenResponse{
+		UserId:      uint64(userID),
+		ServiceRole: uint64(claims.Role),
+	}, nil
+}
+
+// GetUsersById возвращает пользователей в порядке запрошенных ids, несуществующие ids пропускаются.
+// Метод доступен только сервисам с токеном (middleware.ServiceAuthInterceptor)
+func (s *AuthGRPCServer) GetUsersById(ctx context.Context, req *authrpc.GetUsersByIdRequest) (*authrpc.GetUsersByIdResponse, error) {
+	if len(req.GetIds()) > maxUsersPerRequest {
+		return nil, status.Errorf(codes.InvalidArgument, "too many ids, at most %d allowed", maxUsersPerRequest)
+	}
+
+	ids := make([]uint, len(req.GetIds()))
+	for i, id := range req.GetIds() {
+		ids[i] = uint(id)
+	}
+	users, err := s.UserService.GetUsersByIDs(ids)
+	if err != nil {
+		s.Log.Error(fmt.Sprintf("Failed to get users. Error: %s", err.Error()), slog.String("method", "GetUsersById"), slog.String("code", codes.Internal.String()))
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	resp := &authrpc.GetUsersByIdResponse{Users: make([]*authrpc.User, 0, len(users))}
+	for i := range users {
+		resp.Users = append(resp.Users, toRPCUser(&users[i]))
+	}
+	service, _ := middleware.CallingService(ctx)
+	s.Log.Debug("Users were received", slog.String("method", "GetUsersById"), slog.String("client", service), slog.Int("requested", len(ids)), slog.Int("found", len(users)))
+	return resp, nil
+}
+
+func toRPCUser(user *models.User) *authrpc.User {
+	return &authrpc.User{
+		Id:            uint64(user.ID),
+		Login:         utils.ValueOrZero(user.Login),
+		Email:         utils.ValueOrZero(user.Email),
+		FirstName:     utils.ValueOrZero(user.FirstName),
+		LastName:      utils.ValueOrZero(user.LastName),
+		ServiceRoleId: strconv.FormatUint(uint64(user.ServiceRoleID), 10),
+	}
+}
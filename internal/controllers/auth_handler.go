@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	Service services.AuthService
+	Log     *slog.Logger
+}
+
+func NewAuthHandler(authService services.AuthService, log *slog.Logger) *AuthHandler {
+	return &AuthHandler{Service: authService, Log: log}
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+	var registerDTO dto.RegisterData
+	if err := c.ShouldBindJSON(&registerDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to register user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.Register(&registerDTO); err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			h.Log.Info("Failed to register user. Error: password does not meet the policy", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to register user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", registerDTO.Login))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+
+	// Ответ одинаков для новых, занятых адресов и логинов: результат регистрации приходит письмом
+	h.Log.Info("Registration was requested", slog.String("method", c.Request.Method), slog.Int("code", http.StatusAccepted), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", registerDTO.Login))
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Check your inbox to complete the registration"})
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var loginDTO dto.LoginData
+	if err := c.ShouldBindJSON(&loginDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to login user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.Service.Login(&loginDTO, clientInfo(c))
+	if err != nil {
+		var tooMany *services.TooManyRequestsError
+		if errors.As(err, &tooMany) {
+			h.Log.Warn("Failed to login user. Error: too many attempts", slog.String("method", c.Request.Method), slog.Int("code", http.StatusTooManyRequests), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			h.Log.Info("Failed to login user. Error: invalid credentials", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			h.Log.Info("Failed to login user. Error: email is not verified", slog.String("method", c.Request.Method), slog.Int("code", http.StatusForbidden), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to login user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to login user"})
+		return
+	}
+
+	if result.MFARequired {
+		h.Log.Info("Second factor is required", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	h.Log.Info("User was logged in", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", loginDTO.Login))
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var loginDTO dto.TwoFactorLoginData
+	if err := c.ShouldBindJSON(&loginDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to login user with second factor. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.Service.LoginTwoFactor(loginDTO.MFAToken, loginDTO.Code, clientInfo(c))
+	if err != nil {
+		var tooMany *services.TooManyRequestsError
+		if errors.As(err, &tooMany) {
+			h.Log.Warn("Failed to login user with second factor. Error: too many attempts", slog.String("method", c.Request.Method), slog.Int("code", http.StatusTooManyRequests), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			h.Log.Info(fmt.Sprintf("Failed to login user with second factor. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to login user with second factor. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to login user"})
+		return
+	}
+
+	h.Log.Info("User was logged in with second factor", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var refreshDTO dto.RefreshData
+	if err := c.ShouldBindJSON(&refreshDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to refresh tokens. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.Service.Refresh(refreshDTO.RefreshToken, "", clientInfo(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			h.Log.Info("Failed to refresh tokens. Error: invalid refresh token", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to refresh tokens. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh tokens"})
+		return
+	}
+
+	h.Log.Info("Tokens were refreshed", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout завершает сессию текущего access токена и отзывает сам токен
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, ok := middleware.CurrentClaims(c)
+	if !ok {
+		h.Log.Info("Failed to logout. Error: no access token", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+		return
+	}
+
+	if err := h.Service.Logout(claims); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to logout. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	h.Log.Info("User logged out", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNoContent), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/services"
+	"messenger-auth/internal/testutil"
+)
+
+// Ответы регистрации, входа и восстановления пароля не должны выдавать, существует ли учетная запись:
+// для известных и неизвестных логинов и адресов сравниваются только код и тело ответа. Время ответа
+// эти тесты не проверяют
+
+type accountsTestServer struct {
+	router *gin.Engine
+	mail   *fakeMailer
+}
+
+func newAccountsTestServer(t *testing.T, mailFailing bool) *accountsTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := discardLogger()
+	hasher := &services.BcryptHasher{Cost: bcrypt.MinCost}
+	passwordHash, err := hasher.Hash("Tr0ub4dor&3-horse")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	login, email := "alice", "alice@messenger.test"
+	users := testutil.NewUserRepo()
+	if err := users.Create(&models.User{Login: &login, Name: &login, Email: &email, Password: &passwordHash}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	mail := newFakeMailer(mailFailing)
+
+	policy := services.NewPasswordPolicyServiceLocal(8, 0, nil, nil, log)
+	verifier := services.NewEmailVerificationServiceGORM(users, nil, mail, strings.Repeat("s", 32), "https://messenger.test/verify-email", time.Hour, 3, time.Hour, log)
+	authService := services.NewAuthServiceGORM(users, nil, nil, fakeThrottle{}, nil, &fakeRoleService{}, hasher, policy, verifier, nil, nil, nil, nil, time.Hour, services.EmailVerificationOff, time.Minute, 5, log)
+	passwordService := services.NewPasswordServiceGORM(users, &fakeResetRepo{}, nil, hasher, policy, mail, "https://messenger.test/reset-password", time.Hour, log)
+
+	router := gin.New()
+	authHandler := NewAuthHandler(authService, log)
+	passwordHandler := NewPasswordHandler(passwordService, log)
+	router.POST("/auth/api/v1/register", authHandler.Register)
+	router.POST("/auth/api/v1/login", authHandler.Login)
+	router.POST("/auth/api/v1/password/forgot", passwordHandler.ForgotPassword)
+	return &accountsTestServer{router: router, mail: mail}
+}
+
+func (server *accountsTestServer) post(path string, body string) (int, string) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(recorder, request)
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestRegisterDoesNotRevealAccounts(t *testing.T) {
+	for _, mailFailing := range []bool{false, true} {
+		server := newAccountsTestServer(t, mailFailing)
+		cases := []struct {
+			name    string
+			body    string
+			mailTo  string
+			subject string
+		}{
+			{"new account", `{"login":"bob","email":"bob@messenger.test","password":"correct-battery-staple"}`, "bob@messenger.test", "Confirm your email"},
+			{"registered email", `{"login":"carol","email":"alice@messenger.test","password":"correct-battery-staple"}`, "alice@messenger.test", "Your messenger account"},
+			{"taken login", `{"login":"alice","email":"dave@messenger.test","password":"correct-battery-staple"}`, "dave@messenger.test", "Complete your registration"},
+		}
+
+		var wantCode int
+		var wantBody string
+		for i, tc := range cases {
+			code, body := server.post("/auth/api/v1/register", tc.body)
+			if i == 0 {
+				wantCode, wantBody = code, body
+				if code != http.StatusAccepted {
+					t.Fatalf("mail failing %v, %s: code = %d, body = %s, want 202", mailFailing, tc.name, code, body)
+				}
+			} else if code != wantCode || body != wantBody {
+				t.Fatalf("mail failing %v, %s: got %d %s, want %d %s", mailFailing, tc.name, code, body, wantCode, wantBody)
+			}
+			if msg := server.mail.wait(t); msg.To != tc.mailTo || msg.Subject != tc.subject {
+				t.Fatalf("%s: email %q to %s, want %q to %s", tc.name, msg.Subject, msg.To, tc.subject, tc.mailTo)
+			}
+		}
+	}
+}
+
+func TestLoginDoesNotRevealAccounts(t *testing.T) {
+	server := newAccountsTestServer(t, false)
+
+	knownCode, knownBody := server.post("/auth/api/v1/login", `{"login":"alice","password":"wrong-password"}`)
+	unknownCode, unknownBody := server.post("/auth/api/v1/login", `{"login":"mallory","password":"wrong-password"}`)
+	if knownCode != http.StatusUnauthorized {
+		t.Fatalf("known login: code = %d, body = %s, want 401", knownCode, knownBody)
+	}
+	if unknownCode != knownCode || unknownBody != knownBody {
+		t.Fatalf("unknown login: got %d %s, want %d %s", unknownCode, unknownBody, knownCode, knownBody)
+	}
+}
+
+func TestForgotPasswordDoesNotRevealAccounts(t *testing.T) {
+	server := newAccountsTestServer(t, false)
+
+	knownCode, knownBody := server.post("/auth/api/v1/password/forgot", `{"email":"alice@messenger.test"}`)
+	if knownCode != http.StatusAccepted {
+		t.Fatalf("known email: code = %d, body = %s, want 202", knownCode, knownBody)
+	}
+	if msg := server.mail.wait(t); msg.To != "alice@messenger.test" || !strings.Contains(msg.Body, "reset-password?token=") {
+		t.Fatalf("email %q to %s, want a reset link to alice", msg.Subject, msg.To)
+	}
+
+	unknownCode, unknownBody := server.post("/auth/api/v1/password/forgot", `{"email":"mallory@messenger.test"}`)
+	if unknownCode != knownCode || unknownBody != knownBody {
+		t.Fatalf("unknown email: got %d %s, want %d %s", unknownCode, unknownBody, knownCode, knownBody)
+	}
+	server.mail.none(t)
+}
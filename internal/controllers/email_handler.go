@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailHandler struct {
+	Service services.EmailVerificationService
+	Log     *slog.Logger
+}
+
+func NewEmailHandler(verificationService services.EmailVerificationService, log *slog.Logger) *EmailHandler {
+	return &EmailHandler{Service: verificationService, Log: log}
+}
+
+func (h *EmailHandler) VerifyEmail(c *gin.Context) {
+	var verifyDTO dto.VerifyEmailData
+	if err := c.ShouldBindJSON(&verifyDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to verify email. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.VerifyEmail(verifyDTO.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			h.Log.Info("Failed to verify email. Error: invalid verification token", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to verify email. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	h.Log.Info("Email was verified", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification отвечает одинаково для известных и неизвестных адресов
+func (h *EmailHandler) ResendVerification(c *gin.Context) {
+	var resendDTO dto.ResendVerificationData
+	if err := c.ShouldBindJSON(&resendDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to resend verification email. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.ResendVerification(resendDTO.Email); err != nil {
+		var tooMany *services.TooManyRequestsError
+		if errors.As(err, &tooMany) {
+			h.Log.Info("Failed to resend verification email. Error: too many requests", slog.String("method", c.Request.Method), slog.Int("code", http.StatusTooManyRequests), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to resend verification email. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend verification email"})
+		return
+	}
+
+	h.Log.Info("Verification email was requested", slog.String("method", c.Request.Method), slog.Int("code", http.StatusAccepted), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered and not verified yet, a verification link has been sent"})
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"messenger-auth/internal/domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// requestError - ошибка разбора запроса (неверный JSON, параметр пути), на которую отвечаем 400
+type requestError struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func (e *requestError) Unwrap() error {
+	return e.Err
+}
+
+// UseJSONFieldNames настраивает валидатор gin так, чтобы в ошибках проверки поля назывались как в JSON, а не как в Go
+func UseJSONFieldNames() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+}
+
+// bindError оборачивает ошибку ShouldBindJSON: нарушенные правила binding становятся domain.ErrValidation
+// с перечнем полей, а тело, которое не удалось разобрать, - ошибкой запроса
+func bindError(err error) error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]domain.FieldError, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			fields = append(fields, domain.FieldError{Field: fieldErr.Field(), Rule: fieldErr.Tag(), Message: fieldMessage(fieldErr)})
+		}
+		return domain.InvalidFields(domain.CodeInvalidRequest, "request data is invalid", fields, err)
+	}
+	return &requestError{Code: domain.CodeMalformedRequest, Message: err.Error(), Err: err}
+}
+
+// fieldMessage описывает нарушенное правило binding для клиента
+func fieldMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fieldErr.Field())
+	case "email":
+		return fmt.Sprintf("%s must be a valid email", fieldErr.Field())
+	case "min":
+		return fmt.Sprintf("%s must be at least %s characters long", fieldErr.Field(), fieldErr.Param())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters long", fieldErr.Field(), fieldErr.Param())
+	default:
+		return fmt.Sprintf("%s is invalid", fieldErr.Field())
+	}
+}
+
+// classifiedError - ошибка, приведенная к виду, общему для всех форматов ответа
+type classifiedError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []domain.FieldError
+}
+
+// classifyError определяет HTTP статус, стабильный код и сообщение для ошибки. Ошибки, не относящиеся
+// к предметной области, становятся 500 без подробностей: сообщения БД клиенту не показываются
+func classifyError(err error) classifiedError {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return classifiedError{Status: http.StatusBadRequest, Code: reqErr.Code, Message: reqErr.Message}
+	}
+	var domainErr *domain.Error
+	if errors.As(err, &domainErr) {
+		return classifiedError{Status: errorStatus(domainErr.Kind), Code: domainErr.Code, Message: domainErr.Message, Fields: domainErr.Fields}
+	}
+	return classifiedError{Status: http.StatusInternalServerError, Code: domain.CodeInternal, Message: "internal server error"}
+}
+
+// errorResponse переводит ошибку в HTTP статус и тело ответа API v1: {"error": ..., "code": ...}
+func errorResponse(err error) (int, gin.H) {
+	classified := classifyError(err)
+	return classified.Status, gin.H{"error": classified.Message, "code": classified.Code}
+}
+
+// errorStatus возвращает HTTP статус для категории ошибки предметной области
+func errorStatus(kind error) int {
+	switch {
+	case errors.Is(kind, domain.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(kind, domain.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(kind, domain.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(kind, domain.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// logError пишет ошибку запроса в лог. Ошибки клиента пишутся уровнем Info, ошибки сервера - уровнем Error
+func logError(c *gin.Context, log *slog.Logger, status int, message string, err error, attrs []any) {
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs = append([]any{slog.String("method", c.Request.Method), slog.Int("code", status), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP())}, attrs...)
+	log.Log(c, level, fmt.Sprintf("%s. Error: %s", message, err.Error()), attrs...)
+}
+
+// respondError пишет в лог и отвечает клиенту ошибкой, переведенной errorResponse
+func respondError(c *gin.Context, log *slog.Logger, message string, err error, attrs ...any) {
+	status, body := errorResponse(err)
+	logError(c, log, status, message, err, attrs)
+	c.JSON(status, body)
+}
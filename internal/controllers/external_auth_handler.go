@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie, которой вход через провайдера привязывается к браузеру, начавшему его
+const (
+	externalLoginCookie     = "external_login"
+	externalLoginCookiePath = "/auth/api/v1/external"
+)
+
+type ExternalAuthHandler struct {
+	Service services.ExternalAuthService
+	Log     *slog.Logger
+}
+
+func NewExternalAuthHandler(externalService services.ExternalAuthService, log *slog.Logger) *ExternalAuthHandler {
+	return &ExternalAuthHandler{Service: externalService, Log: log}
+}
+
+func (h *ExternalAuthHandler) GetProviders(c *gin.Context) {
+	h.Log.Info("External providers were received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, gin.H{"providers": h.Service.Providers()})
+}
+
+// Begin перенаправляет пользователя на страницу входа провайдера
+func (h *ExternalAuthHandler) Begin(c *gin.Context) {
+	provider := c.Param("provider")
+	redirectURL, browserBinding, err := h.Service.Begin(provider)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownProvider) {
+			h.Log.Info(fmt.Sprintf("Failed to begin external login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusNotFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to begin external login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadGateway), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to begin external login"})
+		return
+	}
+
+	// Возврат от провайдера - переход верхнего уровня, поэтому cookie с SameSite=Lax до него доходит
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(externalLoginCookie, browserBinding, 0, externalLoginCookiePath, "", true, true)
+
+	h.Log.Info("External login was started", slog.String("method", c.Request.Method), slog.Int("code", http.StatusFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// Callback принимает пользователя, вернувшегося от провайдера, и выдает токены (или промежуточный токен для кода TOTP)
+func (h *ExternalAuthHandler) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+	// Cookie одноразовая, как и state
+	browserBinding, _ := c.Cookie(externalLoginCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(externalLoginCookie, "", -1, externalLoginCookiePath, "", true, true)
+	if providerError := c.Query("error"); providerError != "" {
+		h.Log.Info(fmt.Sprintf("Failed to finish external login. Error: %s", providerError), slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": providerError, "error_description": c.Query("error_description")})
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		h.Log.Info("Failed to finish external login. Error: state and code are required", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state and code are required"})
+		return
+	}
+
+	result, err := h.Service.Finish(provider, state, browserBinding, code, clientInfo(c))
+	if err != nil {
+		var tooMany *services.TooManyRequestsError
+		if errors.As(err, &tooMany) {
+			h.Log.Warn("Failed to finish external login. Error: too many attempts", slog.String("method", c.Request.Method), slog.Int("code", http.StatusTooManyRequests), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrUnknownProvider):
+			code = http.StatusNotFound
+		case errors.Is(err, services.ErrInvalidExternalState), errors.Is(err, services.ErrInvalidExternalToken):
+			code = http.StatusUnauthorized
+		case errors.Is(err, services.ErrExternalEmailRequired):
+			code = http.StatusBadRequest
+		case errors.Is(err, services.ErrExternalAccountConflict):
+			code = http.StatusConflict
+		case errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrExternalEmailNotVerified):
+			code = http.StatusForbidden
+		}
+		if code == http.StatusInternalServerError {
+			h.Log.Error(fmt.Sprintf("Failed to finish external login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+			c.JSON(code, gin.H{"error": "Failed to login user"})
+			return
+		}
+		h.Log.Info(fmt.Sprintf("Failed to finish external login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider))
+		c.JSON(code, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.Log.Info("User was logged in with external provider", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("provider", provider), slog.Bool("mfa_required", result.MFARequired))
+
+	c.JSON(http.StatusOK, result)
+}
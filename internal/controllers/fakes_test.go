@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/mailer"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/services"
+)
+
+// Заглушки для тестов обработчиков: обработчики проверяются вместе с настоящими сервисами,
+// а хранилища и почта заменяются хранением в памяти. Пользователей хранит общая с тестами сервисов testutil.UserRepo
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// fakeResetRepo хранит токены сброса пароля в памяти
+type fakeResetRepo struct {
+	mu     sync.Mutex
+	tokens []models.PasswordResetToken
+}
+
+func (repo *fakeResetRepo) Create(token *models.PasswordResetToken) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	token.ID = uint(len(repo.tokens) + 1)
+	repo.tokens = append(repo.tokens, *token)
+	return nil
+}
+
+func (repo *fakeResetRepo) FindByHash(tokenHash string) (*models.PasswordResetToken, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+// fakeMailer запоминает отправленные письма. Сервисы отправляют письма в фоне, поэтому тесты ждут их через wait.
+// С failing каждая отправка завершается ошибкой
+type fakeMailer struct {
+	failing bool
+	sent    chan mailer.Message
+}
+
+func newFakeMailer(failing bool) *fakeMailer {
+	return &fakeMailer{failing: failing, sent: make(chan mailer.Message, 16)}
+}
+
+func (mail *fakeMailer) Send(msg mailer.Message) error {
+	mail.sent <- msg
+	if mail.failing {
+		return errors.New("mail server is unavailable")
+	}
+	return nil
+}
+
+// wait возвращает следующее отправленное письмо
+func (mail *fakeMailer) wait(t *testing.T) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-mail.sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+		return mailer.Message{}
+	}
+}
+
+// none проверяет, что писем больше не было
+func (mail *fakeMailer) none(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-mail.sent:
+		t.Fatalf("unexpected email %q to %s", msg.Subject, msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeRoleService знает роль по умолчанию и роль администратора adminRoleID, у которой есть все права
+type fakeRoleService struct {
+	services.RoleService
+
+	adminRoleID uint
+}
+
+func (roles *fakeRoleService) DefaultRole() (*models.Role, error) {
+	return &models.Role{ID: 1, Name: models.RoleNameUser}, nil
+}
+
+func (roles *fakeRoleService) HasPermission(roleID uint, permission string) (bool, error) {
+	return roles.adminRoleID != 0 && roleID == roles.adminRoleID, nil
+}
+
+// fakeThrottle не ограничивает попытки входа
+type fakeThrottle struct{}
+
+func (fakeThrottle) Check(login string, ip string) error           { return nil }
+func (fakeThrottle) RegisterFailure(login string, ip string) error { return nil }
+func (fakeThrottle) RegisterSuccess(login string, ip string) error { return nil }
+func (fakeThrottle) ClearLockout(login string, ip string) error    { return nil }
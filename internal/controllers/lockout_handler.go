@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LockoutHandler struct {
+	Service services.LoginThrottleService
+	Log     *slog.Logger
+}
+
+// ClearLockout снимает блокировку входа по логину и/или IP (параметры запроса login и ip)
+func (h *LockoutHandler) ClearLockout(c *gin.Context) {
+	login := c.Query("login")
+	ip := c.Query("ip")
+	if login == "" && ip == "" {
+		h.Log.Info("Failed to clear login lockout. Error: login or ip is required", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login or ip is required"})
+		return
+	}
+
+	if err := h.Service.ClearLockout(login, ip); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to clear login lockout. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear login lockout"})
+		return
+	}
+
+	h.Log.Info("Login lockout was cleared", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNoContent), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("login", login), slog.String("ip", ip))
+
+	c.Status(http.StatusNoContent)
+}
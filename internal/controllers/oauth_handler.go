@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthHandler struct {
+	Service services.OAuthService
+	Log     *slog.Logger
+}
+
+func NewOAuthHandler(oauthService services.OAuthService, log *slog.Logger) *OAuthHandler {
+	return &OAuthHandler{Service: oauthService, Log: log}
+}
+
+// Authorize выдает код авторизации пользователю, который вошел в веб-клиент мессенджера и предъявил его access токен.
+// Согласие не запрашивается: клиентов регистрируют только администраторы
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to authorize client. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var authorizeDTO dto.OAuthAuthorizeRequest
+	if err := c.ShouldBind(&authorizeDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to authorize client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.OAuthInvalidRequest, "error_description": err.Error()})
+		return
+	}
+
+	redirectURI, err := h.Service.Authorize(userID, middleware.CurrentAuthTime(c), &authorizeDTO)
+	if err != nil {
+		var oauthErr *services.OAuthError
+		if !errors.As(err, &oauthErr) {
+			h.Log.Error(fmt.Sprintf("Failed to authorize client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", authorizeDTO.ClientID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": services.OAuthServerError, "error_description": "Failed to authorize client"})
+			return
+		}
+		if redirectURI != "" {
+			h.Log.Info(fmt.Sprintf("Failed to authorize client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", authorizeDTO.ClientID))
+			c.Redirect(http.StatusFound, redirectURI)
+			return
+		}
+		h.Log.Info(fmt.Sprintf("Failed to authorize client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", authorizeDTO.ClientID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+		return
+	}
+
+	h.Log.Info("Client was authorized", slog.String("method", c.Request.Method), slog.Int("code", http.StatusFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", authorizeDTO.ClientID), slog.Uint64("user_id", uint64(userID)))
+
+	c.Redirect(http.StatusFound, redirectURI)
+}
+
+// Token принимает form-urlencoded запрос. Клиент аутентифицируется через HTTP Basic или client_id/client_secret в теле
+func (h *OAuthHandler) Token(c *gin.Context) {
+	// Ответы с токенами не должны кэшироваться (RFC 6749 §5.1)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var tokenDTO dto.OAuthTokenRequest
+	if err := c.ShouldBind(&tokenDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to issue oauth tokens. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.OAuthInvalidRequest, "error_description": err.Error()})
+		return
+	}
+	basicAuth := false
+	if clientID, clientSecret, ok := c.Request.BasicAuth(); ok {
+		// Значения в Basic закодированы application/x-www-form-urlencoded (RFC 6749 §2.3.1)
+		tokenDTO.ClientID, _ = url.QueryUnescape(clientID)
+		tokenDTO.ClientSecret, _ = url.QueryUnescape(clientSecret)
+		basicAuth = true
+	}
+
+	tokens, err := h.Service.Token(&tokenDTO, clientInfo(c))
+	if err != nil {
+		var oauthErr *services.OAuthError
+		if !errors.As(err, &oauthErr) {
+			h.Log.Error(fmt.Sprintf("Failed to issue oauth tokens. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", tokenDTO.ClientID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": services.OAuthServerError, "error_description": "Failed to issue tokens"})
+			return
+		}
+		code := http.StatusBadRequest
+		if oauthErr.Code == services.OAuthInvalidClient {
+			code = http.StatusUnauthorized
+			if basicAuth {
+				c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			}
+		}
+		h.Log.Info(fmt.Sprintf("Failed to issue oauth tokens. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", tokenDTO.ClientID))
+		c.JSON(code, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+		return
+	}
+
+	h.Log.Info("OAuth tokens were issued", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", tokenDTO.ClientID))
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *OAuthHandler) CreateClient(c *gin.Context) {
+	var clientDTO dto.OAuthClientData
+	if err := c.ShouldBindJSON(&clientDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to register oauth client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.Service.CreateClient(&clientDTO)
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to register oauth client. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register oauth client"})
+		return
+	}
+
+	h.Log.Info("OAuth client was registered", slog.String("method", c.Request.Method), slog.Int("code", http.StatusCreated), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("client_id", client.ClientID))
+
+	c.JSON(http.StatusCreated, client)
+}
+
+func (h *OAuthHandler) GetClients(c *gin.Context) {
+	clients, err := h.Service.GetClients()
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to receive oauth clients. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to receive oauth clients"})
+		return
+	}
+
+	h.Log.Info("OAuth clients were received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, clients)
+}
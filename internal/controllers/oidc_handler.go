@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	Service services.OIDCService
+	Log     *slog.Logger
+}
+
+func NewOIDCHandler(oidcService services.OIDCService, log *slog.Logger) *OIDCHandler {
+	return &OIDCHandler{Service: oidcService, Log: log}
+}
+
+// Discovery отдает документ OpenID Provider Metadata (OpenID Connect Discovery §4)
+func (h *OIDCHandler) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.Service.Discovery())
+}
+
+// UserInfo возвращает данные пользователя по access токену. Набор полей зависит от scope токена
+func (h *OIDCHandler) UserInfo(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to receive userinfo. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+
+	userInfo, err := h.Service.UserInfo(userID, middleware.CurrentScope(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInsufficientScope):
+			h.Log.Info(fmt.Sprintf("Failed to receive userinfo. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusForbidden), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+		case errors.Is(err, services.ErrUserNotFound):
+			h.Log.Info(fmt.Sprintf("Failed to receive userinfo. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		default:
+			h.Log.Error(fmt.Sprintf("Failed to receive userinfo. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to receive userinfo"})
+		}
+		return
+	}
+
+	h.Log.Info("Userinfo was received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, userInfo)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordHandler struct {
+	Service services.PasswordService
+	Log     *slog.Logger
+}
+
+func NewPasswordHandler(passwordService services.PasswordService, log *slog.Logger) *PasswordHandler {
+	return &PasswordHandler{Service: passwordService, Log: log}
+}
+
+// ForgotPassword всегда отвечает одинаково, независимо от того, зарегистрирована ли почта
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var forgotDTO dto.ForgotPasswordData
+	if err := c.ShouldBindJSON(&forgotDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to request password reset. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.ForgotPassword(forgotDTO.Email); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to request password reset. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request password reset"})
+		return
+	}
+
+	h.Log.Info("Password reset was requested", slog.String("method", c.Request.Method), slog.Int("code", http.StatusAccepted), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a password reset link has been sent"})
+}
+
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var resetDTO dto.ResetPasswordData
+	if err := c.ShouldBindJSON(&resetDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to reset password. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.ResetPassword(resetDTO.Token, resetDTO.Password); err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			h.Log.Info("Failed to reset password. Error: password does not meet the policy", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			h.Log.Info("Failed to reset password. Error: invalid reset token", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to reset password. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	h.Log.Info("Password was reset", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password was reset successfully"})
+}
+
+// ChangePassword меняет пароль аутентифицированного пользователя. Остальные сессии пользователя завершаются
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to change password. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var changeDTO dto.ChangePasswordData
+	if err := c.ShouldBindJSON(&changeDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to change password. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Service.ChangePassword(userID, changeDTO.CurrentPassword, changeDTO.Password, middleware.CurrentSessionID(c)); err != nil {
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			h.Log.Info("Failed to change password. Error: password does not meet the policy", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			h.Log.Info("Failed to change password. Error: invalid current password", slog.String("method", c.Request.Method), slog.Int("code", http.StatusForbidden), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid current password"})
+			return
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.Log.Info("Failed to change password. Error: user not found", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNotFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to change password. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	h.Log.Info("Password was changed", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password was changed successfully"})
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"log/slog"
+
+	"messenger-auth/internal/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemResponse переводит ошибку в HTTP статус и тело ответа в формате RFC 7807
+func problemResponse(c *gin.Context, err error) (int, dto.Problem) {
+	classified := classifyError(err)
+	return classified.Status, dto.NewProblem(classified.Status, classified.Code, classified.Message, c.Request.URL.Path, classified.Fields)
+}
+
+// respondProblem пишет в лог и отвечает клиенту ошибкой в формате application/problem+json
+func respondProblem(c *gin.Context, log *slog.Logger, message string, err error, attrs ...any) {
+	status, problem := problemResponse(c, err)
+	logError(c, log, status, message, err, attrs)
+	// gin не перезаписывает заданный заранее Content-Type
+	c.Header("Content-Type", dto.ProblemContentType)
+	c.JSON(status, problem)
+}
+
+// respondData отвечает успешным результатом API v2 в конверте {"data": ...}
+func respondData(c *gin.Context, status int, data any) {
+	c.JSON(status, dto.Envelope{Data: data})
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleHandler struct {
+	Service services.RoleService
+	Log     *slog.Logger
+}
+
+func NewRoleHandler(roleService services.RoleService, log *slog.Logger) *RoleHandler {
+	return &RoleHandler{Service: roleService, Log: log}
+}
+
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	roles, err := h.Service.GetRoles()
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to receive roles. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to receive roles"})
+		return
+	}
+
+	h.Log.Info("Roles were received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RoleHandler) AssignRole(c *gin.Context) {
+	userID, roleID, ok := h.parseIDs(c, "assign role")
+	if !ok {
+		return
+	}
+
+	if err := h.Service.AssignRole(userID, roleID); err != nil {
+		h.respondError(c, "assign role", err, userID, roleID)
+		return
+	}
+
+	h.Log.Info("Role was assigned", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)), slog.Uint64("role_id", uint64(roleID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully", "id": userID, "roleId": roleID})
+}
+
+func (h *RoleHandler) RevokeRole(c *gin.Context) {
+	userID, roleID, ok := h.parseIDs(c, "revoke role")
+	if !ok {
+		return
+	}
+
+	if err := h.Service.RevokeRole(userID, roleID); err != nil {
+		h.respondError(c, "revoke role", err, userID, roleID)
+		return
+	}
+
+	h.Log.Info("Role was revoked", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)), slog.Uint64("role_id", uint64(roleID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked successfully", "id": userID, "roleId": roleID})
+}
+
+func (h *RoleHandler) parseIDs(c *gin.Context, action string) (uint, uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || userID == 0 {
+		h.Log.Error(fmt.Sprintf("Failed to %s. Error: Invalid user ID", action), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("user_id", c.Param("id")))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	roleID, err := strconv.ParseUint(c.Param("roleId"), 10, 32)
+	if err != nil || roleID == 0 {
+		h.Log.Error(fmt.Sprintf("Failed to %s. Error: Invalid role ID", action), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("role_id", c.Param("roleId")))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return 0, 0, false
+	}
+	return uint(userID), uint(roleID), true
+}
+
+func (h *RoleHandler) respondError(c *gin.Context, action string, err error, userID, roleID uint) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrRoleNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, services.ErrRoleNotAssigned), errors.Is(err, services.ErrDefaultRoleRevoke):
+		code = http.StatusConflict
+	}
+	h.Log.Error(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)), slog.Uint64("role_id", uint64(roleID)))
+	if code == http.StatusInternalServerError {
+		c.JSON(code, gin.H{"error": fmt.Sprintf("Failed to %s", action)})
+		return
+	}
+	c.JSON(code, gin.H{"error": err.Error()})
+}
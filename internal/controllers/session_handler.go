@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	Service services.SessionService
+	Log     *slog.Logger
+}
+
+func NewSessionHandler(sessionService services.SessionService, log *slog.Logger) *SessionHandler {
+	return &SessionHandler{Service: sessionService, Log: log}
+}
+
+// clientInfo собирает данные устройства для сессии. Клиент может задать название устройства заголовком X-Device-Name
+func clientInfo(c *gin.Context) *dto.ClientInfo {
+	return &dto.ClientInfo{
+		DeviceName: c.GetHeader("X-Device-Name"),
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+	}
+}
+
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to receive sessions. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.Service.GetSessions(userID, middleware.CurrentSessionID(c))
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to receive sessions. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to receive sessions"})
+		return
+	}
+
+	h.Log.Info("Sessions were received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to revoke session. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to revoke session. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.Service.RevokeSession(userID, uint(sessionID)); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			h.Log.Info(fmt.Sprintf("Failed to revoke session. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusNotFound), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.Log.Error(fmt.Sprintf("Failed to revoke session. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	h.Log.Info("Session was revoked", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNoContent), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)), slog.Uint64("session_id", sessionID))
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме сессии текущего access токена ("выйти на всех остальных устройствах")
+func (h *SessionHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	currentSessionID := middleware.CurrentSessionID(c)
+	if !ok || currentSessionID == "" {
+		h.Log.Info("Failed to revoke other sessions. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.Service.RevokeOtherSessions(userID, currentSessionID); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to revoke other sessions. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke other sessions"})
+		return
+	}
+
+	h.Log.Info("Other sessions were revoked", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNoContent), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.Status(http.StatusNoContent)
+}
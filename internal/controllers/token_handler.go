@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenHandler struct {
+	Service services.TokenService
+	Log     *slog.Logger
+}
+
+func NewTokenHandler(tokenService services.TokenService, log *slog.Logger) *TokenHandler {
+	return &TokenHandler{Service: tokenService, Log: log}
+}
+
+// JWKS отдает открытые ключи, по которым другие сервисы проверяют access токены
+func (h *TokenHandler) JWKS(c *gin.Context) {
+	// Новый ключ публикуется заранее, не меньше чем за JWKSMaxAge до начала подписи, поэтому закэшированный
+	// клиентами набор ключей к моменту ротации уже содержит его
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(services.JWKSMaxAge.Seconds())))
+	c.JSON(http.StatusOK, dto.JWKS{Keys: h.Service.PublicKeys()})
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorHandler struct {
+	Service services.TwoFactorService
+	Log     *slog.Logger
+}
+
+func NewTwoFactorHandler(twoFactorService services.TwoFactorService, log *slog.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{Service: twoFactorService, Log: log}
+}
+
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	userID, ok := h.currentUser(c, "enroll two-factor authentication")
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.Service.Enroll(userID)
+	if err != nil {
+		h.respondError(c, "enroll two-factor authentication", err, userID)
+		return
+	}
+
+	h.Log.Info("Two-factor enrollment was started", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	userID, codeDTO, ok := h.bindCode(c, "confirm two-factor authentication")
+	if !ok {
+		return
+	}
+
+	codes, err := h.Service.Confirm(userID, codeDTO.Code)
+	if err != nil {
+		h.respondError(c, "confirm two-factor authentication", err, userID)
+		return
+	}
+
+	h.Log.Info("Two-factor authentication was enabled", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, dto.RecoveryCodesData{RecoveryCodes: codes})
+}
+
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	userID, codeDTO, ok := h.bindCode(c, "disable two-factor authentication")
+	if !ok {
+		return
+	}
+
+	if err := h.Service.Disable(userID, codeDTO.Code); err != nil {
+		h.respondError(c, "disable two-factor authentication", err, userID)
+		return
+	}
+
+	h.Log.Info("Two-factor authentication was disabled", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled successfully"})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, codeDTO, ok := h.bindCode(c, "regenerate recovery codes")
+	if !ok {
+		return
+	}
+
+	codes, err := h.Service.RegenerateRecoveryCodes(userID, codeDTO.Code)
+	if err != nil {
+		h.respondError(c, "regenerate recovery codes", err, userID)
+		return
+	}
+
+	h.Log.Info("Recovery codes were regenerated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, dto.RecoveryCodesData{RecoveryCodes: codes})
+}
+
+// currentUser возвращает id пользователя из access токена. Без аутентификации (AUTH_ENABLED=false) управлять 2FA нельзя
+func (h *TwoFactorHandler) currentUser(c *gin.Context, action string) (uint, bool) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info(fmt.Sprintf("Failed to %s. Error: user is not authenticated", action), slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, false
+	}
+	return userID, true
+}
+
+func (h *TwoFactorHandler) bindCode(c *gin.Context, action string) (uint, *dto.TwoFactorCodeData, bool) {
+	userID, ok := h.currentUser(c, action)
+	if !ok {
+		return 0, nil, false
+	}
+	var codeDTO dto.TwoFactorCodeData
+	if err := c.ShouldBindJSON(&codeDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, nil, false
+	}
+	return userID, &codeDTO, true
+}
+
+func (h *TwoFactorHandler) respondError(c *gin.Context, action string, err error, userID uint) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		code = http.StatusUnauthorized
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled), errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolled):
+		code = http.StatusConflict
+	case errors.Is(err, services.ErrUserNotFound):
+		code = http.StatusNotFound
+	}
+	if code == http.StatusInternalServerError {
+		h.Log.Error(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+		c.JSON(code, gin.H{"error": fmt.Sprintf("Failed to %s", action)})
+		return
+	}
+	h.Log.Info(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+	c.JSON(code, gin.H{"error": err.Error()})
+}
@@ -1,12 +1,14 @@
 package controllers
 
 import (
-	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
 
+	"messenger-auth/internal/domain"
 	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/models"
 	"messenger-auth/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -14,44 +16,41 @@ import (
 
 type UserHandler struct {
 	Service services.UserService
+	Roles   services.RoleService
 	Log     *slog.Logger
 }
 
-func NewUserHandler(userService services.UserService, log *slog.Logger) *UserHandler {
-	return &UserHandler{Service: userService, Log: log}
+func NewUserHandler(userService services.UserService, roleService services.RoleService, log *slog.Logger) *UserHandler {
+	return &UserHandler{Service: userService, Roles: roleService, Log: log}
 }
 
 func (h *UserHandler) CreateUser(c *gin.Context) {
-	var userDTO dto.UserData
+	var userDTO dto.CreateUserData
 	if err := c.ShouldBindJSON(&userDTO); err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, h.Log, "Failed to create user", bindError(err))
 		return
 	}
 
-	if err := h.Service.CreateUser(&userDTO); err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Any("user_data", userDTO))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if _, err := h.Service.CreateUser(&userDTO); err != nil {
+		respondError(c, h.Log, "Failed to create user", err, slog.Any("user_data", userDTO.UserData))
 		return
 	}
 
-	h.Log.Info("User was created", slog.String("method", c.Request.Method), slog.Int("code", http.StatusCreated), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Any("user_data", userDTO))
+	h.Log.Info("User was created", slog.String("method", c.Request.Method), slog.Int("code", http.StatusCreated), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Any("user_data", userDTO.UserData))
 
-	c.JSON(http.StatusCreated, userDTO)
+	c.JSON(http.StatusCreated, userDTO.UserData)
 }
 
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil || id == 0{
-		h.Log.Error("Failed to delete user. Error: Invalid user ID", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("user_id", idStr))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, h.Log, "Failed to delete user", &requestError{Code: domain.CodeInvalidUserID, Message: "Invalid user ID", Err: err}, slog.String("user_id", idStr))
 		return
 	}
 
 	if err = h.Service.DeleteUser(uint(id)); err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to delete user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", id))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, h.Log, "Failed to delete user", err, slog.Uint64("user_id", id))
 		return
 	}
 
@@ -64,15 +63,13 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		h.Log.Error("Failed to receive user. Error: Invalid user ID", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("user_id", idStr))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, h.Log, "Failed to receive user", &requestError{Code: domain.CodeInvalidUserID, Message: "Invalid user ID", Err: err}, slog.String("user_id", idStr))
 		return
 	}
 
 	user, err := h.Service.GetUserByID(uint(id))
 	if err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to receive user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", id))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, h.Log, "Failed to receive user", err, slog.Uint64("user_id", id))
 		return
 	}
 
@@ -82,13 +79,29 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 }
 
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	users, err := h.Service.GetUsers()
+	var query dto.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondError(c, h.Log, "Failed to receive users", bindError(err))
+		return
+	}
+
+	// v1 отдает список массивом, поэтому данные пагинации передаются в заголовках. Клиенты v1, написанные
+	// до появления пагинации, не передают ни limit, ни cursor и получают весь список, как раньше. Это привилегия
+	// администраторов (users:manage): остальные без limit получают первую страницу и X-Next-Cursor
+	query.Unpaginated = query.Limit == 0 && query.Cursor == "" && middleware.HasPermission(c, h.Roles, models.PermissionUsersManage, h.Log)
+	users, meta, err := h.Service.GetUsers(&query)
 	if err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to receive users. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, h.Log, "Failed to receive users", err)
 		return
 	}
 
+	if meta.NextCursor != nil {
+		c.Header("X-Next-Cursor", *meta.NextCursor)
+	}
+	if meta.Total != nil {
+		c.Header("X-Total-Count", strconv.FormatInt(*meta.Total, 10))
+	}
+
 	h.Log.Info("Users was received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
 
 	c.JSON(http.StatusOK, users)
@@ -98,25 +111,22 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		h.Log.Error("Failed to update user. Error: Invalid user ID", slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.String("user_id", idStr))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, h.Log, "Failed to update user", &requestError{Code: domain.CodeInvalidUserID, Message: "Invalid user ID", Err: err}, slog.String("user_id", idStr))
 		return
 	}
 
 	var userDTO dto.UserData
 	if err := c.ShouldBindJSON(&userDTO); err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, h.Log, "Failed to update user", bindError(err))
 		return
 	}
 
-	if err := h.Service.UpdateUser(uint(id), &userDTO); err != nil {
-		h.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", id), slog.Any("user_data", userDTO))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if _, err := h.Service.UpdateUser(uint(id), &userDTO); err != nil {
+		respondError(c, h.Log, "Failed to update user", err, slog.Uint64("user_id", id), slog.Any("user_data", userDTO))
 		return
 	}
 
 	h.Log.Info("User was updated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", id), slog.Any("user_data", userDTO))
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "id": id})
+	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully", "id": id})
 }
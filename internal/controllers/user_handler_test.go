@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/services"
+	"messenger-auth/internal/testutil"
+)
+
+const (
+	testUserRoleID  uint = 1
+	testAdminRoleID uint = 2
+)
+
+// newUsersTestRouter подключает обработчики пользователей к настоящему UserService. Вместо проверки токена
+// роль вызывающего берется из заголовка X-Test-Role, пользователь - из X-Test-User
+func newUsersTestRouter(t *testing.T, users *testutil.UserRepo) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := discardLogger()
+	roles := &fakeRoleService{adminRoleID: testAdminRoleID}
+	userService := services.NewUserServiceGORM(users, nil, nil, roles, nil, nil, log)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		var role, userID uint
+		fmt.Sscan(c.GetHeader("X-Test-Role"), &role)
+		fmt.Sscan(c.GetHeader("X-Test-User"), &userID)
+		c.Set(middleware.ContextRole, role)
+		c.Set(middleware.ContextUserID, userID)
+		c.Next()
+	})
+	userHandler := NewUserHandler(userService, roles, log)
+	userHandlerV2 := &UserHandlerV2{Service: userService, Log: log}
+	router.GET("/user/api/v1/", userHandler.GetUsers)
+	router.GET("/user/api/v2/search", userHandlerV2.SearchUsers)
+	return router
+}
+
+func getAs(router *gin.Engine, role uint, userID uint, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.Header.Set("X-Test-Role", fmt.Sprint(role))
+	request.Header.Set("X-Test-User", fmt.Sprint(userID))
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetUsersV1UnpaginatedOnlyForManagers(t *testing.T) {
+	many := make([]*models.User, 30)
+	for i := range many {
+		login := fmt.Sprintf("user%02d", i)
+		many[i] = &models.User{Login: &login, Name: &login}
+	}
+	router := newUsersTestRouter(t, testutil.NewUserRepo(many...))
+
+	cases := []struct {
+		name       string
+		role       uint
+		want       int
+		nextCursor bool
+	}{
+		{"admin", testAdminRoleID, len(many), false},
+		{"without users:manage", testUserRoleID, 20, true},
+	}
+	for _, tc := range cases {
+		recorder := getAs(router, tc.role, 0, "/user/api/v1/")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: code = %d, body = %s", tc.name, recorder.Code, recorder.Body.String())
+		}
+		var list []models.User
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+			t.Fatalf("%s: decode: %v", tc.name, err)
+		}
+		if len(list) != tc.want || (recorder.Header().Get("X-Next-Cursor") != "") != tc.nextCursor {
+			t.Fatalf("%s: got %d users, X-Next-Cursor %q, want %d users", tc.name, len(list), recorder.Header().Get("X-Next-Cursor"), tc.want)
+		}
+	}
+}
+
+func TestSearchUsersByEmailRespectsPrivacy(t *testing.T) {
+	newUser := func(login string, hideFromEmailSearch bool) *models.User {
+		email := login + "@messenger.test"
+		return &models.User{Login: &login, Name: &login, Email: &email, HideFromEmailSearch: hideFromEmailSearch}
+	}
+	caller, visible, hidden := newUser("alice", false), newUser("bob", false), newUser("carol", true)
+	router := newUsersTestRouter(t, testutil.NewUserRepo(caller, visible, hidden))
+
+	cases := []struct {
+		email string
+		want  []uint
+	}{
+		{"bob@messenger.test", []uint{visible.ID}},
+		{"carol@messenger.test", nil},
+	}
+	for _, tc := range cases {
+		// Обычный пользователь без users:read: поиск доступен любому аутентифицированному
+		recorder := getAs(router, testUserRoleID, caller.ID, "/user/api/v2/search?q="+url.QueryEscape(tc.email))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: code = %d, body = %s", tc.email, recorder.Code, recorder.Body.String())
+		}
+		var body struct {
+			Data []dto.UserSearchResult `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: decode: %v", tc.email, err)
+		}
+		var got []uint
+		for _, result := range body.Data {
+			got = append(got, result.ID)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("%s: found users %v, want %v", tc.email, got, tc.want)
+		}
+	}
+}
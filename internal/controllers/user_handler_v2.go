@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandlerV2 - API пользователей /user/api/v2. Успешные ответы приходят в конверте {"data": ...},
+// ошибки - в формате application/problem+json (RFC 7807)
+type UserHandlerV2 struct {
+	Service services.UserService
+	Log     *slog.Logger
+}
+
+func NewUserHandlerV2(userService services.UserService, log *slog.Logger) *UserHandlerV2 {
+	return &UserHandlerV2{Service: userService, Log: log}
+}
+
+func (h *UserHandlerV2) CreateUser(c *gin.Context) {
+	var userDTO dto.CreateUserData
+	if err := c.ShouldBindJSON(&userDTO); err != nil {
+		respondProblem(c, h.Log, "Failed to create user", bindError(err))
+		return
+	}
+
+	user, err := h.Service.CreateUser(&userDTO)
+	if err != nil {
+		respondProblem(c, h.Log, "Failed to create user", err, slog.Any("user_data", userDTO.UserData))
+		return
+	}
+
+	h.Log.Info("User was created", slog.String("method", c.Request.Method), slog.Int("code", http.StatusCreated), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(user.ID)))
+
+	respondData(c, http.StatusCreated, user)
+}
+
+func (h *UserHandlerV2) UpdateUser(c *gin.Context) {
+	id, ok := h.parseID(c, "Failed to update user")
+	if !ok {
+		return
+	}
+
+	var userDTO dto.UserData
+	if err := c.ShouldBindJSON(&userDTO); err != nil {
+		respondProblem(c, h.Log, "Failed to update user", bindError(err), slog.Uint64("user_id", uint64(id)))
+		return
+	}
+
+	user, err := h.Service.UpdateUser(id, &userDTO)
+	if err != nil {
+		respondProblem(c, h.Log, "Failed to update user", err, slog.Uint64("user_id", uint64(id)), slog.Any("user_data", userDTO))
+		return
+	}
+
+	h.Log.Info("User was updated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(id)))
+
+	respondData(c, http.StatusOK, user)
+}
+
+func (h *UserHandlerV2) DeleteUser(c *gin.Context) {
+	id, ok := h.parseID(c, "Failed to delete user")
+	if !ok {
+		return
+	}
+
+	if err := h.Service.DeleteUser(id); err != nil {
+		respondProblem(c, h.Log, "Failed to delete user", err, slog.Uint64("user_id", uint64(id)))
+		return
+	}
+
+	h.Log.Info("User was deleted", slog.String("method", c.Request.Method), slog.Int("code", http.StatusNoContent), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(id)))
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UserHandlerV2) GetUserByID(c *gin.Context) {
+	id, ok := h.parseID(c, "Failed to receive user")
+	if !ok {
+		return
+	}
+
+	user, err := h.Service.GetUserByID(id)
+	if err != nil {
+		respondProblem(c, h.Log, "Failed to receive user", err, slog.Uint64("user_id", uint64(id)))
+		return
+	}
+
+	h.Log.Info("User was received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(id)))
+
+	respondData(c, http.StatusOK, user)
+}
+
+func (h *UserHandlerV2) GetUsers(c *gin.Context) {
+	var query dto.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondProblem(c, h.Log, "Failed to receive users", bindError(err))
+		return
+	}
+
+	users, meta, err := h.Service.GetUsers(&query)
+	if err != nil {
+		respondProblem(c, h.Log, "Failed to receive users", err)
+		return
+	}
+
+	h.Log.Info("Users were received", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, dto.Envelope{Data: users, Meta: meta})
+}
+
+// SearchUsers ищет контакты по имени, логину или полному адресу почты. Доступен любому аутентифицированному пользователю
+func (h *UserHandlerV2) SearchUsers(c *gin.Context) {
+	var query dto.UserSearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondProblem(c, h.Log, "Failed to search users", bindError(err))
+		return
+	}
+
+	// При AUTH_ENABLED=false пользователя нет, и исключать из результатов некого
+	callerID, _ := middleware.CurrentUserID(c)
+	users, err := h.Service.SearchUsers(callerID, &query)
+	if err != nil {
+		respondProblem(c, h.Log, "Failed to search users", err, slog.Uint64("user_id", uint64(callerID)))
+		return
+	}
+
+	h.Log.Info("Users were searched", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(callerID)), slog.Int("count", len(users)))
+
+	respondData(c, http.StatusOK, users)
+}
+
+// parseID читает id пользователя из пути. При ошибке сам отвечает клиенту
+func (h *UserHandlerV2) parseID(c *gin.Context, message string) (uint, bool) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil || id == 0 {
+		respondProblem(c, h.Log, message, &requestError{Code: domain.CodeInvalidUserID, Message: "Invalid user ID", Err: err}, slog.String("user_id", idStr))
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/middleware"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	Service     services.WebAuthnService
+	AuthService services.AuthService
+	Log         *slog.Logger
+}
+
+func NewWebAuthnHandler(webAuthnService services.WebAuthnService, authService services.AuthService, log *slog.Logger) *WebAuthnHandler {
+	return &WebAuthnHandler{Service: webAuthnService, AuthService: authService, Log: log}
+}
+
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to begin passkey registration. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	options, err := h.Service.BeginRegistration(userID)
+	if err != nil {
+		h.respondError(c, "begin passkey registration", err)
+		return
+	}
+
+	h.Log.Info("Passkey registration was started", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		h.Log.Info("Failed to finish passkey registration. Error: user is not authenticated", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	var registrationDTO dto.WebAuthnRegistrationData
+	if err := c.ShouldBindJSON(&registrationDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to finish passkey registration. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.Service.FinishRegistration(userID, &registrationDTO)
+	if err != nil {
+		h.respondError(c, "finish passkey registration", err)
+		return
+	}
+
+	h.Log.Info("Passkey was registered", slog.String("method", c.Request.Method), slog.Int("code", http.StatusCreated), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(userID)))
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	var loginDTO dto.WebAuthnLoginBeginData
+	if err := c.ShouldBindJSON(&loginDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to begin passkey login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := h.Service.BeginLogin(loginDTO.Login)
+	if err != nil {
+		h.respondError(c, "begin passkey login", err)
+		return
+	}
+
+	h.Log.Info("Passkey login was started", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var assertionDTO dto.WebAuthnAssertionData
+	if err := c.ShouldBindJSON(&assertionDTO); err != nil {
+		h.Log.Error(fmt.Sprintf("Failed to finish passkey login. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusBadRequest), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.Service.FinishLogin(&assertionDTO)
+	if err != nil {
+		h.respondError(c, "finish passkey login", err)
+		return
+	}
+	tokens, err := h.AuthService.LoginPasswordless(user, clientInfo(c))
+	if err != nil {
+		h.respondError(c, "finish passkey login", err)
+		return
+	}
+
+	h.Log.Info("User was logged in with passkey", slog.String("method", c.Request.Method), slog.Int("code", http.StatusOK), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()), slog.Uint64("user_id", uint64(user.ID)))
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *WebAuthnHandler) respondError(c *gin.Context, action string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, services.ErrInvalidWebAuthnChallenge), errors.Is(err, services.ErrInvalidWebAuthnResponse), errors.Is(err, services.ErrWebAuthnCredentialCloned):
+		code = http.StatusUnauthorized
+	case errors.Is(err, services.ErrUnsupportedAttestation), errors.Is(err, services.ErrUnsupportedWebAuthnKey):
+		code = http.StatusBadRequest
+	case errors.Is(err, services.ErrWebAuthnCredentialExists):
+		code = http.StatusConflict
+	case errors.Is(err, services.ErrEmailNotVerified):
+		code = http.StatusForbidden
+	case errors.Is(err, services.ErrUserNotFound):
+		code = http.StatusNotFound
+	}
+	if code == http.StatusInternalServerError {
+		h.Log.Error(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		c.JSON(code, gin.H{"error": fmt.Sprintf("Failed to %s", action)})
+		return
+	}
+	h.Log.Info(fmt.Sprintf("Failed to %s. Error: %s", action, err.Error()), slog.String("method", c.Request.Method), slog.Int("code", code), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+	c.JSON(code, gin.H{"error": err.Error()})
+}
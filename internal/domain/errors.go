@@ -0,0 +1,89 @@
+// Package domain содержит ошибки предметной области, не зависящие от хранилища и транспорта.
+// Репозитории и сервисы оборачивают в них свои ошибки, а обработчики переводят их в HTTP ответы
+package domain
+
+import "errors"
+
+// Категории ошибок. Проверяются через errors.Is
+var (
+	// ErrNotFound - запрошенный объект не существует
+	ErrNotFound = errors.New("not found")
+	// ErrConflict - операция противоречит текущему состоянию (например, значение уже занято)
+	ErrConflict = errors.New("conflict")
+	// ErrValidation - входные данные некорректны
+	ErrValidation = errors.New("validation failed")
+	// ErrForbidden - операция запрещена для текущего пользователя или объекта
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Стабильные машиночитаемые коды ошибок. Клиенты опираются на них, поэтому коды не меняются
+const (
+	CodeInternal          = "internal_error"
+	CodeUnauthorized      = "unauthorized"
+	CodeInvalidToken      = "invalid_token"
+	CodeForbidden         = "forbidden"
+	CodeTooManyRequests   = "too_many_requests"
+	CodeMalformedRequest  = "malformed_request"
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidUserID     = "invalid_user_id"
+	CodeUserNotFound      = "user_not_found"
+	CodeUserAlreadyExists = "user_already_exists"
+	CodeUserProtected     = "user_protected"
+	CodeInvalidUserData   = "invalid_user_data"
+	CodeSessionNotFound   = "session_not_found"
+	CodeRoleNotFound      = "role_not_found"
+)
+
+// FieldError описывает нарушение правила проверки для одного поля запроса
+type FieldError struct {
+	Field   string `json:"field"`   // Имя поля в JSON
+	Rule    string `json:"rule"`    // Нарушенное правило (required, email, max, ...)
+	Message string `json:"message"` // Описание для клиента
+}
+
+// Error - ошибка предметной области: категория Kind, стабильный код Code, сообщение для клиента Message,
+// ошибки отдельных полей Fields и исходная ошибка Err, которая в ответ не попадает
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap позволяет проверять через errors.Is как категорию, так и исходную ошибку (например, gorm.ErrRecordNotFound)
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// NotFound создает ошибку категории ErrNotFound. err - исходная ошибка, может быть nil
+func NotFound(code string, message string, err error) error {
+	return &Error{Kind: ErrNotFound, Code: code, Message: message, Err: err}
+}
+
+// Conflict создает ошибку категории ErrConflict. err - исходная ошибка, может быть nil
+func Conflict(code string, message string, err error) error {
+	return &Error{Kind: ErrConflict, Code: code, Message: message, Err: err}
+}
+
+// Validation создает ошибку категории ErrValidation. err - исходная ошибка, может быть nil
+func Validation(code string, message string, err error) error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message, Err: err}
+}
+
+// InvalidFields создает ошибку категории ErrValidation с перечнем нарушений по полям
+func InvalidFields(code string, message string, fields []FieldError, err error) error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message, Fields: fields, Err: err}
+}
+
+// Forbidden создает ошибку категории ErrForbidden. err - исходная ошибка, может быть nil
+func Forbidden(code string, message string, err error) error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: message, Err: err}
+}
@@ -7,5 +7,53 @@ type LoginData struct {
 
 type RegisterData struct {
 	Login    string `json:"login" binding:"required,min=3,max=50"`
+	Email    string `json:"email" binding:"required,email,max=255"`
 	Password string `json:"password" binding:"required,min=8,max=50"`
 }
+
+type ForgotPasswordData struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+}
+
+type ResetPasswordData struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=50"`
+}
+
+type ChangePasswordData struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	Password        string `json:"password" binding:"required,min=8,max=50"`
+}
+
+type VerifyEmailData struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationData struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+}
+
+type TwoFactorLoginData struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required,max=20"`
+}
+
+type TwoFactorCodeData struct {
+	Code string `json:"code" binding:"required,max=20"`
+}
+
+// TOTPEnrollmentData - секрет TOTP и otpauth:// URI для отображения QR-кода
+type TOTPEnrollmentData struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+type RecoveryCodesData struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// PasswordViolation - нарушенное правило политики паролей
+type PasswordViolation struct {
+	Rule    string `json:"rule"` // min_length, strength, banned_word или breached
+	Message string `json:"message"`
+}
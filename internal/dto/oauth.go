@@ -0,0 +1,51 @@
+package dto
+
+import "messenger-auth/internal/models"
+
+// OAuthAuthorizeRequest - параметры запроса авторизации (RFC 6749 §4.1.1, RFC 7636 §4.3)
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"` // OIDC: переносится в ID токен
+}
+
+// OAuthTokenRequest - параметры запроса к /oauth/token (RFC 6749 §4.1.3, §6)
+type OAuthTokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// OAuthTokenResponse - ответ /oauth/token (RFC 6749 §5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // OIDC: выдается, если в scope есть openid
+}
+
+type OAuthClientData struct {
+	Name         string   `json:"name" binding:"required,max=255"`
+	RedirectURIs []string `json:"redirectUris" binding:"required,min=1,dive,url"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// OAuthClientInfo - клиент OAuth для администраторов. Секрет показывается только один раз, при регистрации
+type OAuthClientInfo struct {
+	*models.OAuthClient
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+}
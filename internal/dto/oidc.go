@@ -0,0 +1,35 @@
+package dto
+
+// OpenIDConfiguration - документ /.well-known/openid-configuration (OpenID Connect Discovery 1.0 §3)
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OIDCUserClaims - стандартные поля пользователя (OpenID Connect Core §5.1), заполняются по выданным scope
+type OIDCUserClaims struct {
+	Name              string `json:"name,omitempty"`               // profile
+	GivenName         string `json:"given_name,omitempty"`         // profile
+	FamilyName        string `json:"family_name,omitempty"`        // profile
+	PreferredUsername string `json:"preferred_username,omitempty"` // profile
+	UpdatedAt         int64  `json:"updated_at,omitempty"`         // profile
+	Email             string `json:"email,omitempty"`              // email
+	EmailVerified     *bool  `json:"email_verified,omitempty"`     // email
+}
+
+// UserInfo - ответ /userinfo
+type UserInfo struct {
+	Subject string `json:"sub"`
+	OIDCUserClaims
+}
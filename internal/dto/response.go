@@ -0,0 +1,43 @@
+package dto
+
+import (
+	"net/http"
+
+	"messenger-auth/internal/domain"
+)
+
+// Envelope - успешный ответ API v2. Данные всегда лежат в data, служебная информация (например, пагинация) - в meta
+type Envelope struct {
+	Data any `json:"data"`
+	Meta any `json:"meta,omitempty"`
+}
+
+// Problem - ошибка API v2 в формате RFC 7807 (application/problem+json)
+type Problem struct {
+	Type     string              `json:"type"`             // URI типа ошибки, однозначно соответствует Code
+	Title    string              `json:"title"`            // Краткое описание типа ошибки (текст HTTP статуса)
+	Status   int                 `json:"status"`           // HTTP статус ответа
+	Detail   string              `json:"detail,omitempty"` // Описание конкретного случая
+	Instance string              `json:"instance"`         // Путь запроса, на который получена ошибка
+	Code     string              `json:"code"`             // Стабильный машиночитаемый код ошибки
+	Errors   []domain.FieldError `json:"errors,omitempty"` // Ошибки отдельных полей запроса
+}
+
+// ProblemContentType - тип содержимого ошибок в формате RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix - пространство имен URI типов ошибок. Тип строится из стабильного кода ошибки
+const problemTypePrefix = "urn:messenger-auth:problem:"
+
+// NewProblem создает описание ошибки. Тип и заголовок выводятся из кода ошибки и HTTP статуса
+func NewProblem(status int, code string, detail string, instance string, fields []domain.FieldError) Problem {
+	return Problem{
+		Type:     problemTypePrefix + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+		Errors:   fields,
+	}
+}
@@ -0,0 +1,22 @@
+package dto
+
+import "time"
+
+// ClientInfo - данные устройства, с которого выполняется вход или обновление токенов
+type ClientInfo struct {
+	DeviceName string // Название устройства из заголовка X-Device-Name (если не задано, строится по User-Agent)
+	UserAgent  string
+	IP         string
+}
+
+// SessionInfo - сессия пользователя (вход на одном устройстве) в ответе GET /auth/api/v1/sessions
+type SessionInfo struct {
+	ID         uint      `json:"id"`
+	DeviceName string    `json:"deviceName"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"` // Сессия, которой принадлежит access токен запроса
+}
@@ -0,0 +1,38 @@
+package dto
+
+// TokenData - пара токенов, выдаваемая клиенту после успешной аутентификации
+type TokenData struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"` // Время жизни access токена в секундах
+	Scope        string `json:"-"`         // Scope, с которыми выданы токены клиенту OAuth
+}
+
+// LoginResult - ответ на вход по паролю. При включенной двухфакторной аутентификации
+// вместо токенов возвращается промежуточный mfaToken, который обменивается на токены вместе с кодом TOTP
+type LoginResult struct {
+	*TokenData
+	MFARequired bool   `json:"mfaRequired,omitempty"`
+	MFAToken    string `json:"mfaToken,omitempty"`
+}
+
+type RefreshData struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// JWK - открытый ключ в формате RFC 7517 (только поля, нужные для Ed25519 и RSA)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"` // Ed25519
+	X         string `json:"x,omitempty"`   // Ed25519
+	N         string `json:"n,omitempty"`   // RSA
+	E         string `json:"e,omitempty"`   // RSA
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
@@ -1,28 +1,92 @@
 package dto
 
 import (
+	"time"
+
 	"messenger-auth/internal/models"
 	"messenger-auth/internal/utils"
 )
 
 // UserData представляет данные проекта, которые может установить пользователь
 type UserData struct {
-	Name     *string `json:"name" gorm:"type:varchar(255);not null"` // Имя (никнейм) пользователя
-	Email    *string `json:"email" gorm:"type:varchar(255);not null"` // Электронная почта пользователя
+	Name      *string `json:"name" gorm:"type:varchar(255);not null" binding:"omitempty,min=1,max=255"`  // Имя (никнейм) пользователя
+	FirstName *string `json:"firstName" gorm:"type:varchar(255)" binding:"omitempty,max=255"`            // Имя
+	LastName  *string `json:"lastName" gorm:"type:varchar(255)" binding:"omitempty,max=255"`             // Фамилия
+	Email     *string `json:"email" gorm:"type:varchar(255);not null" binding:"omitempty,email,max=255"` // Электронная почта пользователя
+
+	HideFromSearch      *bool `json:"hideFromSearch"`      // Не находить пользователя по имени и логину
+	HideFromEmailSearch *bool `json:"hideFromEmailSearch"` // Не находить пользователя по точному адресу почты
+}
+
+// CreateUserData - данные для создания пользователя администратором. Логин и пароль задаются только при создании
+type CreateUserData struct {
+	UserData
+	Login    *string `json:"login" binding:"omitempty,min=3,max=50"`
+	Password *string `json:"password" binding:"omitempty,min=8,max=50"`
 }
 
 // Parse достает данные из модели и вставляет их в UserData
 func (dto *UserData) Parse(user *models.User) error {
 	utils.CopyIfNotNil(&dto.Name, user.Name)
+	utils.CopyIfNotNil(&dto.FirstName, user.FirstName)
+	utils.CopyIfNotNil(&dto.LastName, user.LastName)
 	utils.CopyIfNotNil(&dto.Email, user.Email)
-	
+	dto.HideFromSearch = &user.HideFromSearch
+	dto.HideFromEmailSearch = &user.HideFromEmailSearch
+
 	return nil
 }
 
 // Map обновляет данные модели значениями из UserData
 func (dto *UserData) Map(user *models.User) error {
-	utils.CopyIfNotNil(&dto.Name, user.Name)
-	utils.CopyIfNotNil(&dto.Email, user.Email)
+	utils.CopyIfNotNil(&user.Name, dto.Name)
+	utils.CopyIfNotNil(&user.FirstName, dto.FirstName)
+	utils.CopyIfNotNil(&user.LastName, dto.LastName)
+	utils.CopyIfNotNil(&user.Email, dto.Email)
+	if dto.HideFromSearch != nil {
+		user.HideFromSearch = *dto.HideFromSearch
+	}
+	if dto.HideFromEmailSearch != nil {
+		user.HideFromEmailSearch = *dto.HideFromEmailSearch
+	}
 
 	return nil
 }
+
+// UserListQuery - параметры запроса списка пользователей (query string)
+type UserListQuery struct {
+	Limit         int        `form:"limit" binding:"omitempty,min=1,max=100"` // Размер страницы, по умолчанию 20
+	Cursor        string     `form:"cursor"`                                  // next_cursor предыдущей страницы
+	Sort          string     `form:"sort"`                                    // Поле сортировки, "-" в начале - по убыванию. По умолчанию id
+	EmailDomain   string     `form:"email_domain" binding:"omitempty,max=255"`
+	CreatedAfter  *time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore *time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	RoleID        uint       `form:"role_id"`
+	Deleted       string     `form:"deleted" binding:"omitempty,oneof=exclude include only"` // Удаленные пользователи: exclude, include или only
+	IncludeTotal  bool       `form:"include_total"`                                          // Посчитать общее число пользователей под фильтром
+
+	// Без limit вернуть всех пользователей одной страницей. Не берется из запроса: так работает v1 без limit и cursor
+	// для администраторов (users:manage)
+	Unpaginated bool `form:"-"`
+}
+
+// PageMeta - данные пагинации в ответе со списком
+type PageMeta struct {
+	NextCursor *string `json:"next_cursor"`     // Курсор следующей страницы, null на последней странице
+	Total      *int64  `json:"total,omitempty"` // Общее число записей под фильтром, если запрошено include_total
+}
+
+// UserSearchQuery - параметры поиска контактов (query string)
+type UserSearchQuery struct {
+	Q     string `form:"q" binding:"required,min=2,max=100"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=20"` // Число результатов, по умолчанию 10
+}
+
+// UserSearchResult - публичный профиль в результатах поиска. Почта не возвращается даже при поиске по ней
+type UserSearchResult struct {
+	ID        uint    `json:"id"`
+	Login     *string `json:"login"`
+	Name      *string `json:"name"`
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+}
@@ -0,0 +1,87 @@
+package dto
+
+// Структуры церемоний WebAuthn в формате, который ожидают navigator.credentials.create/get.
+// Бинарные поля передаются в base64url без дополнения
+
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions - параметры для navigator.credentials.create
+type WebAuthnCreationOptions struct {
+	PublicKey struct {
+		RelyingParty           WebAuthnRelyingParty           `json:"rp"`
+		User                   WebAuthnUser                   `json:"user"`
+		Challenge              string                         `json:"challenge"`
+		PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                          `json:"timeout"`
+		ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+		AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                         `json:"attestation"`
+	} `json:"publicKey"`
+}
+
+// WebAuthnRequestOptions - параметры для navigator.credentials.get
+type WebAuthnRequestOptions struct {
+	PublicKey struct {
+		Challenge        string                         `json:"challenge"`
+		Timeout          int64                          `json:"timeout"`
+		RelyingPartyID   string                         `json:"rpId"`
+		AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+		UserVerification string                         `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AttestationObject string `json:"attestationObject" binding:"required"`
+}
+
+// WebAuthnRegistrationData - результат navigator.credentials.create
+type WebAuthnRegistrationData struct {
+	ID       string                      `json:"id" binding:"required"`
+	Type     string                      `json:"type" binding:"required,eq=public-key"`
+	Response WebAuthnAttestationResponse `json:"response" binding:"required"`
+	Name     string                      `json:"name" binding:"max=255"` // Название устройства для списка ключей
+}
+
+// WebAuthnLoginBeginData - логин необязателен: без него браузер предложит ключи, сохраненные на устройстве
+type WebAuthnLoginBeginData struct {
+	Login string `json:"login" binding:"max=50"`
+}
+
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// WebAuthnAssertionData - результат navigator.credentials.get
+type WebAuthnAssertionData struct {
+	ID       string                    `json:"id" binding:"required"`
+	Type     string                    `json:"type" binding:"required,eq=public-key"`
+	Response WebAuthnAssertionResponse `json:"response" binding:"required"`
+}
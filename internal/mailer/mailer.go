@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+
+	"messenger-auth/config"
+)
+
+// Message - письмо пользователю (только текст)
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer создает отправщика писем по настройке MAILER: smtp или outbox (письма складываются в файлы)
+func NewMailer(config *config.Config, logger *slog.Logger) (Mailer, error) {
+	switch config.Mailer {
+	case "smtp":
+		return NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom, logger), nil
+	case "outbox":
+		return NewOutboxMailer(config.MailOutboxDir, config.MailFrom, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", config.Mailer)
+	}
+}
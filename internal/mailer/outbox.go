@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OutboxMailer складывает письма в .eml файлы в каталоге Dir вместо отправки.
+// Используется при локальном запуске и в тестах
+type OutboxMailer struct {
+	Dir  string
+	From string
+	Log  *slog.Logger
+}
+
+func NewOutboxMailer(dir, from string, logger *slog.Logger) Mailer {
+	return &OutboxMailer{Dir: dir, From: from, Log: logger}
+}
+
+func (m *OutboxMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		m.Log.Error(fmt.Sprintf("Failed to create outbox dir. Error: %s", err.Error()), slog.String("dir", m.Dir))
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, formatMessage(m.From, msg), 0o600); err != nil {
+		m.Log.Error(fmt.Sprintf("Failed to write email to outbox. Error: %s", err.Error()), slog.String("path", path))
+		return err
+	}
+	m.Log.Debug("Email was written to outbox", slog.String("path", path), slog.String("subject", msg.Subject))
+	return nil
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer отправляет письма через SMTP сервер
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Log      *slog.Logger
+}
+
+func NewSMTPMailer(host, port, username, password, from string, logger *slog.Logger) Mailer {
+	return &SMTPMailer{Host: host, Port: port, Username: username, Password: password, From: from, Log: logger}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	if err := smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, formatMessage(m.From, msg)); err != nil {
+		m.Log.Error(fmt.Sprintf("Failed to send email. Error: %s", err.Error()), slog.String("subject", msg.Subject))
+		return err
+	}
+	m.Log.Debug("Email was sent", slog.String("subject", msg.Subject))
+	return nil
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ключи контекста запроса, в которые кладутся данные аутентифицированного пользователя
+const (
+	ContextUserID       = "auth_user_id"
+	ContextRole         = "auth_role"
+	ContextScope        = "auth_scope"
+	ContextAuthTime     = "auth_time"
+	ContextSessionID    = "auth_session_id"
+	ContextClaims       = "auth_claims"
+	contextAuthDisabled = "auth_disabled"
+)
+
+// Authenticate проверяет bearer access токен первой стороны и кладет id и роль пользователя в контекст запроса.
+// Токены клиентов OAuth отклоняются. При enabled == false (AUTH_ENABLED=false, локальная разработка)
+// пропускает все запросы без проверки
+func Authenticate(authService services.AuthService, enabled bool, log *slog.Logger) gin.HandlerFunc {
+	return authenticate(authService.VerifyAccessToken, enabled, log)
+}
+
+// AuthenticateClient как Authenticate, но принимает и токены клиентов OAuth. Ставится только на эндпоинты,
+// которые проверяют scope токена (/userinfo)
+func AuthenticateClient(authService services.AuthService, enabled bool, log *slog.Logger) gin.HandlerFunc {
+	return authenticate(authService.VerifyClientAccessToken, enabled, log)
+}
+
+func authenticate(verify func(accessToken string) (*services.AccessClaims, error), enabled bool, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Set(contextAuthDisabled, true)
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			log.Info("Request was rejected. Error: missing bearer token", slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			c.Header("WWW-Authenticate", `Bearer realm="messenger"`)
+			abortWithError(c, http.StatusUnauthorized, domain.CodeUnauthorized, "Authorization required")
+			return
+		}
+
+		claims, err := verify(token)
+		if err != nil {
+			if errors.Is(err, services.ErrTokenMalformed) || errors.Is(err, services.ErrTokenExpired) || errors.Is(err, services.ErrTokenRevoked) || errors.Is(err, services.ErrTokenThirdParty) {
+				log.Info(fmt.Sprintf("Request was rejected. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusUnauthorized), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+				c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="messenger", error="invalid_token", error_description=%q`, err.Error()))
+				abortWithError(c, http.StatusUnauthorized, domain.CodeInvalidToken, err.Error())
+				return
+			}
+			log.Error(fmt.Sprintf("Failed to verify access token. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.Int("code", http.StatusInternalServerError), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+			abortWithError(c, http.StatusInternalServerError, domain.CodeInternal, "Failed to verify access token")
+			return
+		}
+
+		userID, _ := claims.UserID()
+		c.Set(ContextUserID, userID)
+		c.Set(ContextRole, claims.Role)
+		c.Set(ContextScope, claims.Scope)
+		c.Set(ContextAuthTime, claims.AuthTime)
+		c.Set(ContextSessionID, claims.SessionID)
+		c.Set(ContextClaims, claims)
+		c.Next()
+	}
+}
+
+// RequirePermission пропускает пользователей, роль которых дает право permission
+func RequirePermission(roleService services.RoleService, permission string, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authDisabled(c) || hasPermission(c, roleService, permission, log) {
+			c.Next()
+			return
+		}
+		abortWithError(c, http.StatusForbidden, domain.CodeForbidden, "Forbidden")
+	}
+}
+
+// RequireSelfOrPermission пропускает владельца записи (id пользователя в параметре пути param)
+// и пользователей, роль которых дает право permission
+func RequireSelfOrPermission(roleService services.RoleService, param string, permission string, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authDisabled(c) {
+			c.Next()
+			return
+		}
+		userID, ok := CurrentUserID(c)
+		targetID, err := strconv.ParseUint(c.Param(param), 10, 32)
+		if ok && err == nil && uint(targetID) == userID {
+			c.Next()
+			return
+		}
+		if hasPermission(c, roleService, permission, log) {
+			c.Next()
+			return
+		}
+		abortWithError(c, http.StatusForbidden, domain.CodeForbidden, "Forbidden")
+	}
+}
+
+// CurrentUserID возвращает id аутентифицированного пользователя
+func CurrentUserID(c *gin.Context) (uint, bool) {
+	value, ok := c.Get(ContextUserID)
+	if !ok {
+		return 0, false
+	}
+	userID, ok := value.(uint)
+	return userID, ok
+}
+
+// CurrentRole возвращает служебную роль аутентифицированного пользователя
+func CurrentRole(c *gin.Context) (uint, bool) {
+	value, ok := c.Get(ContextRole)
+	if !ok {
+		return 0, false
+	}
+	role, ok := value.(uint)
+	return role, ok
+}
+
+// CurrentScope возвращает scope access токена (пусто у токенов первой стороны)
+func CurrentScope(c *gin.Context) string {
+	return c.GetString(ContextScope)
+}
+
+// CurrentAuthTime возвращает время входа пользователя из access токена (нулевое, если оно неизвестно)
+func CurrentAuthTime(c *gin.Context) time.Time {
+	authTime := c.GetInt64(ContextAuthTime)
+	if authTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(authTime, 0)
+}
+
+// CurrentSessionID возвращает сессию (семейство refresh токенов), в которой выдан access токен
+func CurrentSessionID(c *gin.Context) string {
+	return c.GetString(ContextSessionID)
+}
+
+// CurrentClaims возвращает проверенные claims access токена запроса
+func CurrentClaims(c *gin.Context) (*services.AccessClaims, bool) {
+	value, ok := c.Get(ContextClaims)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*services.AccessClaims)
+	return claims, ok
+}
+
+// HasPermission сообщает, дает ли роль аутентифицированного пользователя право permission.
+// Нужна обработчикам, поведение которых зависит от прав (при AUTH_ENABLED=false разрешено все)
+func HasPermission(c *gin.Context, roleService services.RoleService, permission string, log *slog.Logger) bool {
+	return authDisabled(c) || hasPermission(c, roleService, permission, log)
+}
+
+func hasPermission(c *gin.Context, roleService services.RoleService, permission string, log *slog.Logger) bool {
+	role, ok := CurrentRole(c)
+	if !ok {
+		return false
+	}
+	allowed, err := roleService.HasPermission(role, permission)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to check permission. Error: %s", err.Error()), slog.String("permission", permission), slog.Uint64("role_id", uint64(role)))
+		return false
+	}
+	return allowed
+}
+
+func authDisabled(c *gin.Context) bool {
+	return c.GetBool(contextAuthDisabled)
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated помечает ответы устаревшего API заголовками Deprecation (RFC 9745) и Sunset (RFC 8594).
+// successor - путь версии API, которая заменяет устаревшую, передается в Link с rel="successor-version"
+func Deprecated(deprecatedAt time.Time, sunset time.Time, successor string) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", deprecatedAt.Unix())
+	sunsetDate := sunset.UTC().Format(http.TimeFormat)
+	link := fmt.Sprintf(`<%s>; rel="successor-version"`, successor)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunsetDate)
+		c.Header("Link", link)
+		c.Next()
+	}
+}
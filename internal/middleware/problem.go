@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"messenger-auth/internal/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextProblemJSON - ключ контекста, включающий ответы middleware в формате application/problem+json
+const contextProblemJSON = "problem_json"
+
+// ProblemJSON включает для группы маршрутов ошибки middleware в формате RFC 7807 (API v2).
+// Ставится первым в группе, до Authenticate и RateLimit
+func ProblemJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextProblemJSON, true)
+		c.Next()
+	}
+}
+
+// abortWithError прерывает запрос ошибкой в формате группы маршрутов: {"error": ..., "code": ...} или application/problem+json
+func abortWithError(c *gin.Context, status int, code string, message string) {
+	if c.GetBool(contextProblemJSON) {
+		c.Header("Content-Type", dto.ProblemContentType)
+		c.AbortWithStatusJSON(status, dto.NewProblem(status, code, message, c.Request.URL.Path, nil))
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": message, "code": code})
+}
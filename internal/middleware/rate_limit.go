@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/services"
+)
+
+// RateLimit ограничивает частоту запросов к маршруту. Аутентифицированные пользователи ограничиваются
+// по id, поэтому в группах с проверкой токена middleware ставится после Authenticate; остальные - по IP.
+// Ошибка ограничителя не блокирует запрос
+func RateLimit(rateLimitService services.RateLimitService, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := services.RateLimitPrincipal{Kind: services.PrincipalAnonymous, ID: c.ClientIP()}
+		if userID, ok := CurrentUserID(c); ok {
+			principal = services.RateLimitPrincipal{Kind: services.PrincipalUser, ID: strconv.FormatUint(uint64(userID), 10)}
+		}
+
+		err := rateLimitService.Allow(c.Request.Method+" "+c.FullPath(), principal)
+		if err != nil {
+			var tooMany *services.TooManyRequestsError
+			if errors.As(err, &tooMany) {
+				log.Info("Request was rejected. Error: too many requests", slog.String("method", c.Request.Method), slog.Int("code", http.StatusTooManyRequests), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+				abortWithError(c, http.StatusTooManyRequests, domain.CodeTooManyRequests, err.Error())
+				return
+			}
+			log.Error(fmt.Sprintf("Failed to check rate limit. Error: %s", err.Error()), slog.String("method", c.Request.Method), slog.String("url", c.Request.URL.Path), slog.String("client", c.ClientIP()))
+		}
+		c.Next()
+	}
+}
+
+// RateLimitInterceptor ограничивает частоту вызовов методов gRPC для каждого вызывающего сервиса
+func RateLimitInterceptor(rateLimitService services.RateLimitService, log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal := services.RateLimitPrincipal{Kind: services.PrincipalService, ID: grpcCaller(ctx)}
+		err := rateLimitService.Allow(info.FullMethod, principal)
+		if err != nil {
+			var tooMany *services.TooManyRequestsError
+			if errors.As(err, &tooMany) {
+				log.Info("Request was rejected. Error: too many requests", slog.String("method", info.FullMethod), slog.String("code", codes.ResourceExhausted.String()), slog.String("client", principal.ID))
+				_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds())))))
+				return nil, status.Error(codes.ResourceExhausted, err.Error())
+			}
+			log.Error(fmt.Sprintf("Failed to check rate limit. Error: %s", err.Error()), slog.String("method", info.FullMethod), slog.String("client", principal.ID))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcCaller возвращает имя сервиса, подтвержденное токеном (ServiceAuthInterceptor), или IP вызывающего.
+// Метаданным, которые передает сам клиент, верить нельзя: иначе каждый запрос мог бы попасть в новый бакет
+func grpcCaller(ctx context.Context) string {
+	if name, ok := CallingService(ctx); ok {
+		return name
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type serviceNameKey struct{}
+
+// ServiceAuthInterceptor проверяет токен вызывающего сервиса (метаданные authorization: Bearer <token>).
+// tokens - имена сервисов по токенам (config.LoadServiceTokens). Вызов с неизвестным токеном отклоняется всегда,
+// вызов без токена - только для методов из protected. Имя сервиса доступно обработчикам через CallingService
+func ServiceAuthInterceptor(tokens map[string]string, protected []string, log *slog.Logger) grpc.UnaryServerInterceptor {
+	// Токены сравниваются по хешам за постоянное время, чтобы по времени ответа нельзя было подобрать токен
+	hashes := make(map[[sha256.Size]byte]string, len(tokens))
+	for token, name := range tokens {
+		hashes[sha256.Sum256([]byte(token))] = name
+	}
+	protectedMethods := make(map[string]bool, len(protected))
+	for _, method := range protected {
+		protectedMethods[method] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token, ok := grpcBearerToken(ctx)
+		if !ok {
+			if protectedMethods[info.FullMethod] {
+				log.Info("Request was rejected. Error: service token is missing", slog.String("method", info.FullMethod), slog.String("code", codes.Unauthenticated.String()))
+				return nil, status.Error(codes.Unauthenticated, "service token is required")
+			}
+			return handler(ctx, req)
+		}
+
+		hash := sha256.Sum256([]byte(token))
+		var name string
+		for known, service := range hashes {
+			if subtle.ConstantTimeCompare(hash[:], known[:]) == 1 {
+				name = service
+			}
+		}
+		if name == "" {
+			log.Info("Request was rejected. Error: service token is invalid", slog.String("method", info.FullMethod), slog.String("code", codes.Unauthenticated.String()))
+			return nil, status.Error(codes.Unauthenticated, "service token is invalid")
+		}
+		return handler(context.WithValue(ctx, serviceNameKey{}, name), req)
+	}
+}
+
+// CallingService возвращает имя сервиса, подтвержденного ServiceAuthInterceptor
+func CallingService(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(serviceNameKey{}).(string)
+	return name, ok && name != ""
+}
+
+func grpcBearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", false
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	return token, ok && token != ""
+}
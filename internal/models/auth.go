@@ -2,9 +2,49 @@ package models
 
 import "time"
 
+// RefreshToken - refresh токен. Сам токен не хранится, только его хеш.
+// Токены, полученные ротацией от одного входа, образуют семейство (FamilyID)
 type RefreshToken struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`         // Уникальный идентификатор токена
-	Token     string    `json:"token" gorm:"unique;not null"` // Токен (уникальный и обязательный)
-	UserID    uint      `json:"userId" gorm:"not null"`       // Идентификатор пользователя
-	ExpiresAt time.Time `json:"expiresAt" gorm:"not null"`    // Время истечения токена
+	ID        uint       `json:"id" gorm:"primaryKey"`                            // Уникальный идентификатор токена
+	TokenHash string     `json:"-" gorm:"type:char(64);unique_index;not null"`    // SHA-256 хеш токена (уникальный и обязательный)
+	FamilyID  string     `json:"familyId" gorm:"type:varchar(64);index;not null"` // Идентификатор семейства токенов
+	UserID    uint       `json:"userId" gorm:"index;not null"`                    // Идентификатор пользователя
+	ClientID  string     `json:"clientId" gorm:"type:varchar(64)"`                // Клиент OAuth, которому выдано семейство (пусто у токенов первой стороны)
+	Scope     string     `json:"scope" gorm:"type:varchar(255)"`                  // Scope, выданные клиенту OAuth (пусто у токенов первой стороны)
+	AuthTime  *time.Time `json:"authTime"`                                        // Время входа, с которого началось семейство
+	ExpiresAt time.Time  `json:"expiresAt" gorm:"not null"`                       // Время истечения токена
+	CreatedAt time.Time  `json:"createdAt" gorm:"not null"`                       // Время выдачи токена
+	UsedAt    *time.Time `json:"usedAt"`                                          // Время обмена токена на новую пару
+	RevokedAt *time.Time `json:"revokedAt"`                                       // Время отзыва токена
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// PasswordResetToken - одноразовый токен сброса пароля. Хранится только хеш токена
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`                         // Уникальный идентификатор токена
+	TokenHash string     `json:"-" gorm:"type:char(64);unique_index;not null"` // SHA-256 хеш токена
+	UserID    uint       `json:"userId" gorm:"index;not null"`                 // Идентификатор пользователя
+	ExpiresAt time.Time  `json:"expiresAt" gorm:"not null"`                    // Время истечения токена
+	CreatedAt time.Time  `json:"createdAt" gorm:"not null"`                    // Время выдачи токена
+	UsedAt    *time.Time `json:"usedAt"`                                       // Время использования токена
+}
+
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// RecoveryCode - одноразовый код восстановления доступа при двухфакторной аутентификации. Хранится только хеш кода
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`            // Уникальный идентификатор кода
+	UserID    uint       `json:"userId" gorm:"index;not null"`    // Идентификатор пользователя
+	CodeHash  string     `json:"-" gorm:"type:char(64);not null"` // SHA-256 хеш кода
+	CreatedAt time.Time  `json:"createdAt" gorm:"not null"`       // Время создания кода
+	UsedAt    *time.Time `json:"usedAt"`                          // Время использования кода
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
 }
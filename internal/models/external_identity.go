@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ExternalIdentity связывает учетную запись у внешнего провайдера OpenID Connect с локальным пользователем
+type ExternalIdentity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`                                                        // Уникальный идентификатор записи
+	UserID      uint       `json:"userId" gorm:"index;not null"`                                                // Идентификатор пользователя
+	Provider    string     `json:"provider" gorm:"type:varchar(50);unique_index:idx_external_subject;not null"` // Имя провайдера (config.OIDCProvider)
+	Subject     string     `json:"subject" gorm:"type:varchar(255);unique_index:idx_external_subject;not null"` // Идентификатор пользователя у провайдера (sub)
+	Email       string     `json:"email" gorm:"type:varchar(255)"`                                              // Почта из ID токена на момент привязки
+	CreatedAt   time.Time  `json:"createdAt" gorm:"not null"`                                                   // Время привязки
+	LastLoginAt *time.Time `json:"lastLoginAt"`                                                                 // Время последнего входа через провайдера
+}
+
+func (ExternalIdentity) TableName() string {
+	return "external_identities"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuthClient - зарегистрированный клиент OAuth 2.0 (веб-приложение, десктоп, бот)
+type OAuthClient struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`                                   // Уникальный идентификатор записи
+	ClientID     string    `json:"clientId" gorm:"type:varchar(64);unique_index;not null"` // Публичный идентификатор клиента
+	SecretHash   *string   `json:"-" gorm:"type:char(64)"`                                 // SHA-256 хеш секрета (nil у публичных клиентов)
+	Name         string    `json:"name" gorm:"type:varchar(255);not null"`                 // Название клиента
+	RedirectURIs string    `json:"-" gorm:"type:text;not null"`                            // Разрешенные redirect_uri через пробел
+	Scopes       string    `json:"-" gorm:"type:text;not null"`                            // Разрешенные scope через пробел
+	Public       bool      `json:"public" gorm:"not null;default:false"`                   // Публичный клиент не хранит секрет и обязан использовать PKCE
+	CreatedAt    time.Time `json:"createdAt" gorm:"not null"`                              // Время регистрации клиента
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// RedirectURIList возвращает разрешенные redirect_uri
+func (client *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(client.RedirectURIs)
+}
+
+// ScopeList возвращает разрешенные scope
+func (client *OAuthClient) ScopeList() []string {
+	return strings.Fields(client.Scopes)
+}
+
+// AllowsRedirectURI проверяет redirect_uri по списку. Сравнение точное, без префиксов и шаблонов
+func (client *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	return slices.Contains(client.RedirectURIList(), redirectURI)
+}
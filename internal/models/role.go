@@ -0,0 +1,47 @@
+package models
+
+// Встроенные роли, создаются миграциями
+const (
+	RoleNameUser       = "user"
+	RoleNameAdmin      = "admin"
+	RoleNameUnverified = "unverified" // Выдается в токенах пользователям с неподтвержденной почтой (EMAIL_VERIFICATION_MODE=restrict)
+)
+
+// Права, которые проверяются в маршрутах (см. SetupHandlers)
+const (
+	PermissionUsersRead     = "users:read"     // Просмотр пользователей
+	PermissionUsersManage   = "users:manage"   // Создание, изменение и удаление любых пользователей
+	PermissionRolesManage   = "roles:manage"   // Просмотр ролей, назначение и отзыв ролей пользователей
+	PermissionClientsManage = "clients:manage" // Регистрация и просмотр клиентов OAuth
+)
+
+// Role - служебная роль пользователя (поле serviceRole в auth.proto)
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`                               // Уникальный идентификатор роли
+	Name        string       `json:"name" gorm:"type:varchar(50);unique_index;not null"` // Название роли
+	Description string       `json:"description" gorm:"type:varchar(255)"`               // Описание роли
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`      // Права роли
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+// HasPermission проверяет, есть ли у роли право permission
+func (role *Role) HasPermission(permission string) bool {
+	for _, p := range role.Permissions {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Permission struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`                                // Уникальный идентификатор права
+	Name string `json:"name" gorm:"type:varchar(100);unique_index;not null"` // Название права
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session - вход пользователя на одном устройстве. Соответствует семейству refresh токенов (RefreshToken.FamilyID)
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`                            // Уникальный идентификатор сессии
+	UserID     uint       `json:"userId" gorm:"index;not null"`                    // Идентификатор пользователя
+	FamilyID   string     `json:"-" gorm:"type:varchar(64);unique_index;not null"` // Семейство refresh токенов сессии
+	DeviceName string     `json:"deviceName" gorm:"type:varchar(255)"`             // Название устройства
+	UserAgent  string     `json:"userAgent" gorm:"type:varchar(512)"`              // User-Agent последнего запроса
+	IP         string     `json:"ip" gorm:"type:varchar(45)"`                      // IP адрес последнего запроса
+	CreatedAt  time.Time  `json:"createdAt" gorm:"not null"`                       // Время входа
+	LastUsedAt time.Time  `json:"lastUsedAt" gorm:"not null"`                      // Время последнего обновления токенов
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`                       // Время истечения последнего refresh токена
+	RevokedAt  *time.Time `json:"revokedAt"`                                       // Время завершения сессии
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SigningKey - ключ подписи JWT. Ключ, у которого RetiredAt не задан, а ActivatesAt наступил, используется для подписи
+// новых токенов. Новый ключ публикуется в JWKS заранее, до ActivatesAt, а выведенные из оборота ключи продолжают
+// публиковаться, пока не истекут подписанные ими токены
+type SigningKey struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`                              // Уникальный идентификатор записи
+	KID         string     `json:"kid" gorm:"type:varchar(64);unique_index;not null"` // Идентификатор ключа (заголовок kid в JWT)
+	Algorithm   string     `json:"alg" gorm:"type:varchar(16);not null"`              // Алгоритм подписи
+	PrivateKey  []byte     `json:"-" gorm:"not null"`                                 // Закрытый ключ в формате PKCS #8 (DER)
+	CreatedAt   time.Time  `json:"createdAt" gorm:"not null"`                         // Время создания ключа
+	ActivatesAt *time.Time `json:"activatesAt"`                                       // С какого момента ключ подписывает токены (nil - сразу)
+	RetiredAt   *time.Time `json:"retiredAt"`                                         // Время вывода ключа из оборота
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
@@ -1,14 +1,32 @@
 package models
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Name     *string `json:"name" gorm:"type:varchar(255);not null"` // Имя (никнейм) пользователя
-	Email    *string `json:"email" gorm:"type:varchar(255);not null"` // Электронная почта пользователя
-	Password *string `json:"password" gorm:"type:varchar(255);not null"` // Пароль пользователя
+	Login     *string `json:"login" gorm:"type:varchar(50);unique_index;not null"` // Логин пользователя
+	Name      *string `json:"name" gorm:"type:varchar(255);not null"`              // Имя (никнейм) пользователя
+	FirstName *string `json:"firstName" gorm:"type:varchar(255)"`                  // Имя
+	LastName  *string `json:"lastName" gorm:"type:varchar(255)"`                   // Фамилия
+	Email     *string `json:"email" gorm:"type:varchar(255);not null"`             // Электронная почта пользователя
+	Password  *string `json:"-" gorm:"type:varchar(255);not null"`                 // Хеш пароля пользователя (PHC argon2id или bcrypt)
+
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`                       // Время подтверждения почты (nil - почта не подтверждена)
+	PendingEmail    *string    `json:"pendingEmail" gorm:"type:varchar(255)"` // Новый адрес, который заменит Email после подтверждения
+
+	TOTPSecret    *string    `json:"-" gorm:"type:varchar(255)"`  // Секрет TOTP, зашифрованный AES-GCM (TOTP_ENCRYPTION_KEY)
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt"`               // Время включения двухфакторной аутентификации (nil - выключена)
+	TOTPLastStep  int64      `json:"-" gorm:"not null;default:0"` // Последний принятый временной шаг TOTP (защита от повторного использования кода)
+
+	ServiceRoleID uint `json:"serviceRoleId" gorm:"not null;index"` // Служебная роль пользователя (models.Role)
+
+	// Настройки приватности поиска контактов
+	HideFromSearch      bool `json:"hideFromSearch" gorm:"not null;default:false"`      // Не находить пользователя по имени и логину
+	HideFromEmailSearch bool `json:"hideFromEmailSearch" gorm:"not null;default:false"` // Не находить пользователя по точному адресу почты
 }
 
 func (User) TableName() string {
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// WebAuthnCredential - ключ доступа (passkey), зарегистрированный пользователем
+type WebAuthnCredential struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`                                         // Уникальный идентификатор записи
+	UserID       uint       `json:"userId" gorm:"index;not null"`                                 // Идентификатор пользователя
+	CredentialID string     `json:"credentialId" gorm:"type:varchar(1400);unique_index;not null"` // Идентификатор ключа у аутентификатора (base64url)
+	PublicKey    []byte     `json:"-" gorm:"type:bytea;not null"`                                 // Открытый ключ в формате COSE
+	Algorithm    int64      `json:"algorithm" gorm:"not null"`                                    // Алгоритм подписи COSE (-7 ES256, -8 EdDSA, -257 RS256)
+	SignCount    int64      `json:"signCount" gorm:"not null;default:0"`                          // Последнее значение счетчика подписей аутентификатора
+	CloneWarning bool       `json:"cloneWarning" gorm:"not null;default:false"`                   // Счетчик подписей не вырос: возможно, аутентификатор склонирован
+	Name         string     `json:"name" gorm:"type:varchar(255)"`                                // Название устройства, заданное пользователем
+	CreatedAt    time.Time  `json:"createdAt" gorm:"not null"`                                    // Время регистрации ключа
+	LastUsedAt   *time.Time `json:"lastUsedAt"`                                                   // Время последнего входа с ключом
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
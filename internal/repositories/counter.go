@@ -0,0 +1,31 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Структура для счетчиков с ограниченным временем жизни (ограничение частоты запросов) в Redis
+type CounterRepoRedis struct {
+	RedisDB *redis.Client
+}
+
+func NewCounterRepoRedis(redisDB *redis.Client) CounterRepository {
+	return &CounterRepoRedis{RedisDB: redisDB}
+}
+
+// Increment увеличивает счетчик key. Окно window начинается с первого увеличения.
+// Возвращает новое значение счетчика и время до сброса
+func (repo *CounterRepoRedis) Increment(key string, window time.Duration) (int64, time.Duration, error) {
+	ctx := context.Background()
+	pipe := repo.RedisDB.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return incr.Val(), ttl.Val(), nil
+}
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с привязками внешних провайдеров в Postgres
+type ExternalIdentityRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewExternalIdentityRepoPostgres(db *gorm.DB, logger *slog.Logger) ExternalIdentityRepository {
+	return &ExternalIdentityRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *ExternalIdentityRepoPostgres) Create(identity *models.ExternalIdentity) error {
+	if err := repo.DB.Create(identity).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create external identity. Error: %s", err.Error()), slog.Uint64("user_id", uint64(identity.UserID)), slog.String("provider", identity.Provider))
+		return err
+	}
+	repo.Log.Debug("External identity was created", slog.Uint64("user_id", uint64(identity.UserID)), slog.String("provider", identity.Provider))
+	return nil
+}
+
+// FindBySubject возвращает gorm.ErrRecordNotFound, если учетная запись провайдера не привязана
+func (repo *ExternalIdentityRepoPostgres) FindBySubject(provider string, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	if err := repo.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (repo *ExternalIdentityRepoPostgres) UpdateLastLogin(id uint, loggedInAt time.Time) error {
+	if err := repo.DB.Model(&models.ExternalIdentity{}).Where("id = ?", id).Update("last_login_at", loggedInAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to update external identity. Error: %s", err.Error()), slog.Uint64("identity_id", uint64(id)))
+		return err
+	}
+	return nil
+}
+
+func (repo *ExternalIdentityRepoPostgres) Delete(id uint) error {
+	if err := repo.DB.Where("id = ?", id).Delete(&models.ExternalIdentity{}).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to delete external identity. Error: %s", err.Error()), slog.Uint64("identity_id", uint64(id)))
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package repositories
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 	"messenger-auth/internal/models"
 )
@@ -8,8 +10,150 @@ import (
 type UserRepository interface {
 	Create(user *models.User) error
 	Update(user *models.User) error
+	ResetPassword(id uint, passwordHash string, resetTokenID uint, usedAt time.Time) (bool, error)
+	AdvanceTOTPStep(id uint, step int64) (bool, error)
 	Delete(id uint) error
 	FindByID(id uint) (*models.User, error)
-	FindAll() ([]models.User, error)
+	FindByIDs(ids []uint) ([]models.User, error)
+	FindByLogin(login string) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	FindPage(query UserPageQuery) (*UserPage, error)
+	Search(text string, excludeID uint, limit int) ([]models.User, error)
+	SearchByEmail(email string, excludeID uint) ([]models.User, error)
 	GetDB() *gorm.DB
 }
+
+// UserSortColumns - поля, по которым можно сортировать список пользователей, и их колонки в users
+var UserSortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"login":      "login",
+	"name":       "name",
+	"email":      "email",
+}
+
+// Режимы выборки удаленных (soft delete) пользователей
+const (
+	UserDeletedExclude = "exclude"
+	UserDeletedInclude = "include"
+	UserDeletedOnly    = "only"
+)
+
+// UserFilter - условия выборки списка пользователей. Пустые поля выборку не ограничивают
+type UserFilter struct {
+	EmailDomain   string     // Домен почты без @, без учета регистра
+	CreatedAfter  *time.Time // Созданы не раньше
+	CreatedBefore *time.Time // Созданы раньше
+	RoleID        uint       // Служебная роль
+	Deleted       string     // UserDeleted*, по умолчанию удаленные не выбираются
+}
+
+// UserPageQuery - запрос страницы списка пользователей. Страница начинается после записи с ключом (AfterValue, AfterID),
+// где AfterValue - значение колонки сортировки у последней записи предыдущей страницы. AfterID == 0 - первая страница
+type UserPageQuery struct {
+	Filter     UserFilter
+	SortBy     string // Ключ UserSortColumns
+	Desc       bool
+	AfterValue interface{}
+	AfterID    uint
+	Limit      int  // 0 - без ограничения
+	WithTotal  bool // Посчитать общее число записей под фильтром
+}
+
+// UserPage - страница списка пользователей
+type UserPage struct {
+	Users   []models.User
+	HasMore bool   // Есть следующая страница
+	Total   *int64 // Общее число записей под фильтром, если запрошено
+}
+
+type SigningKeyRepository interface {
+	Create(key *models.SigningKey) error
+	FindPublished(retiredAfter time.Time) ([]models.SigningKey, error)
+	RetireCreatedBefore(algorithm string, createdBefore time.Time, retiredAt time.Time) error
+	DeleteRetiredBefore(retiredBefore time.Time) error
+}
+
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	FindByHash(tokenHash string) (*models.RefreshToken, error)
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+	RevokeFamily(familyID string, revokedAt time.Time) error
+	RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error
+}
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByID(id uint) (*models.Session, error)
+	FindByFamily(familyID string) (*models.Session, error)
+	FindActiveByUser(userID uint, now time.Time) ([]models.Session, error)
+	Touch(id uint, userAgent string, ip string, usedAt time.Time, expiresAt time.Time) error
+	RevokeFamily(familyID string, revokedAt time.Time) error
+	RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error
+}
+
+type PasswordResetRepository interface {
+	Create(token *models.PasswordResetToken) error
+	FindByHash(tokenHash string) (*models.PasswordResetToken, error)
+}
+
+type RoleRepository interface {
+	FindAll() ([]models.Role, error)
+}
+
+type CounterRepository interface {
+	Increment(key string, window time.Duration) (int64, time.Duration, error)
+}
+
+type KeyValueRepository interface {
+	Set(key string, value interface{}, ttl time.Duration) error
+	Get(key string, dest interface{}) error
+	Take(key string, dest interface{}) error
+	Delete(key string) error
+}
+
+type RecoveryCodeRepository interface {
+	ReplaceForUser(userID uint, codeHashes []string) error
+	MarkUsed(userID uint, codeHash string, usedAt time.Time) (bool, error)
+	DeleteForUser(userID uint) error
+}
+
+type WebAuthnCredentialRepository interface {
+	Create(credential *models.WebAuthnCredential) error
+	FindByCredentialID(credentialID string) (*models.WebAuthnCredential, error)
+	FindByUser(userID uint) ([]models.WebAuthnCredential, error)
+	UpdateSignCount(id uint, signCount int64, usedAt time.Time) (bool, error)
+	MarkCloned(id uint) error
+}
+
+type OAuthClientRepository interface {
+	Create(client *models.OAuthClient) error
+	FindByClientID(clientID string) (*models.OAuthClient, error)
+	FindAll() ([]models.OAuthClient, error)
+}
+
+type ExternalIdentityRepository interface {
+	Create(identity *models.ExternalIdentity) error
+	FindBySubject(provider string, subject string) (*models.ExternalIdentity, error)
+	UpdateLastLogin(id uint, loggedInAt time.Time) error
+	Delete(id uint) error
+}
+
+type TokenDenylistRepository interface {
+	DenyToken(jti string, ttl time.Duration) error
+	DenySession(sessionID string, ttl time.Duration) error
+	SetUserNotBefore(userID uint, notBefore time.Time, ttl time.Duration) error
+	IsDenied(jti string, sessionID string, userID uint, issuedAt time.Time) (bool, error)
+}
+
+type LoginAttemptRepository interface {
+	AddToWindow(key string, member string, window time.Duration) (int64, error)
+	Lock(key string, ttl time.Duration) error
+	NextLevel(levelKey string, levelTTL time.Duration) (int64, error)
+	LockedFor(keys ...string) (time.Duration, error)
+	Clear(keys ...string) error
+}
+
+type RateLimitRepository interface {
+	Take(key string, perSecond float64, burst int64) (bool, time.Duration, error)
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrKeyNotFound возвращается, если ключа нет или истек его срок жизни
+var ErrKeyNotFound = errors.New("key not found")
+
+// Структура для короткоживущих значений (промежуточные токены, challenge и т.п.) в Redis
+type KeyValueRepoRedis struct {
+	RedisDB *redis.Client
+}
+
+func NewKeyValueRepoRedis(redisDB *redis.Client) KeyValueRepository {
+	return &KeyValueRepoRedis{RedisDB: redisDB}
+}
+
+func (repo *KeyValueRepoRedis) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return repo.RedisDB.Set(context.Background(), key, data, ttl).Err()
+}
+
+func (repo *KeyValueRepoRedis) Get(key string, dest interface{}) error {
+	data, err := repo.RedisDB.Get(context.Background(), key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// Take получает значение и сразу удаляет ключ, поэтому значение можно получить только один раз
+func (repo *KeyValueRepoRedis) Take(key string, dest interface{}) error {
+	data, err := repo.RedisDB.GetDel(context.Background(), key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (repo *KeyValueRepoRedis) Delete(key string) error {
+	return repo.RedisDB.Del(context.Background(), key).Err()
+}
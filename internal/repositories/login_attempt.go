@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Структура для учета попыток входа в Redis: скользящие окна на sorted set и блокировки с ограниченным временем жизни
+type LoginAttemptRepoRedis struct {
+	RedisDB *redis.Client
+}
+
+func NewLoginAttemptRepoRedis(redisDB *redis.Client) LoginAttemptRepository {
+	return &LoginAttemptRepoRedis{RedisDB: redisDB}
+}
+
+// AddToWindow добавляет member в скользящее окно key длиной window и возвращает число разных элементов в окне.
+// Повторное добавление того же member только сдвигает его время, поэтому окно может считать как попытки
+// (уникальные member), так и разные учетные записи (member - учетная запись)
+func (repo *LoginAttemptRepoRedis) AddToWindow(key string, member string, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	now := time.Now()
+	pipe := repo.RedisDB.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMicro(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMicro()), Member: member})
+	card := pipe.ZCard(ctx, key)
+	pipe.PExpire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return card.Val(), nil
+}
+
+// Lock ставит блокировку key на время ttl
+func (repo *LoginAttemptRepoRedis) Lock(key string, ttl time.Duration) error {
+	return repo.RedisDB.Set(context.Background(), key, 1, ttl).Err()
+}
+
+// NextLevel увеличивает уровень блокировки levelKey, который хранится levelTTL с последнего увеличения
+func (repo *LoginAttemptRepoRedis) NextLevel(levelKey string, levelTTL time.Duration) (int64, error) {
+	ctx := context.Background()
+	pipe := repo.RedisDB.TxPipeline()
+	incr := pipe.Incr(ctx, levelKey)
+	pipe.Expire(ctx, levelKey, levelTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// LockedFor возвращает наибольшее оставшееся время блокировки среди keys (0 - блокировок нет)
+func (repo *LoginAttemptRepoRedis) LockedFor(keys ...string) (time.Duration, error) {
+	ctx := context.Background()
+	pipe := repo.RedisDB.Pipeline()
+	ttls := make([]*redis.DurationCmd, 0, len(keys))
+	for _, key := range keys {
+		ttls = append(ttls, pipe.PTTL(ctx, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	for _, ttl := range ttls {
+		// Для отсутствующего ключа PTTL возвращает отрицательное значение
+		if ttl.Val() > longest {
+			longest = ttl.Val()
+		}
+	}
+	return longest, nil
+}
+
+// Clear удаляет окна, блокировки и уровни keys
+func (repo *LoginAttemptRepoRedis) Clear(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return repo.RedisDB.Del(context.Background(), keys...).Err()
+}
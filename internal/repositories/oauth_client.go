@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с клиентами OAuth в Postgres
+type OAuthClientRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewOAuthClientRepoPostgres(db *gorm.DB, logger *slog.Logger) OAuthClientRepository {
+	return &OAuthClientRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *OAuthClientRepoPostgres) Create(client *models.OAuthClient) error {
+	if err := repo.DB.Create(client).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create oauth client in DB. Error: %s", err.Error()), slog.String("client_id", client.ClientID))
+		return err
+	}
+	repo.Log.Debug("OAuth client was created in DB", slog.String("client_id", client.ClientID))
+	return nil
+}
+
+func (repo *OAuthClientRepoPostgres) FindByClientID(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := repo.DB.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (repo *OAuthClientRepoPostgres) FindAll() ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	if err := repo.DB.Order("id").Find(&clients).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to get oauth clients from DB. Error: %s", err.Error()))
+		return nil, err
+	}
+	return clients, nil
+}
@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с токенами сброса пароля в Postgres
+type PasswordResetRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewPasswordResetRepoPostgres(db *gorm.DB, logger *slog.Logger) PasswordResetRepository {
+	return &PasswordResetRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *PasswordResetRepoPostgres) Create(token *models.PasswordResetToken) error {
+	if err := repo.DB.Create(token).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create password reset token in DB. Error: %s", err.Error()), slog.Uint64("user_id", uint64(token.UserID)))
+		return err
+	}
+	repo.Log.Debug("Password reset token was created in DB", slog.Uint64("user_id", uint64(token.UserID)))
+	return nil
+}
+
+func (repo *PasswordResetRepoPostgres) FindByHash(tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	if err := repo.DB.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokenScript атомарно пополняет bucket по прошедшему времени и забирает один токен.
+// Время берется из Redis, чтобы расхождение часов реплик не влияло на ограничение.
+// Возвращает 1 и 0, если токен получен, или 0 и число миллисекунд до появления токена
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, wait}
+`)
+
+// Структура для token bucket в Redis: ограничение действует сразу на все реплики сервиса
+type RateLimitRepoRedis struct {
+	RedisDB *redis.Client
+}
+
+func NewRateLimitRepoRedis(redisDB *redis.Client) RateLimitRepository {
+	return &RateLimitRepoRedis{RedisDB: redisDB}
+}
+
+// Take забирает токен из bucket key, который пополняется на perSecond токенов в секунду до burst.
+// Если токенов нет, возвращает false и время до появления следующего
+func (repo *RateLimitRepoRedis) Take(key string, perSecond float64, burst int64) (bool, time.Duration, error) {
+	perMillisecond := strconv.FormatFloat(perSecond/1000, 'g', -1, 64)
+	result, err := takeTokenScript.Run(context.Background(), repo.RedisDB, []string{key}, perMillisecond, burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
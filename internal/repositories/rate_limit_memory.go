@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"sync"
+	"time"
+)
+
+// Структура для token bucket в памяти процесса. Используется, пока Redis недоступен:
+// ограничение действует отдельно на каждой реплике
+type RateLimitRepoMemory struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	// Момент, когда bucket снова будет полным и его можно удалить
+	full time.Time
+}
+
+// Как часто удаляются полные bucket, чтобы память не росла с числом клиентов
+const memoryBucketSweepInterval = time.Minute
+
+func NewRateLimitRepoMemory() RateLimitRepository {
+	return &RateLimitRepoMemory{buckets: make(map[string]*memoryBucket)}
+}
+
+func (repo *RateLimitRepoMemory) Take(key string, perSecond float64, burst int64) (bool, time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(repo.lastSweep) > memoryBucketSweepInterval {
+		for bucketKey, bucket := range repo.buckets {
+			if now.After(bucket.full) {
+				delete(repo.buckets, bucketKey)
+			}
+		}
+		repo.lastSweep = now
+	}
+
+	bucket, ok := repo.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(burst), updated: now}
+		repo.buckets[key] = bucket
+	}
+	bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	allowed := bucket.tokens >= 1
+	var wait time.Duration
+	if allowed {
+		bucket.tokens--
+	} else {
+		wait = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.full = now.Add(time.Duration((float64(burst) - bucket.tokens) / perSecond * float64(time.Second)))
+	return allowed, wait, nil
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с кодами восстановления в Postgres
+type RecoveryCodeRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewRecoveryCodeRepoPostgres(db *gorm.DB, logger *slog.Logger) RecoveryCodeRepository {
+	return &RecoveryCodeRepoPostgres{DB: db, Log: logger}
+}
+
+// ReplaceForUser удаляет старые коды пользователя и сохраняет новые в одной транзакции
+func (repo *RecoveryCodeRepoPostgres) ReplaceForUser(userID uint, codeHashes []string) error {
+	now := time.Now()
+	err := repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, codeHash := range codeHashes {
+			if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: codeHash, CreatedAt: now}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to replace recovery codes. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return err
+	}
+	repo.Log.Debug("Recovery codes were replaced", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+// MarkUsed помечает неиспользованный код пользователя использованным. Возвращает false, если такого кода нет
+func (repo *RecoveryCodeRepoPostgres) MarkUsed(userID uint, codeHash string, usedAt time.Time) (bool, error) {
+	result := repo.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).Update("used_at", usedAt)
+	if result.Error != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to use recovery code. Error: %s", result.Error.Error()), slog.Uint64("user_id", uint64(userID)))
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (repo *RecoveryCodeRepoPostgres) DeleteForUser(userID uint) error {
+	if err := repo.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to delete recovery codes. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с refresh токенами в Postgres
+type RefreshTokenRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewRefreshTokenRepoPostgres(db *gorm.DB, logger *slog.Logger) RefreshTokenRepository {
+	return &RefreshTokenRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *RefreshTokenRepoPostgres) Create(token *models.RefreshToken) error {
+	if err := repo.DB.Create(token).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create refresh token in DB. Error: %s", err.Error()), slog.Uint64("user_id", uint64(token.UserID)))
+		return err
+	}
+	repo.Log.Debug("Refresh token was created in DB", slog.Uint64("user_id", uint64(token.UserID)), slog.String("family_id", token.FamilyID))
+	return nil
+}
+
+func (repo *RefreshTokenRepoPostgres) FindByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := repo.DB.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed помечает токен использованным. Возвращает false, если токен уже был использован
+// (в том числе параллельным запросом) - это решается одним условным UPDATE
+func (repo *RefreshTokenRepoPostgres) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := repo.DB.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", usedAt)
+	if result.Error != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to mark refresh token as used. Error: %s", result.Error.Error()), slog.Uint64("token_id", uint64(id)))
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (repo *RefreshTokenRepoPostgres) RevokeFamily(familyID string, revokedAt time.Time) error {
+	if err := repo.DB.Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", revokedAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to revoke refresh token family. Error: %s", err.Error()), slog.String("family_id", familyID))
+		return err
+	}
+	repo.Log.Debug("Refresh token family was revoked", slog.String("family_id", familyID))
+	return nil
+}
+
+// RevokeAllForUser отзывает refresh токены пользователя, кроме семейства exceptFamilyID (пусто - отозвать все)
+func (repo *RefreshTokenRepoPostgres) RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error {
+	if err := repo.DB.Model(&models.RefreshToken{}).Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, exceptFamilyID).Update("revoked_at", revokedAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to revoke refresh tokens of user. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return err
+	}
+	repo.Log.Debug("Refresh tokens of user were revoked", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
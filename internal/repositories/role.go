@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Встроенные роли и их права. Используются миграциями для начального заполнения таблиц.
+// Revoked - права, которые роль получала в прошлых версиях; миграции отзывают их в существующих базах
+var builtinRoles = []struct {
+	Name        string
+	Description string
+	Permissions []string
+	Revoked     []string
+}{
+	{
+		// Обычные пользователи ищут контакты через /user/api/v2/search: список пользователей отдает почту
+		// и фильтры по ней, поэтому users:read есть только у администраторов
+		Name:        models.RoleNameUser,
+		Description: "Regular messenger user",
+		Permissions: []string{},
+		Revoked:     []string{models.PermissionUsersRead},
+	},
+	{
+		Name:        models.RoleNameUnverified,
+		Description: "User with unconfirmed email",
+		Permissions: []string{},
+	},
+	{
+		Name:        models.RoleNameAdmin,
+		Description: "Administrator",
+		Permissions: []string{models.PermissionUsersRead, models.PermissionUsersManage, models.PermissionRolesManage, models.PermissionClientsManage},
+	},
+}
+
+// SeedRoles создает встроенные роли и права, если их еще нет, и отзывает у встроенных ролей права из Revoked
+func SeedRoles(db *gorm.DB) error {
+	for _, builtin := range builtinRoles {
+		var role models.Role
+		if err := db.Where(models.Role{Name: builtin.Name}).Attrs(models.Role{Description: builtin.Description}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+		for _, name := range builtin.Permissions {
+			var permission models.Permission
+			if err := db.Where(models.Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+				return err
+			}
+			if err := db.Model(&role).Association("Permissions").Append(&permission).Error; err != nil {
+				return err
+			}
+		}
+		for _, name := range builtin.Revoked {
+			var permission models.Permission
+			err := db.Where(models.Permission{Name: name}).First(&permission).Error
+			if gorm.IsRecordNotFoundError(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := db.Model(&role).Association("Permissions").Delete(&permission).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Структура для работы с ролями в Postgres
+type RoleRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewRoleRepoPostgres(db *gorm.DB, logger *slog.Logger) RoleRepository {
+	return &RoleRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *RoleRepoPostgres) FindAll() ([]models.Role, error) {
+	var roles []models.Role
+	if err := repo.DB.Preload("Permissions").Order("id").Find(&roles).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to get roles. Error: %s", err.Error()))
+		return nil, err
+	}
+	repo.Log.Debug("Roles were received from DB")
+	return roles, nil
+}
@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с сессиями пользователей в Postgres
+type SessionRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewSessionRepoPostgres(db *gorm.DB, logger *slog.Logger) SessionRepository {
+	return &SessionRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *SessionRepoPostgres) Create(session *models.Session) error {
+	if err := repo.DB.Create(session).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create session. Error: %s", err.Error()), slog.Uint64("user_id", uint64(session.UserID)))
+		return err
+	}
+	repo.Log.Debug("Session was created", slog.Uint64("user_id", uint64(session.UserID)), slog.Uint64("session_id", uint64(session.ID)))
+	return nil
+}
+
+// FindByID возвращает gorm.ErrRecordNotFound, если сессии нет
+func (repo *SessionRepoPostgres) FindByID(id uint) (*models.Session, error) {
+	var session models.Session
+	if err := repo.DB.Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindByFamily возвращает gorm.ErrRecordNotFound, если у семейства refresh токенов нет сессии
+func (repo *SessionRepoPostgres) FindByFamily(familyID string) (*models.Session, error) {
+	var session models.Session
+	if err := repo.DB.Where("family_id = ?", familyID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindActiveByUser возвращает незавершенные и неистекшие сессии пользователя, от старых к новым
+func (repo *SessionRepoPostgres) FindActiveByUser(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := repo.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).Order("created_at, id").Find(&sessions).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to find sessions. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Touch обновляет данные устройства и срок действия сессии после обновления токенов
+func (repo *SessionRepoPostgres) Touch(id uint, userAgent string, ip string, usedAt time.Time, expiresAt time.Time) error {
+	err := repo.DB.Model(&models.Session{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"user_agent":   userAgent,
+		"ip":           ip,
+		"last_used_at": usedAt,
+		"expires_at":   expiresAt,
+	}).Error
+	if err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to update session. Error: %s", err.Error()), slog.Uint64("session_id", uint64(id)))
+		return err
+	}
+	return nil
+}
+
+func (repo *SessionRepoPostgres) RevokeFamily(familyID string, revokedAt time.Time) error {
+	if err := repo.DB.Model(&models.Session{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", revokedAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to revoke session. Error: %s", err.Error()), slog.String("family_id", familyID))
+		return err
+	}
+	repo.Log.Debug("Session was revoked", slog.String("family_id", familyID))
+	return nil
+}
+
+// RevokeAllForUser завершает все сессии пользователя, кроме сессии семейства exceptFamilyID (пусто - завершить все)
+func (repo *SessionRepoPostgres) RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error {
+	if err := repo.DB.Model(&models.Session{}).Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, exceptFamilyID).Update("revoked_at", revokedAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to revoke sessions of user. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return err
+	}
+	repo.Log.Debug("Sessions of user were revoked", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с ключами подписи в Postgres
+type SigningKeyRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewSigningKeyRepoPostgres(db *gorm.DB, logger *slog.Logger) SigningKeyRepository {
+	return &SigningKeyRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *SigningKeyRepoPostgres) Create(key *models.SigningKey) error {
+	if err := repo.DB.Create(key).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create signing key in DB. Error: %s", err.Error()), slog.String("kid", key.KID))
+		return err
+	}
+	repo.Log.Debug("Signing key was created in DB", slog.String("kid", key.KID))
+	return nil
+}
+
+// FindPublished возвращает действующие ключи и ключи, выведенные из оборота после retiredAfter (от новых к старым)
+func (repo *SigningKeyRepoPostgres) FindPublished(retiredAfter time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	if err := repo.DB.Where("retired_at IS NULL OR retired_at > ?", retiredAfter).Order("created_at DESC").Find(&keys).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to get signing keys. Error: %s", err.Error()))
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RetireCreatedBefore выводит из оборота действующие ключи алгоритма algorithm, созданные раньше createdBefore
+func (repo *SigningKeyRepoPostgres) RetireCreatedBefore(algorithm string, createdBefore time.Time, retiredAt time.Time) error {
+	if err := repo.DB.Model(&models.SigningKey{}).Where("algorithm = ? AND created_at < ? AND retired_at IS NULL", algorithm, createdBefore).Update("retired_at", retiredAt).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to retire signing keys. Error: %s", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (repo *SigningKeyRepoPostgres) DeleteRetiredBefore(retiredBefore time.Time) error {
+	if err := repo.DB.Where("retired_at IS NOT NULL AND retired_at <= ?", retiredBefore).Delete(&models.SigningKey{}).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to delete retired signing keys. Error: %s", err.Error()))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Структура для списка отозванных access токенов в Redis. Записи живут не дольше самих токенов
+type TokenDenylistRepoRedis struct {
+	RedisDB *redis.Client
+}
+
+func NewTokenDenylistRepoRedis(redisDB *redis.Client) TokenDenylistRepository {
+	return &TokenDenylistRepoRedis{RedisDB: redisDB}
+}
+
+// DenyToken отзывает access токен с идентификатором jti
+func (repo *TokenDenylistRepoRedis) DenyToken(jti string, ttl time.Duration) error {
+	return repo.RedisDB.Set(context.Background(), deniedTokenKey(jti), 1, ttl).Err()
+}
+
+// DenySession отзывает все access токены, выданные в сессии sessionID (поле sid)
+func (repo *TokenDenylistRepoRedis) DenySession(sessionID string, ttl time.Duration) error {
+	return repo.RedisDB.Set(context.Background(), deniedSessionKey(sessionID), 1, ttl).Err()
+}
+
+// SetUserNotBefore отзывает все access токены пользователя, выданные раньше секунды notBefore
+func (repo *TokenDenylistRepoRedis) SetUserNotBefore(userID uint, notBefore time.Time, ttl time.Duration) error {
+	return repo.RedisDB.Set(context.Background(), userNotBeforeKey(userID), notBefore.Unix(), ttl).Err()
+}
+
+// IsDenied проверяет все три признака отзыва одним запросом MGET
+func (repo *TokenDenylistRepoRedis) IsDenied(jti string, sessionID string, userID uint, issuedAt time.Time) (bool, error) {
+	values, err := repo.RedisDB.MGet(context.Background(), deniedTokenKey(jti), deniedSessionKey(sessionID), userNotBeforeKey(userID)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	if (jti != "" && values[0] != nil) || (sessionID != "" && values[1] != nil) {
+		return true, nil
+	}
+	if notBefore, ok := values[2].(string); ok {
+		unix, err := strconv.ParseInt(notBefore, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		// Точность iat - секунда, поэтому токены, выданные в ту же секунду, что и отзыв, здесь не отзываются:
+		// токены старых сессий этой секунды отзываются по sid, а токены новых входов должны приниматься
+		return issuedAt.Unix() < unix, nil
+	}
+	return false, nil
+}
+
+func deniedTokenKey(jti string) string {
+	return "denied_jti_" + jti
+}
+
+func deniedSessionKey(sessionID string) string {
+	return "denied_sid_" + sessionID
+}
+
+func userNotBeforeKey(userID uint) string {
+	return "not_before_" + strconv.FormatUint(uint64(userID), 10)
+}
@@ -2,12 +2,18 @@ package repositories
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
+	"messenger-auth/internal/domain"
 	"messenger-auth/internal/models"
 )
 
@@ -24,7 +30,7 @@ func NewUserRepoPostgres(db *gorm.DB, logger *slog.Logger) UserRepository {
 func (repo *UserRepoPostgres) Create(user *models.User) error {
 	if err := repo.DB.Create(user).Error; err != nil {
 		repo.Log.Error(fmt.Sprintf("Failed to create user in DB. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		return userError(err)
 	}
 	repo.Log.Debug("User was created in DB", slog.Any("user_data", user))
 	return nil
@@ -33,17 +39,63 @@ func (repo *UserRepoPostgres) Create(user *models.User) error {
 func (repo *UserRepoPostgres) Update(user *models.User) error {
 	if err := repo.DB.Save(user).Error; err != nil {
 		repo.Log.Error(fmt.Sprintf("Failed to update user in DB. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		return userError(err)
 	}
 	repo.Log.Debug("User was updated in DB", slog.Any("user_data", user))
 	return nil
 }
 
+// errResetTokenUsed откатывает транзакцию ResetPassword, если токен сброса уже использован
+var errResetTokenUsed = errors.New("password reset token is already used")
+
+// ResetPassword в одной транзакции помечает токен сброса использованным, меняет пароль и удаляет остальные
+// неиспользованные токены сброса пользователя. Возвращает false, если токен уже был использован
+func (repo *UserRepoPostgres) ResetPassword(id uint, passwordHash string, resetTokenID uint, usedAt time.Time) (bool, error) {
+	err := repo.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordResetToken{}).Where("id = ? AND user_id = ? AND used_at IS NULL", resetTokenID, id).Update("used_at", usedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errResetTokenUsed
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", id).Update("password", passwordHash).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND used_at IS NULL", id).Delete(&models.PasswordResetToken{}).Error
+	})
+	if errors.Is(err, errResetTokenUsed) {
+		return false, nil
+	}
+	if err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to reset password in DB. Error: %s", err.Error()), slog.Uint64("user_id", uint64(id)))
+		return false, err
+	}
+	repo.Log.Debug("Password was reset in DB", slog.Uint64("user_id", uint64(id)))
+	return true, nil
+}
+
+// AdvanceTOTPStep запоминает шаг использованного кода TOTP, если он новее сохраненного. Возвращает false,
+// если код этого или более позднего шага уже был принят (в том числе одновременным запросом)
+func (repo *UserRepoPostgres) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	result := repo.DB.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", id, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to update TOTP step in DB. Error: %s", result.Error.Error()), slog.Uint64("user_id", uint64(id)))
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
 func (repo *UserRepoPostgres) Delete(id uint) error {
-	if err := repo.DB.Delete(&models.User{}, id).Error; err != nil {
+	result := repo.DB.Delete(&models.User{}, id)
+	if err := result.Error; err != nil {
 		repo.Log.Error(fmt.Sprintf("Failed to delete user from DB. Error: %s", err.Error()), slog.Uint64("user_id", uint64(id)))
 		return err
 	}
+	if result.RowsAffected == 0 {
+		repo.Log.Debug("User to delete was not found in DB", slog.Uint64("user_id", uint64(id)))
+		return userError(gorm.ErrRecordNotFound)
+	}
 	repo.Log.Debug("User was deleted from DB", slog.Uint64("user_id", uint64(id)))
 	return nil
 }
@@ -51,26 +103,229 @@ func (repo *UserRepoPostgres) Delete(id uint) error {
 func (repo *UserRepoPostgres) FindByID(id uint) (*models.User, error) {
 	var user models.User
 	if err := repo.DB.First(&user, id).Error; err != nil {
-		repo.Log.Error(fmt.Sprintf("Failed to get user. Error: %s", err.Error()), slog.Uint64("user_id", uint64(id)))
+		if gorm.IsRecordNotFoundError(err) {
+			repo.Log.Debug("User was not found in DB", slog.Uint64("user_id", uint64(id)))
+		} else {
+			repo.Log.Error(fmt.Sprintf("Failed to get user. Error: %s", err.Error()), slog.Uint64("user_id", uint64(id)))
+		}
+		return nil, userError(err)
 	}
 	repo.Log.Debug("User was received from DB", slog.Uint64("user_id", uint64(id)))
 	return &user, nil
 }
 
-func (repo *UserRepoPostgres) FindAll() ([]models.User, error) {
+// FindByIDs получает пользователей одним запросом. Результат в порядке ids, отсутствующие пользователи пропускаются
+func (repo *UserRepoPostgres) FindByIDs(ids []uint) ([]models.User, error) {
+	if len(ids) == 0 {
+		return []models.User{}, nil
+	}
+	var found []models.User
+	if err := repo.DB.Where("id IN (?)", ids).Find(&found).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to get users. Error: %s", err.Error()), slog.Int("count", len(ids)))
+		return nil, err
+	}
+	repo.Log.Debug("Users were received from DB", slog.Int("requested", len(ids)), slog.Int("found", len(found)))
+
+	byID := make(map[uint]models.User, len(found))
+	for _, user := range found {
+		byID[user.ID] = user
+	}
+	return orderUsers(ids, byID), nil
+}
+
+func (repo *UserRepoPostgres) FindByLogin(login string) (*models.User, error) {
+	var user models.User
+	if err := repo.DB.Where("login = ?", login).First(&user).Error; err != nil {
+		repo.Log.Debug(fmt.Sprintf("Failed to get user by login. Error: %s", err.Error()), slog.String("login", login))
+		return nil, userError(err)
+	}
+	repo.Log.Debug("User was received from DB", slog.String("login", login))
+	return &user, nil
+}
+
+func (repo *UserRepoPostgres) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	// Почта сравнивается без учета регистра, как в уникальном индексе idx_users_email_lower_unique
+	if err := repo.DB.Where("LOWER(email) = LOWER(?)", email).First(&user).Error; err != nil {
+		repo.Log.Debug(fmt.Sprintf("Failed to get user by email. Error: %s", err.Error()), slog.String("email", email))
+		return nil, userError(err)
+	}
+	repo.Log.Debug("User was received from DB", slog.String("email", email))
+	return &user, nil
+}
+
+// FindPage возвращает страницу списка пользователей. Страница берется по ключу (колонка сортировки, id),
+// поэтому ее стоимость не зависит от номера страницы. Лишняя запись запрашивается, чтобы узнать, есть ли следующая страница.
+// Limit == 0 - все записи одной страницей
+func (repo *UserRepoPostgres) FindPage(query UserPageQuery) (*UserPage, error) {
+	column, ok := UserSortColumns[query.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", query.SortBy)
+	}
+	db := repo.filterUsers(query.Filter)
+
+	page := &UserPage{}
+	if query.WithTotal {
+		var total int64
+		if err := db.Count(&total).Error; err != nil {
+			repo.Log.Error(fmt.Sprintf("Failed to count users. Error: %s", err.Error()))
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	direction, operator := "ASC", ">"
+	if query.Desc {
+		direction, operator = "DESC", "<"
+	}
+	if query.AfterID != 0 {
+		if column == "id" {
+			db = db.Where("id "+operator+" ?", query.AfterID)
+		} else {
+			db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, operator), query.AfterValue, query.AfterID)
+		}
+	}
+	db = db.Order(column + " " + direction)
+	if column != "id" {
+		db = db.Order("id " + direction)
+	}
+
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit + 1)
+	}
 	var users []models.User
-	if err := repo.DB.Find(&users).Error; err != nil {
+	if err := db.Find(&users).Error; err != nil {
 		repo.Log.Error(fmt.Sprintf("Failed to get users. Error: %s", err.Error()))
+		return nil, err
+	}
+	if query.Limit > 0 && len(users) > query.Limit {
+		users = users[:query.Limit]
+		page.HasMore = true
 	}
-	repo.Log.Debug("Users was received from DB")
+	page.Users = users
+	repo.Log.Debug("Users page was received from DB", slog.Int("count", len(users)), slog.Bool("has_more", page.HasMore))
+	return page, nil
+}
+
+// Search ищет пользователей для поиска контактов: по префиксу и по триграммному сходству (pg_trgm) с логином
+// и именем, без учета регистра. Точное совпадение логина выше префикса, префикс выше сходства.
+// Пользователи со скрытым профилем (HideFromSearch) и excludeID в результат не попадают
+func (repo *UserRepoPostgres) Search(text string, excludeID uint, limit int) ([]models.User, error) {
+	text = strings.ToLower(text)
+	prefix := escapeLike(text) + "%"
+	rank := gorm.Expr("CASE WHEN LOWER(login) = ? THEN 3 WHEN LOWER(login) LIKE ? THEN 2 WHEN LOWER(name) LIKE ? THEN 1 ELSE 0 END"+
+		" + GREATEST(similarity(LOWER(login), ?), similarity(LOWER(name), ?)) DESC", text, prefix, prefix, text, text)
+
+	var users []models.User
+	err := repo.DB.
+		Where("hide_from_search = ? AND id <> ?", false, excludeID).
+		Where("LOWER(login) LIKE ? OR LOWER(name) LIKE ? OR LOWER(login) % ? OR LOWER(name) % ?", prefix, prefix, text, text).
+		Order(rank).Order("id").
+		Limit(limit).
+		Find(&users).Error
+	if err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to search users. Error: %s", err.Error()))
+		return nil, err
+	}
+	repo.Log.Debug("Users were found in DB", slog.Int("count", len(users)))
+	return users, nil
+}
+
+// SearchByEmail ищет пользователя для поиска контактов по точному адресу почты без учета регистра.
+// Пользователи, запретившие поиск по почте (HideFromEmailSearch), и excludeID в результат не попадают
+func (repo *UserRepoPostgres) SearchByEmail(email string, excludeID uint) ([]models.User, error) {
+	var users []models.User
+	err := repo.DB.
+		Where("LOWER(email) = ? AND hide_from_email_search = ? AND id <> ?", strings.ToLower(email), false, excludeID).
+		Limit(1).
+		Find(&users).Error
+	if err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to search users by email. Error: %s", err.Error()))
+		return nil, err
+	}
+	repo.Log.Debug("Users were found in DB by email", slog.Int("count", len(users)))
 	return users, nil
 }
 
+// filterUsers строит запрос к users с условиями фильтра
+func (repo *UserRepoPostgres) filterUsers(filter UserFilter) *gorm.DB {
+	db := repo.DB.Model(&models.User{})
+	switch filter.Deleted {
+	case UserDeletedInclude:
+		db = db.Unscoped()
+	case UserDeletedOnly:
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.EmailDomain != "" {
+		db = db.Where("LOWER(email) LIKE ?", "%@"+escapeLike(strings.ToLower(filter.EmailDomain)))
+	}
+	if filter.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.RoleID != 0 {
+		db = db.Where("service_role_id = ?", filter.RoleID)
+	}
+	return db
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE, чтобы значение сравнивалось буквально
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// userError переводит ошибки БД в ошибки предметной области: отсутствие записи - в domain.ErrNotFound,
+// нарушение уникальности логина или почты - в domain.ErrConflict. Исходная ошибка остается доступной через errors.Is
+func userError(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.NotFound(domain.CodeUserNotFound, "user not found", err)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return domain.Conflict(domain.CodeUserAlreadyExists, "user with this login or email already exists", err)
+	}
+	return err
+}
+
+// orderUsers раскладывает найденных пользователей в порядке запрошенных ids
+func orderUsers(ids []uint, byID map[uint]models.User) []models.User {
+	users := make([]models.User, 0, len(byID))
+	for _, id := range ids {
+		if user, ok := byID[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
 // GetDB дает доступ к полю DB
 func (repo *UserRepoPostgres) GetDB() *gorm.DB {
 	return repo.DB
 }
 
+// userCacheEntry - представление пользователя в Redis. В JSON API хеш пароля и секрет TOTP скрыты,
+// но в кеше они нужны, иначе запись, прочитанная из кеша и сохраненная в БД, потеряет их
+type userCacheEntry struct {
+	models.User
+	Password     *string `json:"password"`
+	TOTPSecret   *string `json:"totpSecret"`
+	TOTPLastStep int64   `json:"totpLastStep"`
+}
+
+func newUserCacheEntry(user *models.User) userCacheEntry {
+	return userCacheEntry{User: *user, Password: user.Password, TOTPSecret: user.TOTPSecret, TOTPLastStep: user.TOTPLastStep}
+}
+
+func (entry *userCacheEntry) toModel() *models.User {
+	user := entry.User
+	user.Password = entry.Password
+	user.TOTPSecret = entry.TOTPSecret
+	user.TOTPLastStep = entry.TOTPLastStep
+	return &user
+}
+
 // Структура для работы с Redis (надстройка для Postgres)
 type UserRepoRedis struct {
 	DBRepo  *UserRepoPostgres
@@ -90,7 +345,7 @@ func (repo *UserRepoRedis) Create(user *models.User) error {
 	}
 
 	// Create record in Redis
-	if err := setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(user.ID), 10), user); err != nil {
+	if err := setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(user.ID), 10), newUserCacheEntry(user)); err != nil {
 		repo.Log.Warn("Couldn't create record in Redis", slog.String("error", err.Error()))
 	}
 	return nil
@@ -104,12 +359,38 @@ func (repo *UserRepoRedis) Update(user *models.User) error {
 	}
 
 	// Update record in Redis
-	if err := setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(user.ID), 10), user); err != nil {
+	if err := setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(user.ID), 10), newUserCacheEntry(user)); err != nil {
 		repo.Log.Warn("Couldn't update record in Redis", slog.String("error", err.Error()))
 	}
 	return nil
 }
 
+func (repo *UserRepoRedis) ResetPassword(id uint, passwordHash string, resetTokenID uint, usedAt time.Time) (bool, error) {
+	reset, err := repo.DBRepo.ResetPassword(id, passwordHash, resetTokenID, usedAt)
+	if err != nil || !reset {
+		return reset, err
+	}
+
+	// Удаляем запись из Redis, чтобы по старому хешу пароля больше нельзя было войти
+	if err := repo.RedisDB.Del(context.Background(), "user_"+strconv.FormatUint(uint64(id), 10)).Err(); err != nil {
+		repo.Log.Warn("Couldn't delete record in Redis", slog.String("error", err.Error()))
+	}
+	return true, nil
+}
+
+func (repo *UserRepoRedis) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	advanced, err := repo.DBRepo.AdvanceTOTPStep(id, step)
+	if err != nil || !advanced {
+		return advanced, err
+	}
+
+	// Удаляем запись из Redis, чтобы в кеше не остался старый шаг
+	if err := repo.RedisDB.Del(context.Background(), "user_"+strconv.FormatUint(uint64(id), 10)).Err(); err != nil {
+		repo.Log.Warn("Couldn't delete record in Redis", slog.String("error", err.Error()))
+	}
+	return true, nil
+}
+
 func (repo *UserRepoRedis) Delete(id uint) error {
 	// Delete record from DB
 	err := repo.DBRepo.Delete(id)
@@ -126,26 +407,97 @@ func (repo *UserRepoRedis) Delete(id uint) error {
 }
 
 func (repo *UserRepoRedis) FindByID(id uint) (*models.User, error) {
-	var user models.User
+	var entry userCacheEntry
 
 	// Try get record from Redis, otherwise get from DB and create record in Redis
-	err := getWithUnmarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(id), 10), &user)
+	err := getWithUnmarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(id), 10), &entry)
 	if err != nil {
-		user, err := repo.DBRepo.FindByID(id)
+		// Отсутствующих пользователей не кешируем
+		dbUser, err := repo.DBRepo.FindByID(id)
 		if err != nil {
-			return user, err
+			return nil, err
 		}
 
-		err = setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(user.ID), 10), user)
+		err = setWithMarshal(repo.RedisDB, "user_"+strconv.FormatUint(uint64(dbUser.ID), 10), newUserCacheEntry(dbUser))
 		if err != nil {
 			repo.Log.Warn("Couldn't create record in Redis", slog.String("error", err.Error()))
 		}
+		return dbUser, nil
+	}
+	return entry.toModel(), nil
+}
+
+// FindByIDs берет пользователей из Redis одним MGET, недостающих получает из БД одним запросом и дописывает в кеш
+func (repo *UserRepoRedis) FindByIDs(ids []uint) ([]models.User, error) {
+	if len(ids) == 0 {
+		return []models.User{}, nil
+	}
+	ctx := context.Background()
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = "user_" + strconv.FormatUint(uint64(id), 10)
+	}
+
+	byID := make(map[uint]models.User, len(ids))
+	var missing []uint
+	values, err := repo.RedisDB.MGet(ctx, keys...).Result()
+	if err != nil {
+		repo.Log.Warn("Couldn't get records from Redis", slog.String("error", err.Error()))
+		values = make([]interface{}, len(ids))
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			missing = append(missing, ids[i])
+			continue
+		}
+		var entry userCacheEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+		byID[ids[i]] = *entry.toModel()
 	}
-	return &user, err
+
+	if len(missing) > 0 {
+		dbUsers, err := repo.DBRepo.FindByIDs(missing)
+		if err != nil {
+			return nil, err
+		}
+		pipe := repo.RedisDB.Pipeline()
+		for i := range dbUsers {
+			byID[dbUsers[i].ID] = dbUsers[i]
+			data, err := json.Marshal(newUserCacheEntry(&dbUsers[i]))
+			if err != nil {
+				continue
+			}
+			pipe.Set(ctx, "user_"+strconv.FormatUint(uint64(dbUsers[i].ID), 10), data, 0)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			repo.Log.Warn("Couldn't create records in Redis", slog.String("error", err.Error()))
+		}
+	}
+	return orderUsers(ids, byID), nil
+}
+
+func (repo *UserRepoRedis) FindByLogin(login string) (*models.User, error) {
+	return repo.DBRepo.FindByLogin(login)
+}
+
+func (repo *UserRepoRedis) FindByEmail(email string) (*models.User, error) {
+	return repo.DBRepo.FindByEmail(email)
+}
+
+func (repo *UserRepoRedis) FindPage(query UserPageQuery) (*UserPage, error) {
+	return repo.DBRepo.FindPage(query)
+}
+
+func (repo *UserRepoRedis) Search(text string, excludeID uint, limit int) ([]models.User, error) {
+	return repo.DBRepo.Search(text, excludeID, limit)
 }
 
-func (repo *UserRepoRedis) FindAll() ([]models.User, error) {
-	return repo.DBRepo.FindAll()
+func (repo *UserRepoRedis) SearchByEmail(email string, excludeID uint) ([]models.User, error) {
+	return repo.DBRepo.SearchByEmail(email, excludeID)
 }
 
 // GetDB дает доступ к полю DB
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/models"
+)
+
+// Структура для работы с ключами доступа WebAuthn в Postgres
+type WebAuthnCredentialRepoPostgres struct {
+	DB  *gorm.DB
+	Log *slog.Logger
+}
+
+func NewWebAuthnCredentialRepoPostgres(db *gorm.DB, logger *slog.Logger) WebAuthnCredentialRepository {
+	return &WebAuthnCredentialRepoPostgres{DB: db, Log: logger}
+}
+
+func (repo *WebAuthnCredentialRepoPostgres) Create(credential *models.WebAuthnCredential) error {
+	if err := repo.DB.Create(credential).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to create webauthn credential. Error: %s", err.Error()), slog.Uint64("user_id", uint64(credential.UserID)))
+		return err
+	}
+	repo.Log.Debug("Webauthn credential was created", slog.Uint64("user_id", uint64(credential.UserID)), slog.Uint64("credential_id", uint64(credential.ID)))
+	return nil
+}
+
+// FindByCredentialID возвращает gorm.ErrRecordNotFound, если ключ не зарегистрирован
+func (repo *WebAuthnCredentialRepoPostgres) FindByCredentialID(credentialID string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := repo.DB.Where("credential_id = ?", credentialID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (repo *WebAuthnCredentialRepoPostgres) FindByUser(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	if err := repo.DB.Where("user_id = ?", userID).Order("id").Find(&credentials).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to find webauthn credentials. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpdateSignCount сохраняет новое значение счетчика подписей и время использования ключа, только если счетчик
+// вырос (условный UPDATE), а ключ не помечен склонированным. У аутентификаторов без счетчика (signCount == 0)
+// сохраненный счетчик должен оставаться нулевым. Возвращает false, если условие не выполнено
+func (repo *WebAuthnCredentialRepoPostgres) UpdateSignCount(id uint, signCount int64, usedAt time.Time) (bool, error) {
+	query := repo.DB.Model(&models.WebAuthnCredential{}).Where("id = ? AND NOT clone_warning", id)
+	if signCount == 0 {
+		query = query.Where("sign_count = 0")
+	} else {
+		query = query.Where("sign_count < ?", signCount)
+	}
+	result := query.Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": usedAt,
+	})
+	if result.Error != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to update webauthn credential. Error: %s", result.Error.Error()), slog.Uint64("credential_id", uint64(id)))
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkCloned помечает ключ склонированным, после чего он больше не принимается
+func (repo *WebAuthnCredentialRepoPostgres) MarkCloned(id uint) error {
+	if err := repo.DB.Model(&models.WebAuthnCredential{}).Where("id = ?", id).Update("clone_warning", true).Error; err != nil {
+		repo.Log.Error(fmt.Sprintf("Failed to mark webauthn credential as cloned. Error: %s", err.Error()), slog.Uint64("credential_id", uint64(id)))
+		return err
+	}
+	return nil
+}
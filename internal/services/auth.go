@@ -0,0 +1,567 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+type AuthServiceGORM struct {
+	Repo            repositories.UserRepository
+	RefreshRepo     repositories.RefreshTokenRepository
+	Sessions        SessionService
+	Throttle        LoginThrottleService
+	Tokens          TokenService
+	Roles           RoleService
+	Hasher          PasswordHasher
+	Policy          PasswordPolicyService
+	Verifier        EmailVerificationService
+	TwoFactor       TwoFactorService
+	KeyValues       repositories.KeyValueRepository
+	Counters        repositories.CounterRepository
+	Denylist        repositories.TokenDenylistRepository
+	RefreshTokenTTL time.Duration
+	// Режим EMAIL_VERIFICATION_MODE: off, block или restrict
+	VerificationMode string
+	// Время жизни и число попыток ввода кода для промежуточного токена входа с двухфакторной аутентификацией
+	MFATokenTTL         time.Duration
+	MFATokenMaxAttempts int64
+	Log                 *slog.Logger
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+}
+
+func NewAuthServiceGORM(repo repositories.UserRepository, refreshRepo repositories.RefreshTokenRepository, sessions SessionService, throttle LoginThrottleService, tokens TokenService, roles RoleService, hasher PasswordHasher, policy PasswordPolicyService, verifier EmailVerificationService, twoFactor TwoFactorService, keyValues repositories.KeyValueRepository, counters repositories.CounterRepository, denylist repositories.TokenDenylistRepository, refreshTokenTTL time.Duration, verificationMode string, mfaTokenTTL time.Duration, mfaTokenMaxAttempts int64, logger *slog.Logger) AuthService {
+	return &AuthServiceGORM{
+		Repo:                repo,
+		RefreshRepo:         refreshRepo,
+		Sessions:            sessions,
+		Throttle:            throttle,
+		Tokens:              tokens,
+		Roles:               roles,
+		Hasher:              hasher,
+		Policy:              policy,
+		Verifier:            verifier,
+		TwoFactor:           twoFactor,
+		KeyValues:           keyValues,
+		Counters:            counters,
+		Denylist:            denylist,
+		RefreshTokenTTL:     refreshTokenTTL,
+		VerificationMode:    verificationMode,
+		MFATokenTTL:         mfaTokenTTL,
+		MFATokenMaxAttempts: mfaTokenMaxAttempts,
+		Log:                 logger,
+	}
+}
+
+// Register создает пользователя. Чтобы по регистрации нельзя было узнать, какие адреса и логины заняты,
+// результат для клиента всегда одинаков: письмо на указанный адрес. Новый пользователь получает ссылку
+// подтверждения, владелец занятого адреса - уведомление, а при занятом логине на адрес приходит просьба выбрать другой.
+// Синхронно выполняется только работа, одинаковая для всех случаев (проверка политики и хеширование пароля),
+// поиск занятых адреса и логина, создание пользователя и письмо выполняются в фоне
+func (authService *AuthServiceGORM) Register(registerDTO *dto.RegisterData) error {
+	if err := authService.Policy.Check(registerDTO.Password, registerDTO.Login, registerDTO.Email); err != nil {
+		return err
+	}
+	passwordHash, err := authService.Hasher.Hash(registerDTO.Password)
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to hash password. Error: %s", err.Error()), slog.String("login", registerDTO.Login))
+		return err
+	}
+	defaultRole, err := authService.Roles.DefaultRole()
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to get default role. Error: %s", err.Error()), slog.String("login", registerDTO.Login))
+		return err
+	}
+
+	go authService.registerAccount(registerDTO.Login, registerDTO.Email, passwordHash, defaultRole.ID)
+	return nil
+}
+
+// registerAccount создает пользователя или, если адрес или логин заняты, отправляет соответствующее письмо.
+// Вызывается в фоне, как отправка письма в ForgotPassword: ни время ответа, ни ошибка базы или почтового сервера
+// не должны отличать свободные адрес и логин от занятых. Поэтому ошибки только журналируются
+func (authService *AuthServiceGORM) registerAccount(login string, email string, passwordHash string, roleID uint) {
+	if _, err := authService.Repo.FindByEmail(email); err == nil {
+		authService.Log.Info("Registration with existing email", slog.String("login", login))
+		authService.logRegistrationEmailError(login, authService.Verifier.SendAccountExists(email))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		authService.Log.Error(fmt.Sprintf("Failed to register user. Error: %s", err.Error()), slog.String("login", login))
+		return
+	}
+	if _, err := authService.Repo.FindByLogin(login); err == nil {
+		authService.Log.Info("Registration with existing login", slog.String("login", login))
+		authService.logRegistrationEmailError(login, authService.Verifier.SendLoginTaken(email, login))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		authService.Log.Error(fmt.Sprintf("Failed to register user. Error: %s", err.Error()), slog.String("login", login))
+		return
+	}
+
+	// Никнейм по умолчанию совпадает с логином, пользователь может сменить его позже
+	user := models.User{
+		Login:         &login,
+		Name:          &login,
+		Email:         &email,
+		Password:      &passwordHash,
+		ServiceRoleID: roleID,
+	}
+	if err := authService.Repo.Create(&user); err != nil {
+		// Логин или адрес занят одновременной регистрацией: письмо получит победившая регистрация
+		if errors.Is(err, domain.ErrConflict) {
+			authService.Log.Info("Registration lost to a concurrent one", slog.String("login", login))
+			return
+		}
+		authService.Log.Error(fmt.Sprintf("Failed to register user. Error: %s", err.Error()), slog.String("login", login))
+		return
+	}
+	authService.Log.Debug("User was registered", slog.Uint64("user_id", uint64(user.ID)))
+	// Пользователь уже создан: если письмо не дойдет, его можно запросить повторно
+	authService.logRegistrationEmailError(login, authService.Verifier.SendVerification(&user))
+}
+
+// logRegistrationEmailError журналирует ошибку отправки письма регистрации, если она была
+func (authService *AuthServiceGORM) logRegistrationEmailError(login string, err error) {
+	if err != nil {
+		authService.Log.Warn("Couldn't send registration email", slog.String("login", login), slog.String("error", err.Error()))
+	}
+}
+
+// Login проверяет логин и пароль. Неудачные попытки учитываются LoginThrottleService, при блокировке
+// возвращается *TooManyRequestsError, причем до проверки пароля
+func (authService *AuthServiceGORM) Login(loginDTO *dto.LoginData, client *dto.ClientInfo) (*dto.LoginResult, error) {
+	if err := authService.Throttle.Check(loginDTO.Login, client.IP); err != nil {
+		return nil, err
+	}
+	user, err := authService.Repo.FindByLogin(loginDTO.Login)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Проверка пароля по фиктивному хешу уравнивает время ответа для существующих и несуществующих логинов
+			authService.verifyDummyPassword(loginDTO.Password)
+			return nil, authService.loginFailed(loginDTO.Login, client.IP)
+		}
+		authService.Log.Error(fmt.Sprintf("Failed to login user. Error: %s", err.Error()), slog.String("login", loginDTO.Login))
+		return nil, err
+	}
+	ok, err := verifyPassword(authService.Hasher, user.Password, loginDTO.Password)
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to check password. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return nil, err
+	}
+	if !ok {
+		return nil, authService.loginFailed(loginDTO.Login, client.IP)
+	}
+	if err := authService.Throttle.RegisterSuccess(loginDTO.Login, client.IP); err != nil {
+		authService.Log.Warn("Couldn't reset login failures", slog.Uint64("user_id", uint64(user.ID)), slog.String("error", err.Error()))
+	}
+	authService.rehashPassword(user, loginDTO.Password)
+	return authService.completeLogin(user, client)
+}
+
+// rehashPassword пересчитывает хеш, сделанный устаревшим алгоритмом или с устаревшими параметрами.
+// Открытый пароль есть только в момент входа, поэтому обновление происходит здесь. Ошибка не мешает входу
+func (authService *AuthServiceGORM) rehashPassword(user *models.User, password string) {
+	if !authService.Hasher.NeedsRehash(*user.Password) {
+		return
+	}
+	passwordHash, err := authService.Hasher.Hash(password)
+	if err == nil {
+		user.Password = &passwordHash
+		err = authService.Repo.Update(user)
+	}
+	if err != nil {
+		authService.Log.Warn("Couldn't upgrade password hash", slog.Uint64("user_id", uint64(user.ID)), slog.String("error", err.Error()))
+		return
+	}
+	authService.Log.Info("Password hash was upgraded", slog.Uint64("user_id", uint64(user.ID)))
+}
+
+// verifyDummyPassword проверяет пароль по хешу, который не подходит ни к одному паролю.
+// Хеш создается текущим алгоритмом и параметрами, поэтому проверка занимает столько же, сколько настоящая
+func (authService *AuthServiceGORM) verifyDummyPassword(password string) {
+	authService.dummyHashOnce.Do(func() {
+		secret, err := randomToken(32)
+		if err == nil {
+			authService.dummyHash, err = authService.Hasher.Hash(secret)
+		}
+		if err != nil {
+			authService.Log.Warn("Couldn't create dummy password hash", slog.String("error", err.Error()))
+		}
+	})
+	if authService.dummyHash != "" {
+		_, _ = authService.Hasher.Verify(authService.dummyHash, password)
+	}
+}
+
+// loginFailed учитывает неудачную попытку входа и возвращает ErrInvalidCredentials
+func (authService *AuthServiceGORM) loginFailed(login string, ip string) error {
+	if err := authService.Throttle.RegisterFailure(login, ip); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// LoginExternal выдает токены пользователю, вошедшему через внешнего провайдера OpenID Connect.
+// Провайдер заменяет только пароль: при включенной двухфакторной аутентификации код TOTP все равно запрашивается
+func (authService *AuthServiceGORM) LoginExternal(user *models.User, client *dto.ClientInfo) (*dto.LoginResult, error) {
+	return authService.completeLogin(user, client)
+}
+
+// completeLogin завершает вход после проверки первого фактора: выдает токены или промежуточный токен для ввода кода TOTP
+func (authService *AuthServiceGORM) completeLogin(user *models.User, client *dto.ClientInfo) (*dto.LoginResult, error) {
+	if authService.VerificationMode == EmailVerificationBlock && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+
+	if user.TOTPEnabledAt != nil {
+		// Пока учетная запись заблокирована за неверные коды, новый промежуточный токен не выдается:
+		// иначе ограничение попыток на один токен обходилось бы повторным входом
+		if err := authService.Throttle.Check(twoFactorThrottleLogin(user), client.IP); err != nil {
+			return nil, err
+		}
+		// Первый фактор пройден, но токены выдаются только после проверки второго
+		mfaToken, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		if err := authService.KeyValues.Set(mfaTokenKey(mfaToken), user.ID, authService.MFATokenTTL); err != nil {
+			authService.Log.Error(fmt.Sprintf("Failed to store mfa token. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+			return nil, err
+		}
+		authService.Log.Debug("Second factor is required", slog.Uint64("user_id", uint64(user.ID)))
+		return &dto.LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	tokens, err := authService.startSession(user, tokenSession{AuthTime: time.Now()}, client)
+	if err != nil {
+		return nil, err
+	}
+	authService.Log.Debug("User was logged in", slog.Uint64("user_id", uint64(user.ID)))
+	return &dto.LoginResult{TokenData: tokens}, nil
+}
+
+// LoginTwoFactor завершает вход с двухфакторной аутентификацией: обменивает промежуточный токен и код TOTP
+// (или код восстановления) на пару токенов. Число попыток ввода кода для одного токена ограничено, кроме того
+// неверные коды учитываются LoginThrottleService по учетной записи, как неудачные попытки входа по паролю
+func (authService *AuthServiceGORM) LoginTwoFactor(mfaToken string, code string, client *dto.ClientInfo) (*dto.TokenData, error) {
+	key := mfaTokenKey(mfaToken)
+	var userID uint
+	if err := authService.KeyValues.Get(key, &userID); err != nil {
+		if errors.Is(err, repositories.ErrKeyNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		authService.Log.Error(fmt.Sprintf("Failed to read mfa token. Error: %s", err.Error()))
+		return nil, err
+	}
+
+	attempts, _, err := authService.Counters.Increment(key+"_attempts", authService.MFATokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > authService.MFATokenMaxAttempts {
+		_ = authService.KeyValues.Delete(key)
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := authService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	if user.TOTPEnabledAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+	throttleLogin := twoFactorThrottleLogin(user)
+	if err := authService.Throttle.Check(throttleLogin, client.IP); err != nil {
+		return nil, err
+	}
+	ok, err := authService.TwoFactor.VerifyCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := authService.Throttle.RegisterFailure(throttleLogin, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	// Токен одноразовый: если его успели использовать параллельным запросом, вход не выполняется
+	if err := authService.KeyValues.Take(key, &userID); err != nil {
+		if errors.Is(err, repositories.ErrKeyNotFound) {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+	tokens, err := authService.startSession(user, tokenSession{AuthTime: time.Now()}, client)
+	if err != nil {
+		return nil, err
+	}
+	authService.Log.Debug("User was logged in with second factor", slog.Uint64("user_id", uint64(user.ID)))
+	return tokens, nil
+}
+
+// twoFactorThrottleLogin - ключ учетной записи для LoginThrottleService при проверке второго фактора.
+// Это логин пользователя, поэтому неверные коды и неверные пароли блокируют одну и ту же учетную запись
+// и блокировка снимается тем же DELETE /auth/api/v1/lockouts?login=...
+func twoFactorThrottleLogin(user *models.User) string {
+	if user.Login != nil {
+		return *user.Login
+	}
+	return fmt.Sprintf("user_%d", user.ID)
+}
+
+// LoginPasswordless выдает токены пользователю, уже подтвердившему личность без пароля (например, ключом доступа).
+// Ключ доступа сам является вторым фактором, поэтому TOTP не запрашивается
+func (authService *AuthServiceGORM) LoginPasswordless(user *models.User, client *dto.ClientInfo) (*dto.TokenData, error) {
+	if authService.VerificationMode == EmailVerificationBlock && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+	tokens, err := authService.startSession(user, tokenSession{AuthTime: time.Now()}, client)
+	if err != nil {
+		return nil, err
+	}
+	authService.Log.Debug("User was logged in without password", slog.Uint64("user_id", uint64(user.ID)))
+	return tokens, nil
+}
+
+// LoginForClient выдает токены клиенту OAuth clientID с выданными ему scope. Семейство refresh токенов привязывается
+// к клиенту и scope. authTime - время, когда пользователь вошел в сервис, оно переносится в токены (auth_time)
+// и сохраняется при их обновлении
+func (authService *AuthServiceGORM) LoginForClient(user *models.User, clientID string, scope string, authTime time.Time, client *dto.ClientInfo) (*dto.TokenData, error) {
+	if authService.VerificationMode == EmailVerificationBlock && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+	tokens, err := authService.startSession(user, tokenSession{ClientID: clientID, Scope: scope, AuthTime: authTime}, client)
+	if err != nil {
+		return nil, err
+	}
+	authService.Log.Debug("Tokens were issued to oauth client", slog.Uint64("user_id", uint64(user.ID)))
+	return tokens, nil
+}
+
+// tokenSession - общие данные всех токенов одного входа
+type tokenSession struct {
+	FamilyID string
+	ClientID string // Клиент OAuth (пусто у токенов первой стороны)
+	Scope    string
+	AuthTime time.Time
+}
+
+// startSession выдает токены для нового входа. Каждый вход начинает новое семейство refresh токенов и новую сессию
+func (authService *AuthServiceGORM) startSession(user *models.User, session tokenSession, client *dto.ClientInfo) (*dto.TokenData, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	session.FamilyID = familyID
+	tokens, err := authService.issueTokens(user, session)
+	if err != nil {
+		return nil, err
+	}
+	if err := authService.Sessions.StartSession(user.ID, familyID, client, time.Now().Add(authService.RefreshTokenTTL)); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func mfaTokenKey(mfaToken string) string {
+	return "mfa_" + hashToken(mfaToken)
+}
+
+// Refresh обменивает refresh токен на новую пару токенов. Старый токен становится недействительным.
+// Повторное предъявление уже обмененного токена означает его утечку, поэтому отзывается все семейство.
+// clientID - клиент OAuth, предъявивший токен (пусто для первой стороны), он должен совпадать с клиентом семейства
+func (authService *AuthServiceGORM) Refresh(refreshToken string, clientID string, client *dto.ClientInfo) (*dto.TokenData, error) {
+	stored, err := authService.RefreshRepo.FindByHash(hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		authService.Log.Error(fmt.Sprintf("Failed to refresh tokens. Error: %s", err.Error()))
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	// Токен выдан другому клиенту. Семейства со scope, выданные до привязки к клиенту, первой стороне не обмениваются
+	if stored.ClientID != clientID || (clientID == "" && stored.Scope != "") {
+		authService.Log.Info("Refresh token was presented by another client", slog.Uint64("user_id", uint64(stored.UserID)), slog.String("client_id", clientID))
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.UsedAt != nil {
+		return nil, authService.revokeReusedFamily(stored, now)
+	}
+	marked, err := authService.RefreshRepo.MarkUsed(stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		// Токен успели обменять параллельным запросом
+		return nil, authService.revokeReusedFamily(stored, now)
+	}
+
+	user, err := authService.Repo.FindByID(stored.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if authService.VerificationMode == EmailVerificationBlock && user.EmailVerifiedAt == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// У токенов, выданных до появления auth_time, время входа неизвестно, берем время выдачи токена
+	authTime := stored.CreatedAt
+	if stored.AuthTime != nil {
+		authTime = *stored.AuthTime
+	}
+	tokens, err := authService.issueTokens(user, tokenSession{FamilyID: stored.FamilyID, ClientID: stored.ClientID, Scope: stored.Scope, AuthTime: authTime})
+	if err != nil {
+		return nil, err
+	}
+	if err := authService.Sessions.TouchSession(user.ID, stored.FamilyID, client, time.Now().Add(authService.RefreshTokenTTL)); err != nil {
+		return nil, err
+	}
+	authService.Log.Debug("Tokens were refreshed", slog.Uint64("user_id", uint64(user.ID)), slog.String("family_id", stored.FamilyID))
+	return tokens, nil
+}
+
+// VerifyAccessToken проверяет access токен первой стороны (API сервиса и других сервисов мессенджера).
+// Токены клиентов OAuth отклоняются с ErrTokenThirdParty
+func (authService *AuthServiceGORM) VerifyAccessToken(accessToken string) (*AccessClaims, error) {
+	claims, err := authService.VerifyClientAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ThirdParty() {
+		return nil, ErrTokenThirdParty
+	}
+	return claims, nil
+}
+
+// VerifyClientAccessToken проверяет подпись и срок действия access токена, а также что его владелец все еще существует.
+// Принимает и токены клиентов OAuth, поэтому подходит только эндпоинтам, которые сами проверяют scope
+func (authService *AuthServiceGORM) VerifyClientAccessToken(accessToken string) (*AccessClaims, error) {
+	claims, err := authService.Tokens.ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := claims.UserID()
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	// Проверка отзыва идет первой: это один запрос в Redis, и отозванные токены не доходят до базы
+	denied, err := authService.Denylist.IsDenied(claims.ID, claims.SessionID, userID, issuedAt)
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to check token denylist. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return nil, err
+	}
+	if denied {
+		return nil, ErrTokenRevoked
+	}
+	if _, err := authService.Repo.FindByID(userID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrTokenRevoked
+		}
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Logout завершает сессию access токена и отзывает сам токен до истечения его срока
+func (authService *AuthServiceGORM) Logout(claims *AccessClaims) error {
+	userID, _ := claims.UserID()
+	if claims.SessionID != "" {
+		if err := authService.Sessions.EndSession(claims.SessionID); err != nil {
+			authService.Log.Error(fmt.Sprintf("Failed to end session. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+			return err
+		}
+	}
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if ttl := time.Until(claims.ExpiresAt.Time); ttl > 0 {
+			if err := authService.Denylist.DenyToken(claims.ID, ttl); err != nil {
+				authService.Log.Error(fmt.Sprintf("Failed to revoke access token. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+				return err
+			}
+		}
+	}
+	authService.Log.Info("User logged out", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+func (authService *AuthServiceGORM) revokeReusedFamily(stored *models.RefreshToken, now time.Time) error {
+	authService.Log.Warn("Refresh token reuse detected, revoking token family", slog.Uint64("user_id", uint64(stored.UserID)), slog.String("family_id", stored.FamilyID))
+	if err := authService.Sessions.EndSession(stored.FamilyID); err != nil {
+		return err
+	}
+	return ErrInvalidRefreshToken
+}
+
+// issueTokens выдает access токен и новый refresh токен в семействе session.FamilyID
+func (authService *AuthServiceGORM) issueTokens(user *models.User, session tokenSession) (*dto.TokenData, error) {
+	role, err := authService.tokenRole(user)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, expiresAt, err := authService.Tokens.IssueAccessToken(user.ID, role, session.ClientID, session.Scope, session.AuthTime, session.FamilyID)
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to issue access token. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := authService.RefreshRepo.Create(&models.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  session.FamilyID,
+		UserID:    user.ID,
+		ClientID:  session.ClientID,
+		Scope:     session.Scope,
+		AuthTime:  &session.AuthTime,
+		ExpiresAt: now.Add(authService.RefreshTokenTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dto.TokenData{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expiresAt).Seconds()),
+		Scope:        session.Scope,
+	}, nil
+}
+
+// tokenRole возвращает роль для access токена: в режиме restrict до подтверждения почты это роль unverified
+func (authService *AuthServiceGORM) tokenRole(user *models.User) (uint, error) {
+	if authService.VerificationMode != EmailVerificationRestrict || user.EmailVerifiedAt != nil {
+		return user.ServiceRoleID, nil
+	}
+	role, err := authService.Roles.GetRoleByName(models.RoleNameUnverified)
+	if err != nil {
+		authService.Log.Error(fmt.Sprintf("Failed to get unverified role. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return 0, err
+	}
+	return role.ID, nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/testutil"
+)
+
+func TestLoginTwoFactorThrottlesPerUser(t *testing.T) {
+	login, password := "alice", "hashed:correct horse"
+	totpEnabledAt := time.Now()
+	user := &models.User{Login: &login, Password: &password, TOTPEnabledAt: &totpEnabledAt}
+	users := testutil.NewUserRepo(user)
+	throttle := &fakeThrottle{maxFailures: 3}
+	// Лимит попыток на один промежуточный токен выше порога блокировки: проверяется именно учет по пользователю
+	service := NewAuthServiceGORM(users, nil, nil, throttle, nil, &fakeRoleService{}, fakeHasher{}, nil, nil, &fakeTwoFactor{code: "123456"},
+		newFakeKeyValueRepo(), &fakeCounterRepo{}, nil, time.Hour, EmailVerificationOff, time.Minute, 10, discardLogger())
+	client := &dto.ClientInfo{IP: "192.0.2.1"}
+
+	mfaToken := func() string {
+		t.Helper()
+		result, err := service.Login(&dto.LoginData{Login: login, Password: "correct horse"}, client)
+		if err != nil || !result.MFARequired {
+			t.Fatalf("Login = %+v, %v, want an mfa token", result, err)
+		}
+		return result.MFAToken
+	}
+
+	pending := mfaToken()
+	// Каждый неверный код вводится с новым промежуточным токеном
+	for i := 0; i < throttle.maxFailures; i++ {
+		if _, err := service.LoginTwoFactor(mfaToken(), "000000", client); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+		}
+	}
+
+	var tooMany *TooManyRequestsError
+	if _, err := service.LoginTwoFactor(pending, "123456", client); !errors.As(err, &tooMany) {
+		t.Fatalf("LoginTwoFactor after lockout: err = %v, want TooManyRequestsError", err)
+	}
+	if _, err := service.Login(&dto.LoginData{Login: login, Password: "correct horse"}, client); !errors.As(err, &tooMany) {
+		t.Fatalf("Login after lockout: err = %v, want TooManyRequestsError", err)
+	}
+	// Вход через внешнего провайдера минует проверку пароля, но промежуточный токен тоже не выдается
+	if _, err := service.LoginExternal(user, client); !errors.As(err, &tooMany) {
+		t.Fatalf("LoginExternal after lockout: err = %v, want TooManyRequestsError", err)
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/mailer"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+// Режимы EMAIL_VERIFICATION_MODE
+const (
+	EmailVerificationOff      = "off"      // Неподтвержденная почта ни на что не влияет
+	EmailVerificationBlock    = "block"    // Вход запрещен до подтверждения почты
+	EmailVerificationRestrict = "restrict" // До подтверждения почты в токенах выдается роль unverified
+)
+
+// EmailVerificationServiceGORM подтверждает почту по подписанной ссылке. Ссылка не хранится в БД:
+// в ней id пользователя, адрес и срок действия, подписанные HMAC-SHA256. Смена адреса делает старые ссылки недействительными
+type EmailVerificationServiceGORM struct {
+	Repo         repositories.UserRepository
+	Counters     repositories.CounterRepository
+	Mailer       mailer.Mailer
+	Secret       []byte
+	VerifyURL    string
+	TTL          time.Duration
+	ResendLimit  int64
+	ResendWindow time.Duration
+	Log          *slog.Logger
+}
+
+func NewEmailVerificationServiceGORM(repo repositories.UserRepository, counters repositories.CounterRepository, mail mailer.Mailer, secret string, verifyURL string, ttl time.Duration, resendLimit int64, resendWindow time.Duration, logger *slog.Logger) EmailVerificationService {
+	return &EmailVerificationServiceGORM{
+		Repo:         repo,
+		Counters:     counters,
+		Mailer:       mail,
+		Secret:       []byte(secret),
+		VerifyURL:    verifyURL,
+		TTL:          ttl,
+		ResendLimit:  resendLimit,
+		ResendWindow: resendWindow,
+		Log:          logger,
+	}
+}
+
+// SendVerification отправляет на текущий адрес пользователя ссылку для подтверждения
+func (verificationService *EmailVerificationServiceGORM) SendVerification(user *models.User) error {
+	if user.Email == nil {
+		return nil
+	}
+	return verificationService.sendLink(user.ID, *user.Email)
+}
+
+// SendEmailChange отправляет ссылку подтверждения на новый адрес user.PendingEmail. Если адрес занят другой
+// учетной записью, вместо ссылки его владелец получает уведомление: запросивший смену не может это отличить
+func (verificationService *EmailVerificationServiceGORM) SendEmailChange(user *models.User) error {
+	if user.PendingEmail == nil {
+		return nil
+	}
+	owner, err := verificationService.Repo.FindByEmail(*user.PendingEmail)
+	if err == nil && owner.ID != user.ID {
+		return verificationService.SendAccountExists(*user.PendingEmail)
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		verificationService.Log.Error(fmt.Sprintf("Failed to send email change confirmation. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return err
+	}
+	return verificationService.sendLink(user.ID, *user.PendingEmail)
+}
+
+// SendAccountExists сообщает владельцу адреса, что адрес пытались использовать для новой учетной записи или при смене почты
+func (verificationService *EmailVerificationServiceGORM) SendAccountExists(email string) error {
+	if err := verificationService.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Your messenger account",
+		Body: "Someone tried to use this email address for a new messenger account.\n\n" +
+			"This address already belongs to an account. If it was you, sign in or reset your password.\n\n" +
+			"If it was not you, ignore this email: nothing has been changed.\n",
+	}); err != nil {
+		verificationService.Log.Error(fmt.Sprintf("Failed to send account exists email. Error: %s", err.Error()))
+		return err
+	}
+	verificationService.Log.Debug("Account exists email was sent")
+	return nil
+}
+
+// SendLoginTaken сообщает о регистрации с занятым логином на адрес, указанный при регистрации
+func (verificationService *EmailVerificationServiceGORM) SendLoginTaken(email string, login string) error {
+	if err := verificationService.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Complete your registration",
+		Body: fmt.Sprintf("Someone tried to create a messenger account with this email address and the login %q.\n\n"+
+			"This login is already taken, so the account was not created. If it was you, register again with another login.\n\n"+
+			"If it was not you, ignore this email.\n", login),
+	}); err != nil {
+		verificationService.Log.Error(fmt.Sprintf("Failed to send login taken email. Error: %s", err.Error()))
+		return err
+	}
+	verificationService.Log.Debug("Login taken email was sent")
+	return nil
+}
+
+func (verificationService *EmailVerificationServiceGORM) sendLink(userID uint, email string) error {
+	token := verificationService.sign(userID, email, time.Now().Add(verificationService.TTL))
+
+	link, err := url.Parse(verificationService.VerifyURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	if err := verificationService.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("To confirm the email address of your messenger account, open this link within %s:\n%s\n\n"+
+			"If you did not create an account, ignore this email.\n", verificationService.TTL, link.String()),
+	}); err != nil {
+		verificationService.Log.Error(fmt.Sprintf("Failed to send verification email. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return err
+	}
+	verificationService.Log.Debug("Verification email was sent", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+func (verificationService *EmailVerificationServiceGORM) VerifyEmail(token string) error {
+	userID, email, err := verificationService.parse(token)
+	if err != nil {
+		return err
+	}
+
+	user, err := verificationService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		return err
+	}
+	if user.PendingEmail != nil && *user.PendingEmail == email {
+		return verificationService.confirmEmailChange(user)
+	}
+	if user.Email == nil || *user.Email != email {
+		return ErrInvalidVerificationToken
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := verificationService.Repo.Update(user); err != nil {
+		verificationService.Log.Error(fmt.Sprintf("Failed to verify email. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return err
+	}
+	verificationService.Log.Info("Email was verified", slog.Uint64("user_id", uint64(user.ID)))
+	return nil
+}
+
+// confirmEmailChange заменяет адрес пользователя подтвержденным новым адресом. Адрес проверяется еще раз:
+// его могли занять после отправки ссылки
+func (verificationService *EmailVerificationServiceGORM) confirmEmailChange(user *models.User) error {
+	owner, err := verificationService.Repo.FindByEmail(*user.PendingEmail)
+	if err == nil && owner.ID != user.ID {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	now := time.Now()
+	user.Email = user.PendingEmail
+	user.PendingEmail = nil
+	user.EmailVerifiedAt = &now
+	if err := verificationService.Repo.Update(user); err != nil {
+		// Адрес успел занять другой пользователь между проверкой и сохранением (уникальный индекс по почте)
+		if errors.Is(err, domain.ErrConflict) {
+			verificationService.Log.Info("Email change lost to another account", slog.Uint64("user_id", uint64(user.ID)))
+			return ErrInvalidVerificationToken
+		}
+		verificationService.Log.Error(fmt.Sprintf("Failed to change email. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return err
+	}
+	verificationService.Log.Info("Email was changed", slog.Uint64("user_id", uint64(user.ID)))
+	return nil
+}
+
+// ResendVerification повторно отправляет ссылку не чаще ResendLimit раз за ResendWindow для одного адреса.
+// Для неизвестного или уже подтвержденного адреса ничего не делает и не возвращает ошибку
+func (verificationService *EmailVerificationServiceGORM) ResendVerification(email string) error {
+	count, ttl, err := verificationService.Counters.Increment("email_verification_resend_"+hashToken(strings.ToLower(email)), verificationService.ResendWindow)
+	if err != nil {
+		verificationService.Log.Error(fmt.Sprintf("Failed to count verification resends. Error: %s", err.Error()))
+		return err
+	}
+	if count > verificationService.ResendLimit {
+		return &TooManyRequestsError{RetryAfter: ttl}
+	}
+
+	user, err := verificationService.Repo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	return verificationService.SendVerification(user)
+}
+
+func (verificationService *EmailVerificationServiceGORM) sign(userID uint, email string, expiresAt time.Time) string {
+	payload := strconv.FormatUint(uint64(userID), 10) + ":" + strconv.FormatInt(expiresAt.Unix(), 10) + ":" + email
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(verificationService.mac(payload))
+}
+
+func (verificationService *EmailVerificationServiceGORM) parse(token string) (uint, string, error) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, verificationService.mac(string(payload))) {
+		return 0, "", ErrInvalidVerificationToken
+	}
+
+	parts := strings.SplitN(string(payload), ":", 3)
+	if len(parts) != 3 {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	userID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	return uint(userID), parts[2], nil
+}
+
+func (verificationService *EmailVerificationServiceGORM) mac(payload string) []byte {
+	h := hmac.New(sha256.New, verificationService.Secret)
+	h.Write([]byte("email-verification:" + payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+)
+
+var (
+	// ErrInvalidCredentials возвращается при неверном логине или пароле. Намеренно не уточняет, что именно неверно
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	// ErrUserAlreadyExists возвращается при регистрации с занятым логином или почтой
+	ErrUserAlreadyExists = domain.Conflict(domain.CodeUserAlreadyExists, "user with this login or email already exists", nil)
+	// ErrUserNotFound возвращается, если пользователь не существует
+	ErrUserNotFound = domain.NotFound(domain.CodeUserNotFound, "user not found", nil)
+	// ErrInvalidRefreshToken возвращается для неизвестного, истекшего, отозванного или повторно использованного refresh токена
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrInvalidResetToken возвращается для неизвестного, истекшего или уже использованного токена сброса пароля
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	// ErrInvalidVerificationToken возвращается для неверной или истекшей ссылки подтверждения почты
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+	// ErrEmailNotVerified возвращается при входе с неподтвержденной почтой (EMAIL_VERIFICATION_MODE=block)
+	ErrEmailNotVerified = errors.New("email is not verified")
+
+	// ErrTwoFactorAlreadyEnabled возвращается при повторном подключении уже включенной двухфакторной аутентификации
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnabled возвращается, если двухфакторная аутентификация не включена
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTwoFactorNotEnrolled возвращается при подтверждении без предварительного получения секрета TOTP
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment is not started")
+	// ErrInvalidTwoFactorCode возвращается для неверного кода TOTP или кода восстановления
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	// ErrInvalidMFAToken возвращается для неизвестного, истекшего или исчерпавшего попытки промежуточного токена входа
+	ErrInvalidMFAToken = errors.New("invalid or expired mfa token")
+
+	// ErrInvalidWebAuthnChallenge возвращается для неизвестного, истекшего или уже использованного challenge WebAuthn
+	ErrInvalidWebAuthnChallenge = errors.New("invalid or expired webauthn challenge")
+	// ErrInvalidWebAuthnResponse возвращается, если ответ аутентификатора не прошел проверку
+	ErrInvalidWebAuthnResponse = errors.New("invalid webauthn response")
+	// ErrUnsupportedAttestation возвращается для формата аттестации, отличного от "none"
+	ErrUnsupportedAttestation = errors.New("unsupported webauthn attestation format")
+	// ErrUnsupportedWebAuthnKey возвращается для ключа с неподдерживаемым алгоритмом
+	ErrUnsupportedWebAuthnKey = errors.New("unsupported webauthn public key")
+	// ErrWebAuthnCredentialExists возвращается при повторной регистрации того же ключа
+	ErrWebAuthnCredentialExists = errors.New("webauthn credential is already registered")
+	// ErrWebAuthnCredentialCloned возвращается, если счетчик подписей ключа не вырос (признак клонированного аутентификатора)
+	ErrWebAuthnCredentialCloned = errors.New("webauthn credential is possibly cloned")
+	// ErrInsufficientScope возвращается, если access токен выдан без нужного scope (например, /userinfo без openid)
+	ErrInsufficientScope = errors.New("access token does not have the required scope")
+
+	// ErrSessionNotFound возвращается, если сессии нет, она уже завершена или принадлежит другому пользователю
+	ErrSessionNotFound = domain.NotFound(domain.CodeSessionNotFound, "session not found", nil)
+
+	// ErrUnknownProvider возвращается, если внешний провайдер OpenID Connect не настроен
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrInvalidExternalState возвращается, если state входа через провайдера неизвестен, истек или уже использован
+	ErrInvalidExternalState = errors.New("invalid or expired external login state")
+	// ErrInvalidExternalToken возвращается, если провайдер не выдал ID токен или токен не прошел проверку
+	ErrInvalidExternalToken = errors.New("invalid external id token")
+	// ErrExternalEmailRequired возвращается, если провайдер не передал почту пользователя
+	ErrExternalEmailRequired = errors.New("identity provider did not return an email")
+	// ErrExternalEmailNotVerified возвращается, если провайдер не подтвердил почту: по ней нельзя ни создать, ни привязать аккаунт
+	ErrExternalEmailNotVerified = errors.New("identity provider did not verify the email")
+	// ErrExternalAccountConflict возвращается, если почта занята пользователем, которого нельзя привязать автоматически
+	ErrExternalAccountConflict = errors.New("account with this email already exists")
+
+	// ErrTokenMalformed возвращается для access токена, который не удалось разобрать или подпись которого неверна
+	ErrTokenMalformed = errors.New("access token is malformed")
+	// ErrTokenExpired возвращается для access токена с истекшим сроком действия
+	ErrTokenExpired = errors.New("access token is expired")
+	// ErrTokenRevoked возвращается для корректного access токена, который больше нельзя принимать (например, пользователь удален)
+	ErrTokenRevoked = errors.New("access token is revoked")
+	// ErrTokenThirdParty возвращается для access токена клиента OAuth там, где принимаются только токены первой стороны
+	ErrTokenThirdParty = errors.New("access token was issued to a third-party client")
+
+	// ErrRoleNotFound возвращается для несуществующей роли
+	ErrRoleNotFound = domain.NotFound(domain.CodeRoleNotFound, "role not found", nil)
+	// ErrRoleNotAssigned возвращается при отзыве роли, которой у пользователя нет
+	ErrRoleNotAssigned = errors.New("role is not assigned to user")
+	// ErrDefaultRoleRevoke возвращается при попытке отозвать роль по умолчанию
+	ErrDefaultRoleRevoke = errors.New("default role cannot be revoked")
+)
+
+// TooManyRequestsError возвращается при превышении ограничения частоты запросов
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// PasswordPolicyError возвращается, если пароль нарушает политику паролей. Содержит все нарушенные правила
+type PasswordPolicyError struct {
+	Violations []dto.PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy"
+}
+
+// Коды ошибок OAuth 2.0 (RFC 6749 §4.1.2.1, §5.2)
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthServerError             = "server_error"
+)
+
+// OAuthError - ошибка протокола OAuth, которая возвращается клиенту как есть
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/oauth2"
+	"messenger-auth/config"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+const (
+	// Таймаут запросов к провайдеру (discovery, JWKS, обмен кода)
+	externalProviderTimeout = 10 * time.Second
+	// Ограничение длины логина (models.User.Login)
+	maxLoginLength = 50
+)
+
+// Символы, недопустимые в логине, созданном по данным провайдера
+var loginDisallowedChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// externalLoginState - данные начатого входа через провайдера, хранятся в Redis по хешу state
+type externalLoginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	BindingHash  string `json:"bindingHash"` // Хеш значения cookie браузера, начавшего вход
+}
+
+// externalClaims - поля ID токена провайдера, нужные для привязки и создания пользователя
+type externalClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// externalProvider - настроенный провайдер. Discovery загружается при первом обращении,
+// чтобы недоступность провайдера не мешала запуску сервиса
+type externalProvider struct {
+	config config.OIDCProvider
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type ExternalAuthServiceGORM struct {
+	Repo           repositories.UserRepository
+	IdentitiesRepo repositories.ExternalIdentityRepository
+	KeyValues      repositories.KeyValueRepository
+	Auth           AuthService
+	Roles          RoleService
+	Hasher         PasswordHasher
+	StateTTL       time.Duration
+	HTTPClient     *http.Client
+	Log            *slog.Logger
+
+	providers map[string]*externalProvider
+	names     []string
+}
+
+// NewExternalAuthServiceGORM настраивает провайдеров. Адрес возврата по умолчанию строится от publicURL (OIDC_ISSUER)
+func NewExternalAuthServiceGORM(repo repositories.UserRepository, identitiesRepo repositories.ExternalIdentityRepository, keyValues repositories.KeyValueRepository, auth AuthService, roles RoleService, hasher PasswordHasher, providers []config.OIDCProvider, publicURL string, stateTTL time.Duration, logger *slog.Logger) ExternalAuthService {
+	service := &ExternalAuthServiceGORM{
+		Repo:           repo,
+		IdentitiesRepo: identitiesRepo,
+		KeyValues:      keyValues,
+		Auth:           auth,
+		Roles:          roles,
+		Hasher:         hasher,
+		StateTTL:       stateTTL,
+		HTTPClient:     &http.Client{Timeout: externalProviderTimeout},
+		Log:            logger,
+		providers:      make(map[string]*externalProvider, len(providers)),
+	}
+	for _, provider := range providers {
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = strings.TrimSuffix(publicURL, "/") + "/auth/api/v1/external/" + provider.Name + "/callback"
+		}
+		service.providers[provider.Name] = &externalProvider{config: provider}
+		service.names = append(service.names, provider.Name)
+	}
+	return service
+}
+
+// Providers возвращает имена настроенных провайдеров
+func (externalService *ExternalAuthServiceGORM) Providers() []string {
+	return externalService.names
+}
+
+// Begin начинает вход через провайдера и возвращает адрес, на который нужно перенаправить пользователя,
+// и значение, которое нужно сохранить в cookie браузера. state, nonce, PKCE verifier и хеш этого значения
+// хранятся в Redis до возврата пользователя: закончить вход может только браузер, который его начал
+func (externalService *ExternalAuthServiceGORM) Begin(providerName string) (string, string, error) {
+	provider, ok := externalService.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	oauth2Config, _, err := externalService.discover(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	browserBinding, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	loginState := externalLoginState{Provider: providerName, Nonce: nonce, CodeVerifier: oauth2.GenerateVerifier(), BindingHash: hashToken(browserBinding)}
+	if err := externalService.KeyValues.Set(externalStateKey(state), loginState, externalService.StateTTL); err != nil {
+		externalService.Log.Error(fmt.Sprintf("Failed to store external login state. Error: %s", err.Error()), slog.String("provider", providerName))
+		return "", "", err
+	}
+	return oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(loginState.CodeVerifier)), browserBinding, nil
+}
+
+// Finish обменивает код провайдера на ID токен, проверяет его по JWKS провайдера и входит от имени привязанного
+// пользователя. Если учетная запись провайдера еще не привязана, пользователь находится по подтвержденной почте
+// или создается. browserBinding - значение cookie, выданное Begin; без него вход не завершается (защита от login CSRF)
+func (externalService *ExternalAuthServiceGORM) Finish(providerName string, state string, browserBinding string, code string, client *dto.ClientInfo) (*dto.LoginResult, error) {
+	provider, ok := externalService.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	// state одноразовый: повторный возврат с тем же state отклоняется
+	var loginState externalLoginState
+	if err := externalService.KeyValues.Take(externalStateKey(state), &loginState); err != nil {
+		if errors.Is(err, repositories.ErrKeyNotFound) {
+			return nil, ErrInvalidExternalState
+		}
+		externalService.Log.Error(fmt.Sprintf("Failed to read external login state. Error: %s", err.Error()), slog.String("provider", providerName))
+		return nil, err
+	}
+	if loginState.Provider != providerName {
+		return nil, ErrInvalidExternalState
+	}
+	if browserBinding == "" || subtle.ConstantTimeCompare([]byte(hashToken(browserBinding)), []byte(loginState.BindingHash)) != 1 {
+		externalService.Log.Info("External login was finished by another browser", slog.String("provider", providerName))
+		return nil, ErrInvalidExternalState
+	}
+
+	oauth2Config, verifier, err := externalService.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(context.Background(), externalService.HTTPClient), externalProviderTimeout)
+	defer cancel()
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(loginState.CodeVerifier))
+	if err != nil {
+		externalService.Log.Info(fmt.Sprintf("Failed to exchange external authorization code. Error: %s", err.Error()), slog.String("provider", providerName))
+		return nil, ErrInvalidExternalToken
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidExternalToken
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		externalService.Log.Info(fmt.Sprintf("Failed to verify external id token. Error: %s", err.Error()), slog.String("provider", providerName))
+		return nil, ErrInvalidExternalToken
+	}
+	if idToken.Nonce != loginState.Nonce {
+		return nil, ErrInvalidExternalToken
+	}
+	var claims externalClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, ErrInvalidExternalToken
+	}
+
+	user, err := externalService.linkedUser(providerName, idToken.Subject, &claims)
+	if err != nil {
+		return nil, err
+	}
+	externalService.Log.Debug("User was authenticated by external provider", slog.Uint64("user_id", uint64(user.ID)), slog.String("provider", providerName))
+	return externalService.Auth.LoginExternal(user, client)
+}
+
+// linkedUser возвращает пользователя, привязанного к учетной записи провайдера, и создает привязку при первом входе
+func (externalService *ExternalAuthServiceGORM) linkedUser(providerName string, subject string, claims *externalClaims) (*models.User, error) {
+	now := time.Now()
+	identity, err := externalService.IdentitiesRepo.FindBySubject(providerName, subject)
+	if err == nil {
+		user, err := externalService.Repo.FindByID(identity.UserID)
+		if err == nil {
+			if err := externalService.IdentitiesRepo.UpdateLastLogin(identity.ID, now); err != nil {
+				return nil, err
+			}
+			return user, nil
+		}
+		if !errors.Is(err, domain.ErrNotFound) {
+			return nil, err
+		}
+		// Пользователь удален: привязка больше не действует, учетная запись провайдера привязывается заново
+		if err := externalService.IdentitiesRepo.Delete(identity.ID); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		externalService.Log.Error(fmt.Sprintf("Failed to find external identity. Error: %s", err.Error()), slog.String("provider", providerName))
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, ErrExternalEmailRequired
+	}
+	// Неподтвержденный адрес может принадлежать кому угодно: аккаунт с ним занял бы адрес до регистрации
+	// настоящего владельца, а привязка отдала бы чужой аккаунт. Поэтому такой вход отклоняется
+	if !claims.EmailVerified {
+		externalService.Log.Info("External login with unverified email", slog.String("provider", providerName))
+		return nil, ErrExternalEmailNotVerified
+	}
+	user, err := externalService.Repo.FindByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Привязка к существующему пользователю только если почту подтвердил и сервис.
+		// Иначе владелец учетной записи провайдера мог бы захватить чужой аккаунт с той же почтой
+		if user.EmailVerifiedAt == nil {
+			return nil, ErrExternalAccountConflict
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if user, err = externalService.createUser(claims, now); err != nil {
+			return nil, err
+		}
+	default:
+		externalService.Log.Error(fmt.Sprintf("Failed to find user by email. Error: %s", err.Error()), slog.String("provider", providerName))
+		return nil, err
+	}
+
+	if err := externalService.IdentitiesRepo.Create(&models.ExternalIdentity{
+		UserID:      user.ID,
+		Provider:    providerName,
+		Subject:     subject,
+		Email:       claims.Email,
+		CreatedAt:   now,
+		LastLoginAt: &now,
+	}); err != nil {
+		return nil, err
+	}
+	externalService.Log.Info("External identity was linked", slog.Uint64("user_id", uint64(user.ID)), slog.String("provider", providerName))
+	return user, nil
+}
+
+// createUser создает пользователя по данным ID токена. Пароль случайный: войти по паролю можно после его сброса
+func (externalService *ExternalAuthServiceGORM) createUser(claims *externalClaims, now time.Time) (*models.User, error) {
+	login, err := externalService.freeLogin(claims)
+	if err != nil {
+		return nil, err
+	}
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := externalService.Hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	defaultRole, err := externalService.Roles.DefaultRole()
+	if err != nil {
+		externalService.Log.Error(fmt.Sprintf("Failed to get default role. Error: %s", err.Error()), slog.String("login", login))
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = login
+	}
+	user := models.User{
+		Login:         &login,
+		Name:          &name,
+		Email:         &claims.Email,
+		Password:      &passwordHash,
+		ServiceRoleID: defaultRole.ID,
+	}
+	if claims.GivenName != "" {
+		user.FirstName = &claims.GivenName
+	}
+	if claims.FamilyName != "" {
+		user.LastName = &claims.FamilyName
+	}
+	// Почту подтвердил провайдер (см. linkedUser)
+	user.EmailVerifiedAt = &now
+	if err := externalService.Repo.Create(&user); err != nil {
+		externalService.Log.Error(fmt.Sprintf("Failed to create user from external identity. Error: %s", err.Error()), slog.String("login", login))
+		return nil, err
+	}
+	externalService.Log.Debug("User was created from external identity", slog.Uint64("user_id", uint64(user.ID)))
+	return &user, nil
+}
+
+// freeLogin подбирает свободный логин по preferred_username или почте, при совпадении добавляет случайный суффикс
+func (externalService *ExternalAuthServiceGORM) freeLogin(claims *externalClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = loginDisallowedChars.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > maxLoginLength-9 {
+		base = base[:maxLoginLength-9]
+	}
+
+	login := base
+	for attempt := 0; attempt < 5; attempt++ {
+		_, err := externalService.Repo.FindByLogin(login)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return login, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		suffix, err := randomToken(6)
+		if err != nil {
+			return "", err
+		}
+		login = base + "_" + suffix
+	}
+	return "", fmt.Errorf("failed to find free login for %q", base)
+}
+
+// discover загружает discovery провайдера при первом обращении. При ошибке загрузка повторяется при следующем входе
+func (externalService *ExternalAuthServiceGORM) discover(provider *externalProvider) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.oauth2 != nil {
+		return provider.oauth2, provider.verifier, nil
+	}
+
+	// Контекст сохраняется в наборе ключей провайдера и используется для загрузки JWKS, поэтому он без таймаута
+	ctx := oidc.ClientContext(context.Background(), externalService.HTTPClient)
+	discovered, err := oidc.NewProvider(ctx, provider.config.Issuer)
+	if err != nil {
+		externalService.Log.Error(fmt.Sprintf("Failed to load oidc provider discovery. Error: %s", err.Error()), slog.String("provider", provider.config.Name))
+		return nil, nil, err
+	}
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range provider.config.Scopes {
+		if scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+	provider.oauth2 = &oauth2.Config{
+		ClientID:     provider.config.ClientID,
+		ClientSecret: provider.config.ClientSecret,
+		RedirectURL:  provider.config.RedirectURL,
+		Endpoint:     discovered.Endpoint(),
+		Scopes:       scopes,
+	}
+	provider.verifier = discovered.Verifier(&oidc.Config{ClientID: provider.config.ClientID})
+	return provider.oauth2, provider.verifier, nil
+}
+
+func externalStateKey(state string) string {
+	return "external_state_" + hashToken(state)
+}
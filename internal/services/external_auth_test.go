@@ -0,0 +1,287 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger-auth/config"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/testutil"
+)
+
+const (
+	mockIdPProvider = "mock"
+	mockIdPClientID = "messenger"
+	mockIdPKeyID    = "mock-key"
+	mockIdPCode     = "mock-code"
+)
+
+// mockIdP - OIDC провайдер на httptest: discovery, JWKS и token endpoint, который выдает ID токен с заданными claims
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	idp := &mockIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockIdPKeyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != mockIdPCode || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken, err := idp.idToken()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"access_token": "mock-access", "token_type": "Bearer", "expires_in": 300, "id_token": idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// idToken подписывает claims следующего ответа token endpoint
+func (idp *mockIdP) idToken() (string, error) {
+	idp.mu.Lock()
+	claims := jwt.MapClaims{
+		"iss": idp.server.URL,
+		"aud": mockIdPClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range idp.claims {
+		claims[name] = value
+	}
+	idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockIdPKeyID
+	return token.SignedString(idp.key)
+}
+
+// externalLogin проходит вход целиком: Begin, "авторизация" у провайдера с заданными claims и Finish
+// с cookie браузера. nonce берется из адреса авторизации, если claims его не задают
+func (idp *mockIdP) externalLogin(t *testing.T, service ExternalAuthService, claims jwt.MapClaims, browserBinding func(issued string) string) (*dto.LoginResult, error) {
+	t.Helper()
+	redirectURL, binding, err := service.Begin(mockIdPProvider)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	authorizeURL, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	query := authorizeURL.Query()
+	if query.Get("code_challenge") == "" {
+		t.Fatalf("authorization url %q has no PKCE challenge", redirectURL)
+	}
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+	idp.mu.Lock()
+	idp.claims = claims
+	idp.mu.Unlock()
+	if browserBinding != nil {
+		binding = browserBinding(binding)
+	}
+	return service.Finish(mockIdPProvider, query.Get("state"), binding, mockIdPCode, &dto.ClientInfo{})
+}
+
+func newTestExternalAuthService(idp *mockIdP, users *testutil.UserRepo) (ExternalAuthService, *fakeExternalIdentityRepo, *fakeAuthService) {
+	identities := &fakeExternalIdentityRepo{}
+	auth := &fakeAuthService{}
+	providers := []config.OIDCProvider{{Name: mockIdPProvider, Issuer: idp.server.URL, ClientID: mockIdPClientID, ClientSecret: "secret"}}
+	service := NewExternalAuthServiceGORM(users, identities, newFakeKeyValueRepo(), auth, &fakeRoleService{}, fakeHasher{}, providers, "https://auth.messenger.test", time.Minute, discardLogger())
+	return service, identities, auth
+}
+
+func verifiedUser(login string) *models.User {
+	user := newTestUser(login)
+	verifiedAt := time.Now()
+	user.EmailVerifiedAt = &verifiedAt
+	return user
+}
+
+func TestExternalLoginCreatesVerifiedUser(t *testing.T) {
+	idp := newMockIdP(t)
+	users := testutil.NewUserRepo()
+	service, identities, auth := newTestExternalAuthService(idp, users)
+
+	result, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-1", "email": "bob@messenger.test", "email_verified": true, "preferred_username": "bob"}, nil)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if result.TokenData == nil || len(auth.loggedIn) != 1 {
+		t.Fatalf("result = %+v, logins = %v, want one login", result, auth.loggedIn)
+	}
+	user, err := users.FindByID(auth.loggedIn[0])
+	if err != nil {
+		t.Fatalf("created user was not stored: %v", err)
+	}
+	if *user.Login != "bob" || *user.Email != "bob@messenger.test" || user.EmailVerifiedAt == nil {
+		t.Fatalf("created user = login %q email %q verified %v", *user.Login, *user.Email, user.EmailVerifiedAt)
+	}
+	if *user.Password == "" {
+		t.Fatal("created user has no password hash")
+	}
+	if identity, err := identities.FindBySubject(mockIdPProvider, "subject-1"); err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity = %+v, %v, want link to user %d", identity, err, user.ID)
+	}
+
+	// Повторный вход идет по привязке, даже если провайдер сменил почту
+	if _, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-1", "email": "other@messenger.test", "email_verified": true}, nil); err != nil {
+		t.Fatalf("second Finish: %v", err)
+	}
+	if len(auth.loggedIn) != 2 || auth.loggedIn[1] != user.ID {
+		t.Fatalf("logins = %v, want the linked user twice", auth.loggedIn)
+	}
+}
+
+func TestExternalLoginRejectsUnverifiedEmail(t *testing.T) {
+	idp := newMockIdP(t)
+	users := testutil.NewUserRepo(verifiedUser("alice"))
+	service, identities, auth := newTestExternalAuthService(idp, users)
+
+	for _, email := range []string{"new@messenger.test", "alice@messenger.test"} {
+		_, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-" + email, "email": email, "email_verified": false}, nil)
+		if !errors.Is(err, ErrExternalEmailNotVerified) {
+			t.Fatalf("%s: err = %v, want ErrExternalEmailNotVerified", email, err)
+		}
+	}
+	if _, err := users.FindByEmail("new@messenger.test"); err == nil {
+		t.Fatal("user was created from an unverified email")
+	}
+	if len(identities.identities) != 0 || len(auth.loggedIn) != 0 {
+		t.Fatalf("identities = %+v, logins = %v, want none", identities.identities, auth.loggedIn)
+	}
+}
+
+func TestExternalLoginLinksVerifiedUser(t *testing.T) {
+	idp := newMockIdP(t)
+	users := testutil.NewUserRepo(verifiedUser("alice"))
+	service, identities, auth := newTestExternalAuthService(idp, users)
+
+	if _, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-1", "email": "alice@messenger.test", "email_verified": true}, nil); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if len(auth.loggedIn) != 1 || auth.loggedIn[0] != 1 {
+		t.Fatalf("logins = %v, want alice", auth.loggedIn)
+	}
+	if identity, err := identities.FindBySubject(mockIdPProvider, "subject-1"); err != nil || identity.UserID != 1 {
+		t.Fatalf("identity = %+v, %v, want link to alice", identity, err)
+	}
+}
+
+func TestExternalLoginDoesNotLinkUnverifiedLocalUser(t *testing.T) {
+	idp := newMockIdP(t)
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, identities, _ := newTestExternalAuthService(idp, users)
+
+	_, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-1", "email": "alice@messenger.test", "email_verified": true}, nil)
+	if !errors.Is(err, ErrExternalAccountConflict) {
+		t.Fatalf("err = %v, want ErrExternalAccountConflict", err)
+	}
+	if len(identities.identities) != 0 {
+		t.Fatalf("identities = %+v, want none", identities.identities)
+	}
+}
+
+func TestExternalLoginRequiresBrowserBinding(t *testing.T) {
+	idp := newMockIdP(t)
+	service, _, auth := newTestExternalAuthService(idp, testutil.NewUserRepo())
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "subject-1", "email": "bob@messenger.test", "email_verified": true}
+	}
+
+	// Жертва открывает callback со state атакующего: cookie у ее браузера нет или она от другого входа
+	for name, binding := range map[string]func(string) string{
+		"missing": func(string) string { return "" },
+		"foreign": func(issued string) string { return issued + "x" },
+	} {
+		if _, err := idp.externalLogin(t, service, claims(), binding); !errors.Is(err, ErrInvalidExternalState) {
+			t.Fatalf("%s cookie: err = %v, want ErrInvalidExternalState", name, err)
+		}
+	}
+	if len(auth.loggedIn) != 0 {
+		t.Fatalf("logins = %v, want none", auth.loggedIn)
+	}
+}
+
+func TestExternalLoginRejectsStateReplay(t *testing.T) {
+	idp := newMockIdP(t)
+	service, _, _ := newTestExternalAuthService(idp, testutil.NewUserRepo())
+
+	redirectURL, binding, err := service.Begin(mockIdPProvider)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	authorizeURL, _ := url.Parse(redirectURL)
+	state := authorizeURL.Query().Get("state")
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": "bob@messenger.test", "email_verified": true, "nonce": authorizeURL.Query().Get("nonce")}
+	if _, err := service.Finish(mockIdPProvider, state, binding, mockIdPCode, &dto.ClientInfo{}); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if _, err := service.Finish(mockIdPProvider, state, binding, mockIdPCode, &dto.ClientInfo{}); !errors.Is(err, ErrInvalidExternalState) {
+		t.Fatalf("replayed Finish: err = %v, want ErrInvalidExternalState", err)
+	}
+}
+
+func TestExternalLoginRejectsWrongNonce(t *testing.T) {
+	idp := newMockIdP(t)
+	service, _, auth := newTestExternalAuthService(idp, testutil.NewUserRepo())
+
+	_, err := idp.externalLogin(t, service, jwt.MapClaims{"sub": "subject-1", "email": "bob@messenger.test", "email_verified": true, "nonce": "other"}, nil)
+	if !errors.Is(err, ErrInvalidExternalToken) {
+		t.Fatalf("err = %v, want ErrInvalidExternalToken", err)
+	}
+	if len(auth.loggedIn) != 0 {
+		t.Fatalf("logins = %v, want none", auth.loggedIn)
+	}
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+// Заглушки репозиториев для тестов сервисов. Хранят данные в памяти и повторяют семантику
+// Postgres/Redis реализаций в том, что важно сервисам: ошибки "не найдено" и условные обновления.
+// Заглушка UserRepository общая с тестами обработчиков: testutil.UserRepo
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// fakeWebAuthnCredentialRepo хранит ключи доступа в памяти. UpdateSignCount повторяет условие UPDATE из Postgres
+type fakeWebAuthnCredentialRepo struct {
+	mu          sync.Mutex
+	credentials []models.WebAuthnCredential
+}
+
+func (repo *fakeWebAuthnCredentialRepo) Create(credential *models.WebAuthnCredential) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	credential.ID = uint(len(repo.credentials) + 1)
+	repo.credentials = append(repo.credentials, *credential)
+	return nil
+}
+
+func (repo *fakeWebAuthnCredentialRepo) FindByCredentialID(credentialID string) (*models.WebAuthnCredential, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, credential := range repo.credentials {
+		if credential.CredentialID == credentialID {
+			return &credential, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (repo *fakeWebAuthnCredentialRepo) FindByUser(userID uint) ([]models.WebAuthnCredential, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var credentials []models.WebAuthnCredential
+	for _, credential := range repo.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (repo *fakeWebAuthnCredentialRepo) UpdateSignCount(id uint, signCount int64, usedAt time.Time) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	credential := &repo.credentials[id-1]
+	if credential.CloneWarning || (signCount == 0 && credential.SignCount != 0) || (signCount != 0 && credential.SignCount >= signCount) {
+		return false, nil
+	}
+	credential.SignCount = signCount
+	credential.LastUsedAt = &usedAt
+	return true, nil
+}
+
+func (repo *fakeWebAuthnCredentialRepo) MarkCloned(id uint) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.credentials[id-1].CloneWarning = true
+	return nil
+}
+
+// fakeKeyValueRepo хранит значения в памяти в JSON, как KeyValueRepoRedis. Срок жизни не учитывается
+type fakeKeyValueRepo struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newFakeKeyValueRepo() *fakeKeyValueRepo {
+	return &fakeKeyValueRepo{values: map[string][]byte{}}
+}
+
+func (repo *fakeKeyValueRepo) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.values[key] = data
+	return nil
+}
+
+func (repo *fakeKeyValueRepo) Get(key string, dest interface{}) error {
+	repo.mu.Lock()
+	data, ok := repo.values[key]
+	repo.mu.Unlock()
+	if !ok {
+		return repositories.ErrKeyNotFound
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (repo *fakeKeyValueRepo) Take(key string, dest interface{}) error {
+	repo.mu.Lock()
+	data, ok := repo.values[key]
+	delete(repo.values, key)
+	repo.mu.Unlock()
+	if !ok {
+		return repositories.ErrKeyNotFound
+	}
+	return json.Unmarshal(data, dest)
+}
+
+func (repo *fakeKeyValueRepo) Delete(key string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.values, key)
+	return nil
+}
+
+// fakeExternalIdentityRepo хранит привязки учетных записей провайдеров в памяти
+type fakeExternalIdentityRepo struct {
+	mu         sync.Mutex
+	identities []models.ExternalIdentity
+}
+
+func (repo *fakeExternalIdentityRepo) Create(identity *models.ExternalIdentity) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	identity.ID = uint(len(repo.identities) + 1)
+	repo.identities = append(repo.identities, *identity)
+	return nil
+}
+
+func (repo *fakeExternalIdentityRepo) FindBySubject(provider string, subject string) (*models.ExternalIdentity, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, identity := range repo.identities {
+		if identity.ID != 0 && identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (repo *fakeExternalIdentityRepo) UpdateLastLogin(id uint, loggedInAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.identities[id-1].LastLoginAt = &loggedInAt
+	return nil
+}
+
+func (repo *fakeExternalIdentityRepo) Delete(id uint) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.identities[id-1].ID = 0
+	return nil
+}
+
+// fakeAuthService выдает фиктивные токены и запоминает, от чьего имени был выполнен вход
+type fakeAuthService struct {
+	AuthService
+
+	loggedIn []uint
+}
+
+func (auth *fakeAuthService) LoginExternal(user *models.User, client *dto.ClientInfo) (*dto.LoginResult, error) {
+	auth.loggedIn = append(auth.loggedIn, user.ID)
+	return &dto.LoginResult{TokenData: &dto.TokenData{AccessToken: "access-" + strconv.FormatUint(uint64(user.ID), 10), TokenType: "Bearer"}}, nil
+}
+
+// Роли fakeRoleService: роль по умолчанию без прав и роль администратора со всеми правами
+const (
+	fakeUserRoleID  uint = 1
+	fakeAdminRoleID uint = 2
+)
+
+// fakeRoleService знает только роль по умолчанию и роль администратора
+type fakeRoleService struct {
+	RoleService
+}
+
+func (roles *fakeRoleService) DefaultRole() (*models.Role, error) {
+	return &models.Role{ID: fakeUserRoleID, Name: models.RoleNameUser}, nil
+}
+
+func (roles *fakeRoleService) HasPermission(roleID uint, permission string) (bool, error) {
+	return roleID == fakeAdminRoleID, nil
+}
+
+// fakeSessionService запоминает пользователей, у которых отозваны все сессии
+type fakeSessionService struct {
+	SessionService
+
+	revoked []uint
+}
+
+func (sessions *fakeSessionService) RevokeAllSessions(userID uint) error {
+	sessions.revoked = append(sessions.revoked, userID)
+	return nil
+}
+
+// fakeHasher хранит пароль с префиксом вместо хеша
+type fakeHasher struct{}
+
+func (fakeHasher) Hash(password string) (string, error) {
+	return "hashed:" + password, nil
+}
+
+func (fakeHasher) Verify(encoded string, password string) (bool, error) {
+	return encoded == "hashed:"+password, nil
+}
+
+func (fakeHasher) NeedsRehash(encoded string) bool {
+	return false
+}
+
+// fakeVerifier запоминает, кому отправлены ссылки подтверждения
+type fakeVerifier struct {
+	EmailVerificationService
+
+	sentTo []uint
+}
+
+func (verifier *fakeVerifier) SendVerification(user *models.User) error {
+	verifier.sentTo = append(verifier.sentTo, user.ID)
+	return nil
+}
+
+// fakeCounterRepo считает значения в памяти без учета окна
+type fakeCounterRepo struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (repo *fakeCounterRepo) Increment(key string, window time.Duration) (int64, time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.counts == nil {
+		repo.counts = map[string]int64{}
+	}
+	repo.counts[key]++
+	return repo.counts[key], window, nil
+}
+
+// fakeTwoFactor принимает единственный верный код
+type fakeTwoFactor struct {
+	TwoFactorService
+
+	code string
+}
+
+func (twoFactor *fakeTwoFactor) VerifyCode(user *models.User, code string) (bool, error) {
+	return code == twoFactor.code, nil
+}
+
+// fakeThrottle блокирует учетную запись после maxFailures неудачных попыток (IP не учитывается)
+type fakeThrottle struct {
+	mu          sync.Mutex
+	maxFailures int
+	failures    map[string]int
+}
+
+func (throttle *fakeThrottle) Check(login string, ip string) error {
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+	if throttle.failures[login] >= throttle.maxFailures {
+		return &TooManyRequestsError{RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (throttle *fakeThrottle) RegisterFailure(login string, ip string) error {
+	throttle.mu.Lock()
+	defer throttle.mu.Unlock()
+	if throttle.failures == nil {
+		throttle.failures = map[string]int{}
+	}
+	throttle.failures[login]++
+	return nil
+}
+
+func (throttle *fakeThrottle) RegisterSuccess(login string, ip string) error { return nil }
+func (throttle *fakeThrottle) ClearLockout(login string, ip string) error    { return nil }
+
+// fakeSessionRepo хранит сессии в памяти; отозванные сессии не считаются активными
+type fakeSessionRepo struct {
+	repositories.SessionRepository
+
+	sessions []models.Session
+}
+
+func (repo *fakeSessionRepo) FindActiveByUser(userID uint, now time.Time) ([]models.Session, error) {
+	var active []models.Session
+	for _, session := range repo.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+func (repo *fakeSessionRepo) RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error {
+	for i := range repo.sessions {
+		if repo.sessions[i].UserID == userID && repo.sessions[i].FamilyID != exceptFamilyID && repo.sessions[i].RevokedAt == nil {
+			repo.sessions[i].RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+// fakeRefreshRepo не хранит refresh токены: сервисам сессий важен только сам вызов отзыва
+type fakeRefreshRepo struct {
+	repositories.RefreshTokenRepository
+}
+
+func (repo *fakeRefreshRepo) RevokeAllForUser(userID uint, exceptFamilyID string, revokedAt time.Time) error {
+	return nil
+}
+
+// fakeDenylist запоминает отозванные сессии и время not before пользователей
+type fakeDenylist struct {
+	repositories.TokenDenylistRepository
+
+	sessions  []string
+	notBefore map[uint]time.Time
+}
+
+func (denylist *fakeDenylist) DenySession(sessionID string, ttl time.Duration) error {
+	denylist.sessions = append(denylist.sessions, sessionID)
+	return nil
+}
+
+func (denylist *fakeDenylist) SetUserNotBefore(userID uint, notBefore time.Time, ttl time.Duration) error {
+	if denylist.notBefore == nil {
+		denylist.notBefore = map[uint]time.Time{}
+	}
+	denylist.notBefore[userID] = notBefore
+	return nil
+}
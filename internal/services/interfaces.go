@@ -1,16 +1,128 @@
 package services
 
 import (
+	"context"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 	"messenger-auth/internal/dto"
 	"messenger-auth/internal/models"
 	"messenger-auth/internal/repositories"
 )
 
 type UserService interface {
-	CreateUser(userDTO *dto.UserData) error
-	UpdateUser(id uint, userDTO *dto.UserData) error
+	CreateUser(userDTO *dto.CreateUserData) (*models.User, error)
+	UpdateUser(id uint, userDTO *dto.UserData) (*models.User, error)
 	DeleteUser(id uint) error
 	GetUserByID(id uint) (*models.User, error)
-	GetUsers() ([]models.User, error)
+	GetUsersByIDs(ids []uint) ([]models.User, error)
+	GetUsers(query *dto.UserListQuery) ([]models.User, *dto.PageMeta, error)
+	SearchUsers(callerID uint, query *dto.UserSearchQuery) ([]dto.UserSearchResult, error)
 	GetRepo() repositories.UserRepository
 }
+
+type AuthService interface {
+	Register(registerDTO *dto.RegisterData) error
+	Login(loginDTO *dto.LoginData, client *dto.ClientInfo) (*dto.LoginResult, error)
+	LoginTwoFactor(mfaToken string, code string, client *dto.ClientInfo) (*dto.TokenData, error)
+	LoginPasswordless(user *models.User, client *dto.ClientInfo) (*dto.TokenData, error)
+	LoginForClient(user *models.User, clientID string, scope string, authTime time.Time, client *dto.ClientInfo) (*dto.TokenData, error)
+	LoginExternal(user *models.User, client *dto.ClientInfo) (*dto.LoginResult, error)
+	Refresh(refreshToken string, clientID string, client *dto.ClientInfo) (*dto.TokenData, error)
+	VerifyAccessToken(accessToken string) (*AccessClaims, error)
+	VerifyClientAccessToken(accessToken string) (*AccessClaims, error)
+	Logout(claims *AccessClaims) error
+}
+
+type TokenService interface {
+	IssueAccessToken(userID uint, role uint, clientID string, scope string, authTime time.Time, sessionID string) (string, time.Time, error)
+	SignIDToken(claims jwt.Claims) (string, error)
+	IDTokenTTL() time.Duration
+	ParseAccessToken(token string) (*AccessClaims, error)
+	PublicKeys() []dto.JWK
+	RotateKeys() error
+	StartKeyRotation(ctx context.Context)
+}
+
+type RoleService interface {
+	GetRoles() ([]models.Role, error)
+	DefaultRole() (*models.Role, error)
+	GetRoleByName(name string) (*models.Role, error)
+	HasPermission(roleID uint, permission string) (bool, error)
+	AssignRole(userID uint, roleID uint) error
+	RevokeRole(userID uint, roleID uint) error
+}
+
+type PasswordService interface {
+	ForgotPassword(email string) error
+	ResetPassword(token string, newPassword string) error
+	ChangePassword(userID uint, currentPassword string, newPassword string, currentSessionID string) error
+}
+
+type SessionService interface {
+	StartSession(userID uint, familyID string, client *dto.ClientInfo, expiresAt time.Time) error
+	TouchSession(userID uint, familyID string, client *dto.ClientInfo, expiresAt time.Time) error
+	EndSession(familyID string) error
+	GetSessions(userID uint, currentFamilyID string) ([]dto.SessionInfo, error)
+	RevokeSession(userID uint, sessionID uint) error
+	RevokeOtherSessions(userID uint, currentFamilyID string) error
+	RevokeAllSessions(userID uint) error
+}
+
+type LoginThrottleService interface {
+	Check(login string, ip string) error
+	RegisterFailure(login string, ip string) error
+	RegisterSuccess(login string, ip string) error
+	ClearLockout(login string, ip string) error
+}
+
+type RateLimitService interface {
+	Allow(route string, principal RateLimitPrincipal) error
+}
+
+type PasswordPolicyService interface {
+	Check(password string, login string, email string) error
+}
+
+type EmailVerificationService interface {
+	SendVerification(user *models.User) error
+	SendEmailChange(user *models.User) error
+	SendAccountExists(email string) error
+	SendLoginTaken(email string, login string) error
+	VerifyEmail(token string) error
+	ResendVerification(email string) error
+}
+
+type TwoFactorService interface {
+	Enroll(userID uint) (*dto.TOTPEnrollmentData, error)
+	Confirm(userID uint, code string) ([]string, error)
+	Disable(userID uint, code string) error
+	RegenerateRecoveryCodes(userID uint, code string) ([]string, error)
+	VerifyCode(user *models.User, code string) (bool, error)
+}
+
+type WebAuthnService interface {
+	BeginRegistration(userID uint) (*dto.WebAuthnCreationOptions, error)
+	FinishRegistration(userID uint, registrationDTO *dto.WebAuthnRegistrationData) (*models.WebAuthnCredential, error)
+	BeginLogin(login string) (*dto.WebAuthnRequestOptions, error)
+	FinishLogin(assertionDTO *dto.WebAuthnAssertionData) (*models.User, error)
+}
+
+type OAuthService interface {
+	CreateClient(clientDTO *dto.OAuthClientData) (*dto.OAuthClientInfo, error)
+	GetClients() ([]dto.OAuthClientInfo, error)
+	Authorize(userID uint, authTime time.Time, authorizeDTO *dto.OAuthAuthorizeRequest) (string, error)
+	Token(tokenDTO *dto.OAuthTokenRequest, client *dto.ClientInfo) (*dto.OAuthTokenResponse, error)
+}
+
+type OIDCService interface {
+	Discovery() *dto.OpenIDConfiguration
+	IssueIDToken(user *models.User, clientID string, scope string, nonce string, authTime time.Time) (string, error)
+	UserInfo(userID uint, scope string) (*dto.UserInfo, error)
+}
+
+type ExternalAuthService interface {
+	Providers() []string
+	Begin(provider string) (redirectURL string, browserBinding string, err error)
+	Finish(provider string, state string, browserBinding string, code string, client *dto.ClientInfo) (*dto.LoginResult, error)
+}
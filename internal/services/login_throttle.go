@@ -0,0 +1,190 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"messenger-auth/internal/repositories"
+)
+
+// LoginThrottleLimits - пороги защиты входа по паролю от перебора
+type LoginThrottleLimits struct {
+	// Допустимое число неудачных попыток за FailureWindow для учетной записи, IP и пары (учетная запись, IP)
+	MaxAccountFailures int64
+	MaxIPFailures      int64
+	MaxPairFailures    int64
+	FailureWindow      time.Duration
+	// Допустимое число разных учетных записей, на которые входят с одного IP за AccountsPerIPWindow (credential stuffing)
+	MaxAccountsPerIP    int64
+	AccountsPerIPWindow time.Duration
+	// Первая блокировка длится LockoutBase, каждая следующая вдвое дольше, но не дольше LockoutMax.
+	// Счет блокировок сбрасывается, если их не было LockoutResetAfter
+	LockoutBase       time.Duration
+	LockoutMax        time.Duration
+	LockoutResetAfter time.Duration
+}
+
+// Области, по которым считаются неудачные попытки входа
+const (
+	throttleScopeAccount = "account"
+	throttleScopeIP      = "ip"
+	throttleScopePair    = "pair"
+)
+
+type LoginThrottleServiceRedis struct {
+	Repo   repositories.LoginAttemptRepository
+	Limits LoginThrottleLimits
+	Log    *slog.Logger
+}
+
+func NewLoginThrottleServiceRedis(repo repositories.LoginAttemptRepository, limits LoginThrottleLimits, logger *slog.Logger) LoginThrottleService {
+	return &LoginThrottleServiceRedis{Repo: repo, Limits: limits, Log: logger}
+}
+
+// Check возвращает *TooManyRequestsError, если заблокирована учетная запись, IP или их пара.
+// Проверка не зависит от существования учетной записи, чтобы блокировка не выдавала зарегистрированные логины
+func (throttleService *LoginThrottleServiceRedis) Check(login string, ip string) error {
+	account := throttleAccount(login)
+	lockedFor, err := throttleService.Repo.LockedFor(
+		lockKey(throttleScopeAccount, account),
+		lockKey(throttleScopeIP, ip),
+		lockKey(throttleScopePair, account+"_"+ip),
+	)
+	if err != nil {
+		throttleService.Log.Error(fmt.Sprintf("Failed to check login lockout. Error: %s", err.Error()), slog.String("ip", ip))
+		return err
+	}
+	if lockedFor > 0 {
+		return &TooManyRequestsError{RetryAfter: lockedFor}
+	}
+	return nil
+}
+
+// RegisterFailure учитывает неудачную попытку входа и блокирует области, превысившие порог
+func (throttleService *LoginThrottleServiceRedis) RegisterFailure(login string, ip string) error {
+	account := throttleAccount(login)
+	attempt, err := randomToken(8)
+	if err != nil {
+		return err
+	}
+	limits := throttleService.Limits
+	checks := []struct {
+		scope, id string
+		max       int64
+	}{
+		{throttleScopeAccount, account, limits.MaxAccountFailures},
+		{throttleScopeIP, ip, limits.MaxIPFailures},
+		{throttleScopePair, account + "_" + ip, limits.MaxPairFailures},
+	}
+	for _, check := range checks {
+		if check.max <= 0 {
+			continue
+		}
+		count, err := throttleService.Repo.AddToWindow(failuresKey(check.scope, check.id), attempt, limits.FailureWindow)
+		if err != nil {
+			throttleService.Log.Error(fmt.Sprintf("Failed to count login failures. Error: %s", err.Error()), slog.String("scope", check.scope), slog.String("ip", ip))
+			return err
+		}
+		if count >= check.max {
+			if err := throttleService.lock(check.scope, check.id); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Один IP, перебирающий много разных учетных записей, блокируется целиком, даже если на каждую была одна попытка
+	if limits.MaxAccountsPerIP > 0 {
+		accounts, err := throttleService.Repo.AddToWindow(accountsPerIPKey(ip), account, limits.AccountsPerIPWindow)
+		if err != nil {
+			throttleService.Log.Error(fmt.Sprintf("Failed to count accounts per ip. Error: %s", err.Error()), slog.String("ip", ip))
+			return err
+		}
+		if accounts > limits.MaxAccountsPerIP {
+			throttleService.Log.Warn("Credential stuffing suspected", slog.String("ip", ip), slog.Int64("accounts", accounts))
+			if err := throttleService.lock(throttleScopeIP, ip); err != nil {
+				return err
+			}
+			return throttleService.Repo.Clear(accountsPerIPKey(ip))
+		}
+	}
+	return nil
+}
+
+// RegisterSuccess сбрасывает неудачные попытки пары (учетная запись, IP) после успешного входа.
+// Счетчики учетной записи и IP не сбрасываются: иначе перебор можно было бы перемежать входами в свою учетную запись
+func (throttleService *LoginThrottleServiceRedis) RegisterSuccess(login string, ip string) error {
+	return throttleService.Repo.Clear(failuresKey(throttleScopePair, throttleAccount(login)+"_"+ip))
+}
+
+// ClearLockout снимает блокировки и сбрасывает счетчики учетной записи login и/или адреса ip
+func (throttleService *LoginThrottleServiceRedis) ClearLockout(login string, ip string) error {
+	var ids [][2]string
+	if login != "" {
+		ids = append(ids, [2]string{throttleScopeAccount, throttleAccount(login)})
+	}
+	if ip != "" {
+		ids = append(ids, [2]string{throttleScopeIP, ip})
+	}
+	if login != "" && ip != "" {
+		ids = append(ids, [2]string{throttleScopePair, throttleAccount(login) + "_" + ip})
+	}
+	keys := make([]string, 0, 3*len(ids)+1)
+	for _, id := range ids {
+		keys = append(keys, lockKey(id[0], id[1]), lockLevelKey(id[0], id[1]), failuresKey(id[0], id[1]))
+	}
+	if ip != "" {
+		keys = append(keys, accountsPerIPKey(ip))
+	}
+	if err := throttleService.Repo.Clear(keys...); err != nil {
+		throttleService.Log.Error(fmt.Sprintf("Failed to clear login lockout. Error: %s", err.Error()), slog.String("ip", ip))
+		return err
+	}
+	throttleService.Log.Info("Login lockout was cleared", slog.String("login", login), slog.String("ip", ip))
+	return nil
+}
+
+// lock блокирует область на LockoutBase * 2^(уровень-1), но не дольше LockoutMax, и начинает подсчет попыток заново
+func (throttleService *LoginThrottleServiceRedis) lock(scope string, id string) error {
+	limits := throttleService.Limits
+	level, err := throttleService.Repo.NextLevel(lockLevelKey(scope, id), limits.LockoutResetAfter)
+	if err != nil {
+		throttleService.Log.Error(fmt.Sprintf("Failed to lock login. Error: %s", err.Error()), slog.String("scope", scope))
+		return err
+	}
+	duration := limits.LockoutBase
+	for i := int64(1); i < level && duration < limits.LockoutMax; i++ {
+		duration *= 2
+	}
+	if duration > limits.LockoutMax {
+		duration = limits.LockoutMax
+	}
+	if err := throttleService.Repo.Lock(lockKey(scope, id), duration); err != nil {
+		throttleService.Log.Error(fmt.Sprintf("Failed to lock login. Error: %s", err.Error()), slog.String("scope", scope))
+		return err
+	}
+	throttleService.Log.Warn("Login was locked", slog.String("scope", scope), slog.Int64("level", level), slog.Duration("duration", duration))
+	return throttleService.Repo.Clear(failuresKey(scope, id))
+}
+
+// throttleAccount нормализует логин: регистр не должен давать обойти счетчики учетной записи
+func throttleAccount(login string) string {
+	return hashToken(strings.ToLower(strings.TrimSpace(login)))
+}
+
+func failuresKey(scope string, id string) string {
+	return "login_failures_" + scope + "_" + id
+}
+
+func lockKey(scope string, id string) string {
+	return "login_lock_" + scope + "_" + id
+}
+
+func lockLevelKey(scope string, id string) string {
+	return "login_lock_level_" + scope + "_" + id
+}
+
+func accountsPerIPKey(ip string) string {
+	return "login_accounts_ip_" + ip
+}
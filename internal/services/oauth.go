@@ -0,0 +1,326 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+const (
+	oauthGrantAuthorizationCode = "authorization_code"
+	oauthGrantRefreshToken      = "refresh_token"
+	oauthCodeChallengeS256      = "S256"
+)
+
+// code_verifier по RFC 7636 §4.1: 43-128 символов из unreserved
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// authorizationCode - данные, сохраненные для выданного кода авторизации
+type authorizationCode struct {
+	ClientID      string `json:"clientId"`
+	UserID        uint   `json:"userId"`
+	RedirectURI   string `json:"redirectUri"`
+	Scope         string `json:"scope"`
+	CodeChallenge string `json:"codeChallenge"`
+	Nonce         string `json:"nonce"`
+	AuthTime      int64  `json:"authTime"`
+}
+
+type OAuthServiceGORM struct {
+	ClientsRepo repositories.OAuthClientRepository
+	Repo        repositories.UserRepository
+	KeyValues   repositories.KeyValueRepository
+	Auth        AuthService
+	OIDC        OIDCService
+	CodeTTL     time.Duration
+	Log         *slog.Logger
+}
+
+func NewOAuthServiceGORM(clientsRepo repositories.OAuthClientRepository, repo repositories.UserRepository, keyValues repositories.KeyValueRepository, auth AuthService, oidc OIDCService, codeTTL time.Duration, logger *slog.Logger) OAuthService {
+	return &OAuthServiceGORM{ClientsRepo: clientsRepo, Repo: repo, KeyValues: keyValues, Auth: auth, OIDC: oidc, CodeTTL: codeTTL, Log: logger}
+}
+
+// CreateClient регистрирует клиента. Конфиденциальному клиенту выдается секрет, в БД хранится только его хеш
+func (oauthService *OAuthServiceGORM) CreateClient(clientDTO *dto.OAuthClientData) (*dto.OAuthClientInfo, error) {
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         clientDTO.Name,
+		RedirectURIs: strings.Join(clientDTO.RedirectURIs, " "),
+		Scopes:       strings.Join(clientDTO.Scopes, " "),
+		Public:       clientDTO.Public,
+		CreatedAt:    time.Now(),
+	}
+	secret := ""
+	if !client.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, err
+		}
+		secretHash := hashToken(secret)
+		client.SecretHash = &secretHash
+	}
+	if err := oauthService.ClientsRepo.Create(client); err != nil {
+		return nil, err
+	}
+	oauthService.Log.Info("OAuth client was registered", slog.String("client_id", client.ClientID), slog.Bool("public", client.Public))
+
+	info := clientInfo(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+func (oauthService *OAuthServiceGORM) GetClients() ([]dto.OAuthClientInfo, error) {
+	clients, err := oauthService.ClientsRepo.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]dto.OAuthClientInfo, 0, len(clients))
+	for i := range clients {
+		infos = append(infos, *clientInfo(&clients[i]))
+	}
+	return infos, nil
+}
+
+// Authorize выдает код авторизации аутентифицированному пользователю и возвращает адрес для перенаправления с кодом.
+// Если вместе с ошибкой вернулся адрес, он уже содержит error и state и клиенту нужно сообщить об ошибке
+// перенаправлением (RFC 6749 §4.1.2.1). Без адреса redirect_uri не прошел проверку и перенаправлять нельзя.
+// authTime - время входа пользователя, оно попадает в auth_time ID токена
+func (oauthService *OAuthServiceGORM) Authorize(userID uint, authTime time.Time, authorizeDTO *dto.OAuthAuthorizeRequest) (string, error) {
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+	redirectURI, err := oauthService.authorize(userID, authTime, authorizeDTO)
+	var oauthErr *OAuthError
+	if redirectURI != "" && errors.As(err, &oauthErr) {
+		return appendQuery(redirectURI, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}, "state": {authorizeDTO.State}}), err
+	}
+	return redirectURI, err
+}
+
+func (oauthService *OAuthServiceGORM) authorize(userID uint, authTime time.Time, authorizeDTO *dto.OAuthAuthorizeRequest) (string, error) {
+	if authorizeDTO.ClientID == "" {
+		return "", &OAuthError{Code: OAuthInvalidRequest, Description: "client_id is required"}
+	}
+	client, err := oauthService.findClient(authorizeDTO.ClientID)
+	if err != nil {
+		return "", err
+	}
+	redirectURI := authorizeDTO.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIList()) == 1 {
+		redirectURI = client.RedirectURIList()[0]
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		return "", &OAuthError{Code: OAuthInvalidRequest, Description: "redirect_uri is not registered for this client"}
+	}
+
+	if authorizeDTO.ResponseType != "code" {
+		return redirectURI, &OAuthError{Code: OAuthUnsupportedResponseType, Description: "only response_type=code is supported"}
+	}
+	scope, err := grantedScope(client, authorizeDTO.Scope)
+	if err != nil {
+		return redirectURI, err
+	}
+	// PKCE обязателен для публичных клиентов. Метод plain не принимается
+	if authorizeDTO.CodeChallenge == "" && client.Public {
+		return redirectURI, &OAuthError{Code: OAuthInvalidRequest, Description: "code_challenge is required for public clients"}
+	}
+	if authorizeDTO.CodeChallenge != "" && authorizeDTO.CodeChallengeMethod != oauthCodeChallengeS256 {
+		return redirectURI, &OAuthError{Code: OAuthInvalidRequest, Description: "code_challenge_method must be S256"}
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := oauthService.KeyValues.Set(authorizationCodeKey(code), authorizationCode{
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		CodeChallenge: authorizeDTO.CodeChallenge,
+		Nonce:         authorizeDTO.Nonce,
+		AuthTime:      authTime.Unix(),
+	}, oauthService.CodeTTL); err != nil {
+		oauthService.Log.Error(fmt.Sprintf("Failed to store authorization code. Error: %s", err.Error()), slog.String("client_id", client.ClientID))
+		return "", err
+	}
+
+	oauthService.Log.Debug("Authorization code was issued", slog.String("client_id", client.ClientID), slog.Uint64("user_id", uint64(userID)))
+	return appendQuery(redirectURI, url.Values{"code": {code}, "state": {authorizeDTO.State}}), nil
+}
+
+// Token обменивает код авторизации или refresh токен на токены (RFC 6749 §4.1.3, §6)
+func (oauthService *OAuthServiceGORM) Token(tokenDTO *dto.OAuthTokenRequest, client *dto.ClientInfo) (*dto.OAuthTokenResponse, error) {
+	oauthClient, err := oauthService.authenticateClient(tokenDTO.ClientID, tokenDTO.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tokenDTO.GrantType {
+	case oauthGrantAuthorizationCode:
+		return oauthService.exchangeCode(oauthClient, tokenDTO, client)
+	case oauthGrantRefreshToken:
+		if tokenDTO.RefreshToken == "" {
+			return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "refresh_token is required"}
+		}
+		tokens, err := oauthService.Auth.Refresh(tokenDTO.RefreshToken, oauthClient.ClientID, client)
+		if err != nil {
+			if errors.Is(err, ErrInvalidRefreshToken) {
+				return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "refresh token is invalid or expired"}
+			}
+			return nil, err
+		}
+		// Обновленные токены несут scope семейства, их и сообщаем клиенту
+		return oauthTokenResponse(tokens, tokens.Scope), nil
+	case "":
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "grant_type is required"}
+	}
+	return nil, &OAuthError{Code: OAuthUnsupportedGrantType, Description: "grant_type is not supported"}
+}
+
+func (oauthService *OAuthServiceGORM) exchangeCode(client *models.OAuthClient, tokenDTO *dto.OAuthTokenRequest, device *dto.ClientInfo) (*dto.OAuthTokenResponse, error) {
+	if tokenDTO.Code == "" {
+		return nil, &OAuthError{Code: OAuthInvalidRequest, Description: "code is required"}
+	}
+	// Код одноразовый: Take удаляет его при чтении
+	var code authorizationCode
+	if err := oauthService.KeyValues.Take(authorizationCodeKey(tokenDTO.Code), &code); err != nil {
+		if errors.Is(err, repositories.ErrKeyNotFound) {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "authorization code is invalid or expired"}
+		}
+		return nil, err
+	}
+	if code.ClientID != client.ClientID || code.RedirectURI != tokenDTO.RedirectURI {
+		return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "authorization code was issued to another client or redirect_uri"}
+	}
+	if code.CodeChallenge != "" {
+		if !codeVerifierPattern.MatchString(tokenDTO.CodeVerifier) {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "code_verifier is invalid"}
+		}
+		sum := sha256.Sum256([]byte(tokenDTO.CodeVerifier))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(code.CodeChallenge)) != 1 {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "code_verifier does not match code_challenge"}
+		}
+	}
+
+	user, err := oauthService.Repo.FindByID(code.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: "authorization code is invalid or expired"}
+		}
+		return nil, err
+	}
+	authTime := time.Unix(code.AuthTime, 0)
+	tokens, err := oauthService.Auth.LoginForClient(user, client.ClientID, code.Scope, authTime, device)
+	if err != nil {
+		if errors.Is(err, ErrEmailNotVerified) {
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: err.Error()}
+		}
+		return nil, err
+	}
+	response := oauthTokenResponse(tokens, code.Scope)
+	if slices.Contains(strings.Fields(code.Scope), ScopeOpenID) {
+		if response.IDToken, err = oauthService.OIDC.IssueIDToken(user, client.ClientID, code.Scope, code.Nonce, authTime); err != nil {
+			return nil, err
+		}
+	}
+	oauthService.Log.Debug("Authorization code was exchanged", slog.String("client_id", client.ClientID), slog.Uint64("user_id", uint64(user.ID)))
+	return response, nil
+}
+
+// authenticateClient проверяет клиента. Публичный клиент предъявляет только client_id, конфиденциальный - еще и секрет
+func (oauthService *OAuthServiceGORM) authenticateClient(clientID string, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, &OAuthError{Code: OAuthInvalidClient, Description: "client authentication failed"}
+	}
+	client, err := oauthService.findClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return client, nil
+	}
+	if client.SecretHash == nil || subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(*client.SecretHash)) != 1 {
+		return nil, &OAuthError{Code: OAuthInvalidClient, Description: "client authentication failed"}
+	}
+	return client, nil
+}
+
+func (oauthService *OAuthServiceGORM) findClient(clientID string) (*models.OAuthClient, error) {
+	client, err := oauthService.ClientsRepo.FindByClientID(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &OAuthError{Code: OAuthInvalidClient, Description: "unknown client"}
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// grantedScope проверяет запрошенные scope по списку клиента. Без scope выдаются все разрешенные клиенту
+func grantedScope(client *models.OAuthClient, requested string) (string, error) {
+	allowed := client.ScopeList()
+	if requested == "" {
+		return strings.Join(allowed, " "), nil
+	}
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return "", &OAuthError{Code: OAuthInvalidScope, Description: fmt.Sprintf("scope %q is not allowed for this client", scope)}
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+func oauthTokenResponse(tokens *dto.TokenData, scope string) *dto.OAuthTokenResponse {
+	return &dto.OAuthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		Scope:        scope,
+	}
+}
+
+func clientInfo(client *models.OAuthClient) *dto.OAuthClientInfo {
+	return &dto.OAuthClientInfo{OAuthClient: client, RedirectURIs: client.RedirectURIList(), Scopes: client.ScopeList()}
+}
+
+func authorizationCodeKey(code string) string {
+	return "oauth_code_" + hashToken(code)
+}
+
+// appendQuery добавляет параметры к redirect_uri, сохраняя его собственные параметры. Пустые значения пропускаются
+func appendQuery(redirectURI string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+	"messenger-auth/internal/utils"
+)
+
+// Scope OpenID Connect, от которых зависит набор полей пользователя
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// IDTokenClaims - содержимое ID токена (OpenID Connect Core §2)
+type IDTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	dto.OIDCUserClaims
+	jwt.RegisteredClaims
+}
+
+type OIDCServiceGORM struct {
+	Repo   repositories.UserRepository
+	Tokens TokenService
+	Issuer string
+	Log    *slog.Logger
+}
+
+func NewOIDCServiceGORM(repo repositories.UserRepository, tokens TokenService, issuer string, logger *slog.Logger) OIDCService {
+	return &OIDCServiceGORM{Repo: repo, Tokens: tokens, Issuer: strings.TrimSuffix(issuer, "/"), Log: logger}
+}
+
+// Discovery возвращает документ OpenID Provider Metadata. Адреса строятся от OIDC_ISSUER
+func (oidcService *OIDCServiceGORM) Discovery() *dto.OpenIDConfiguration {
+	return &dto.OpenIDConfiguration{
+		Issuer:                            oidcService.Issuer,
+		AuthorizationEndpoint:             oidcService.Issuer + "/oauth/authorize",
+		TokenEndpoint:                     oidcService.Issuer + "/oauth/token",
+		UserInfoEndpoint:                  oidcService.Issuer + "/userinfo",
+		JWKSURI:                           oidcService.Issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{oauthGrantAuthorizationCode, oauthGrantRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{idTokenAlgorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{oauthCodeChallengeS256},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "preferred_username", "updated_at",
+			"email", "email_verified",
+		},
+	}
+}
+
+// IssueIDToken выдает ID токен для клиента clientID. Поля пользователя включаются по выданным scope
+func (oidcService *OIDCServiceGORM) IssueIDToken(user *models.User, clientID string, scope string, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce:          nonce,
+		AuthTime:       authTime.Unix(),
+		OIDCUserClaims: userClaims(user, strings.Fields(scope)),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    oidcService.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcService.Tokens.IDTokenTTL())),
+		},
+	}
+	idToken, err := oidcService.Tokens.SignIDToken(claims)
+	if err != nil {
+		oidcService.Log.Error(fmt.Sprintf("Failed to issue id token. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)), slog.String("client_id", clientID))
+		return "", err
+	}
+	return idToken, nil
+}
+
+// UserInfo возвращает поля пользователя, разрешенные scope access токена. Токен должен быть выдан со scope openid
+func (oidcService *OIDCServiceGORM) UserInfo(userID uint, scope string) (*dto.UserInfo, error) {
+	scopes := strings.Fields(scope)
+	if !slices.Contains(scopes, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+	user, err := oidcService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &dto.UserInfo{
+		Subject:        strconv.FormatUint(uint64(user.ID), 10),
+		OIDCUserClaims: userClaims(user, scopes),
+	}, nil
+}
+
+// userClaims заполняет поля пользователя по scope: profile - имя и логин, email - почта и ее подтверждение
+func userClaims(user *models.User, scopes []string) dto.OIDCUserClaims {
+	var claims dto.OIDCUserClaims
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.Name = utils.ValueOrZero(user.Name)
+		claims.GivenName = utils.ValueOrZero(user.FirstName)
+		claims.FamilyName = utils.ValueOrZero(user.LastName)
+		claims.PreferredUsername = utils.ValueOrZero(user.Login)
+		claims.UpdatedAt = user.UpdatedAt.Unix()
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		verified := user.EmailVerifiedAt != nil
+		claims.Email = utils.ValueOrZero(user.Email)
+		claims.EmailVerified = &verified
+	}
+	return claims
+}
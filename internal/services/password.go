@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/mailer"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+	"messenger-auth/internal/utils"
+)
+
+type PasswordServiceGORM struct {
+	Repo      repositories.UserRepository
+	ResetRepo repositories.PasswordResetRepository
+	Sessions  SessionService
+	Hasher    PasswordHasher
+	Policy    PasswordPolicyService
+	Mailer    mailer.Mailer
+	ResetURL  string
+	ResetTTL  time.Duration
+	Log       *slog.Logger
+}
+
+func NewPasswordServiceGORM(repo repositories.UserRepository, resetRepo repositories.PasswordResetRepository, sessions SessionService, hasher PasswordHasher, policy PasswordPolicyService, mail mailer.Mailer, resetURL string, resetTTL time.Duration, logger *slog.Logger) PasswordService {
+	return &PasswordServiceGORM{Repo: repo, ResetRepo: resetRepo, Sessions: sessions, Hasher: hasher, Policy: policy, Mailer: mail, ResetURL: resetURL, ResetTTL: resetTTL, Log: logger}
+}
+
+// ForgotPassword отправляет на почту ссылку для сброса пароля. Для неизвестной почты ничего не делает
+// и не возвращает ошибку, чтобы по ответу нельзя было узнать, зарегистрирован ли адрес
+func (passwordService *PasswordServiceGORM) ForgotPassword(email string) error {
+	user, err := passwordService.Repo.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			passwordService.Log.Debug("Password reset was requested for unknown email")
+			return nil
+		}
+		passwordService.Log.Error(fmt.Sprintf("Failed to request password reset. Error: %s", err.Error()))
+		return err
+	}
+
+	// Токен создается и письмо отправляется в фоне: ни время ответа, ни ошибка базы или почтового сервера
+	// не должны отличать известный адрес от неизвестного
+	go passwordService.sendResetEmail(user.ID, email)
+	passwordService.Log.Info("Password reset was requested", slog.Uint64("user_id", uint64(user.ID)))
+	return nil
+}
+
+// sendResetEmail сохраняет новый токен сброса пароля и отправляет ссылку с ним. Вызывается в фоне, поэтому ошибки только журналируются
+func (passwordService *PasswordServiceGORM) sendResetEmail(userID uint, email string) {
+	link, err := passwordService.createResetLink(userID)
+	if err != nil {
+		passwordService.Log.Error(fmt.Sprintf("Failed to create password reset token. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return
+	}
+	if err := passwordService.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Someone requested a password reset for your messenger account.\n\n"+
+			"To set a new password, open this link within %s:\n%s\n\n"+
+			"If it was not you, ignore this email.\n", passwordService.ResetTTL, link),
+	}); err != nil {
+		passwordService.Log.Error(fmt.Sprintf("Failed to send password reset email. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+	}
+}
+
+// createResetLink сохраняет новый токен сброса пароля и возвращает ссылку ResetURL с ним
+func (passwordService *PasswordServiceGORM) createResetLink(userID uint) (string, error) {
+	link, err := url.Parse(passwordService.ResetURL)
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err := passwordService.ResetRepo.Create(&models.PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		ExpiresAt: now.Add(passwordService.ResetTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// ResetPassword устанавливает новый пароль по одноразовому токену, аннулирует остальные ссылки сброса
+// и завершает все сессии пользователя
+func (passwordService *PasswordServiceGORM) ResetPassword(token string, newPassword string) error {
+	stored, err := passwordService.ResetRepo.FindByHash(hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	now := time.Now()
+	if stored.UsedAt != nil || now.After(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+	user, err := passwordService.Repo.FindByID(stored.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	// Пароль проверяется до того, как токен будет использован: с отклоненным паролем ссылка остается рабочей
+	if err := passwordService.Policy.Check(newPassword, utils.ValueOrZero(user.Login), utils.ValueOrZero(user.Email)); err != nil {
+		return err
+	}
+
+	passwordHash, err := passwordService.Hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	// Токен используется, пароль меняется и остальные ссылки сброса пользователя удаляются в одной транзакции
+	reset, err := passwordService.Repo.ResetPassword(user.ID, passwordHash, stored.ID, now)
+	if err != nil {
+		return err
+	}
+	if !reset {
+		return ErrInvalidResetToken
+	}
+	if err := passwordService.Sessions.RevokeAllSessions(user.ID); err != nil {
+		return err
+	}
+	passwordService.Log.Info("Password was reset", slog.Uint64("user_id", uint64(user.ID)))
+	return nil
+}
+
+// ChangePassword меняет пароль по текущему паролю и завершает все сессии пользователя, кроме currentSessionID
+func (passwordService *PasswordServiceGORM) ChangePassword(userID uint, currentPassword string, newPassword string, currentSessionID string) error {
+	user, err := passwordService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	ok, err := verifyPassword(passwordService.Hasher, user.Password, currentPassword)
+	if err != nil {
+		passwordService.Log.Error(fmt.Sprintf("Failed to check password. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return err
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	if err := passwordService.Policy.Check(newPassword, utils.ValueOrZero(user.Login), utils.ValueOrZero(user.Email)); err != nil {
+		return err
+	}
+	passwordHash, err := passwordService.Hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = &passwordHash
+	if err := passwordService.Repo.Update(user); err != nil {
+		passwordService.Log.Error(fmt.Sprintf("Failed to change password. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return err
+	}
+	if err := passwordService.Sessions.RevokeOtherSessions(user.ID, currentSessionID); err != nil {
+		return err
+	}
+	passwordService.Log.Info("Password was changed", slog.Uint64("user_id", uint64(user.ID)))
+	return nil
+}
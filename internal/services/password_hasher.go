@@ -0,0 +1,318 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Алгоритмы хеширования паролей (PASSWORD_HASH_ALGORITHM)
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// ErrUnknownPasswordHash возвращается для хеша, формат которого не распознан
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher хеширует пароли и проверяет их. NeedsRehash сообщает, что хеш сделан устаревшим
+// алгоритмом или с устаревшими параметрами и после успешного входа его нужно пересчитать
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded string, password string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher хранит хеши в формате PHC: $argon2id$v=19$m=65536,t=3,p=2[,pepper=1]$<соль>$<хеш>.
+// Параметры хранятся в самом хеше, поэтому их можно менять: старые хеши проверяются со своими параметрами
+type Argon2idHasher struct {
+	Memory      uint32 // Память в KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+	// Pepper - секрет сервера, которым пароль подписывается перед хешированием (пусто - без pepper).
+	// Хранится отдельно от базы, поэтому утечка одной базы не позволяет перебирать пароли
+	Pepper []byte
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	peppered    bool
+	salt        []byte
+	key         []byte
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	peppered := len(hasher.Pepper) > 0
+	key := argon2.IDKey(hasher.input(password, peppered), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", hasher.Memory, hasher.Iterations, hasher.Parallelism)
+	if peppered {
+		params += ",pepper=1"
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (hasher *Argon2idHasher) Verify(encoded string, password string) (bool, error) {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	if params.peppered && len(hasher.Pepper) == 0 {
+		return false, errors.New("password hash requires a pepper, but PASSWORD_PEPPER_FILE is not set")
+	}
+	key := argon2.IDKey(hasher.input(password, params.peppered), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != hasher.Memory || params.iterations != hasher.Iterations || params.parallelism != hasher.Parallelism ||
+		len(params.salt) != int(hasher.SaltLength) || len(params.key) != int(hasher.KeyLength) || params.peppered != (len(hasher.Pepper) > 0)
+}
+
+// input подписывает пароль pepper (HMAC-SHA256), если хеш сделан с pepper
+func (hasher *Argon2idHasher) input(password string, peppered bool) []byte {
+	if !peppered {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, hasher.Pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func parseArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", параметры, соль, хеш
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return nil, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownPasswordHash
+	}
+	params := &argon2idParams{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &params.memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &params.iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &params.parallelism)
+		case "pepper":
+			params.peppered = value == "1"
+		}
+		if err != nil {
+			return nil, ErrUnknownPasswordHash
+		}
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	if params.memory == 0 || params.iterations == 0 || params.parallelism == 0 {
+		return nil, ErrUnknownPasswordHash
+	}
+	return params, nil
+}
+
+// BcryptHasher хранит хеши в формате PHC поверх bcrypt: $bcrypt$t=2a,r=12[,pepper=1]$<соль>$<хеш>, где соль и хеш -
+// части хеша bcrypt. С pepper bcrypt хеширует HMAC-SHA256 пароля в base64 (это заодно снимает ограничение bcrypt в 72 байта).
+// Хеши в собственном формате bcrypt ($2a$12$...), сохраненные раньше, проверяются как хеши без pepper
+type BcryptHasher struct {
+	Cost int
+	// Pepper - секрет сервера, как у Argon2idHasher (пусто - без pepper)
+	Pepper []byte
+}
+
+type bcryptParams struct {
+	cost     int
+	peppered bool
+	legacy   bool   // Собственный формат bcrypt
+	hash     []byte // Хеш в собственном формате bcrypt
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	peppered := len(hasher.Pepper) > 0
+	hash, err := bcrypt.GenerateFromPassword(hasher.input(password, peppered), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	// $2a$12$<22 символа соли><31 символ хеша>
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 4 || len(parts[3]) != 53 {
+		return "", fmt.Errorf("unexpected bcrypt hash format")
+	}
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		return "", err
+	}
+	params := fmt.Sprintf("t=%s,r=%d", parts[1], cost)
+	if peppered {
+		params += ",pepper=1"
+	}
+	return fmt.Sprintf("$%s$%s$%s$%s", PasswordHashBcrypt, params, parts[3][:22], parts[3][22:]), nil
+}
+
+func (hasher *BcryptHasher) Verify(encoded string, password string) (bool, error) {
+	params, err := parseBcrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	if params.peppered && len(hasher.Pepper) == 0 {
+		return false, errors.New("password hash requires a pepper, but PASSWORD_PEPPER_FILE is not set")
+	}
+	err = bcrypt.CompareHashAndPassword(params.hash, hasher.input(password, params.peppered))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (hasher *BcryptHasher) NeedsRehash(encoded string) bool {
+	params, err := parseBcrypt(encoded)
+	if err != nil {
+		return true
+	}
+	return params.legacy || params.cost != hasher.Cost || params.peppered != (len(hasher.Pepper) > 0)
+}
+
+// input подписывает пароль pepper (HMAC-SHA256) и кодирует подпись в base64, если хеш сделан с pepper
+func (hasher *BcryptHasher) input(password string, peppered bool) []byte {
+	if !peppered {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, hasher.Pepper)
+	mac.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func parseBcrypt(encoded string) (*bcryptParams, error) {
+	if !strings.HasPrefix(encoded, "$"+PasswordHashBcrypt+"$") {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return nil, ErrUnknownPasswordHash
+		}
+		return &bcryptParams{cost: cost, legacy: true, hash: []byte(encoded)}, nil
+	}
+	parts := strings.Split(encoded, "$")
+	// "", "bcrypt", параметры, соль, хеш
+	if len(parts) != 5 || len(parts[3]) != 22 || len(parts[4]) != 31 {
+		return nil, ErrUnknownPasswordHash
+	}
+	params := &bcryptParams{}
+	var version string
+	var cost int
+	for _, param := range strings.Split(parts[2], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "t":
+			version = value
+		case "r":
+			if _, err := fmt.Sscanf(value, "%d", &cost); err != nil {
+				return nil, ErrUnknownPasswordHash
+			}
+		case "pepper":
+			params.peppered = value == "1"
+		}
+	}
+	params.hash = []byte(fmt.Sprintf("$%s$%02d$%s%s", version, cost, parts[3], parts[4]))
+	var err error
+	if params.cost, err = bcrypt.Cost(params.hash); err != nil {
+		return nil, ErrUnknownPasswordHash
+	}
+	return params, nil
+}
+
+// MultiPasswordHasher хеширует новые пароли алгоритмом Algorithm и проверяет хеши всех поддерживаемых алгоритмов.
+// Хеши другого алгоритма считаются устаревшими
+type MultiPasswordHasher struct {
+	Algorithm string
+	Argon2id  *Argon2idHasher
+	Bcrypt    *BcryptHasher
+}
+
+func NewPasswordHasher(algorithm string, argon2id *Argon2idHasher, bcryptHasher *BcryptHasher) (PasswordHasher, error) {
+	if algorithm != PasswordHashArgon2id && algorithm != PasswordHashBcrypt {
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+	return &MultiPasswordHasher{Algorithm: algorithm, Argon2id: argon2id, Bcrypt: bcryptHasher}, nil
+}
+
+func (hasher *MultiPasswordHasher) Hash(password string) (string, error) {
+	return hasher.current().Hash(password)
+}
+
+func (hasher *MultiPasswordHasher) Verify(encoded string, password string) (bool, error) {
+	algorithm, err := passwordHashAlgorithm(encoded)
+	if err != nil {
+		return false, err
+	}
+	return hasher.byAlgorithm(algorithm).Verify(encoded, password)
+}
+
+func (hasher *MultiPasswordHasher) NeedsRehash(encoded string) bool {
+	algorithm, err := passwordHashAlgorithm(encoded)
+	if err != nil || algorithm != hasher.Algorithm {
+		return true
+	}
+	return hasher.current().NeedsRehash(encoded)
+}
+
+func (hasher *MultiPasswordHasher) current() PasswordHasher {
+	return hasher.byAlgorithm(hasher.Algorithm)
+}
+
+func (hasher *MultiPasswordHasher) byAlgorithm(algorithm string) PasswordHasher {
+	if algorithm == PasswordHashBcrypt {
+		return hasher.Bcrypt
+	}
+	return hasher.Argon2id
+}
+
+// verifyPassword проверяет пароль по хешу пользователя. Пустой или нераспознанный хеш не подходит ни к одному паролю
+func verifyPassword(hasher PasswordHasher, hash *string, password string) (bool, error) {
+	if hash == nil {
+		return false, nil
+	}
+	ok, err := hasher.Verify(*hash, password)
+	if errors.Is(err, ErrUnknownPasswordHash) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// passwordHashAlgorithm определяет алгоритм по префиксу хеша
+func passwordHashAlgorithm(encoded string) (string, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return PasswordHashArgon2id, nil
+	case strings.HasPrefix(encoded, "$bcrypt$"), strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return PasswordHashBcrypt, nil
+	}
+	return "", ErrUnknownPasswordHash
+}
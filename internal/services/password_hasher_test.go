@@ -0,0 +1,61 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptHasherPepper(t *testing.T) {
+	plain := &BcryptHasher{Cost: bcrypt.MinCost}
+	peppered := &BcryptHasher{Cost: bcrypt.MinCost, Pepper: []byte("pepper")}
+
+	encoded, err := peppered.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$bcrypt$t=2a,r=4,pepper=1$") {
+		t.Fatalf("hash = %q, want PHC string with the pepper flag", encoded)
+	}
+	if ok, err := peppered.Verify(encoded, "correct horse"); err != nil || !ok {
+		t.Fatalf("Verify(correct) = %v, %v, want true", ok, err)
+	}
+	if ok, err := peppered.Verify(encoded, "wrong horse"); err != nil || ok {
+		t.Fatalf("Verify(wrong) = %v, %v, want false", ok, err)
+	}
+	if ok, err := (&BcryptHasher{Cost: bcrypt.MinCost, Pepper: []byte("other")}).Verify(encoded, "correct horse"); err != nil || ok {
+		t.Fatalf("Verify with another pepper = %v, %v, want false", ok, err)
+	}
+	if _, err := plain.Verify(encoded, "correct horse"); err == nil {
+		t.Fatal("Verify without the pepper succeeded")
+	}
+	if peppered.NeedsRehash(encoded) || !plain.NeedsRehash(encoded) {
+		t.Fatal("NeedsRehash must follow the configured pepper")
+	}
+}
+
+func TestBcryptHasherLegacyHash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	hasher, err := NewPasswordHasher(PasswordHashBcrypt, &Argon2idHasher{}, &BcryptHasher{Cost: bcrypt.MinCost, Pepper: []byte("pepper")})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher: %v", err)
+	}
+
+	if ok, err := hasher.Verify(string(legacy), "correct horse"); err != nil || !ok {
+		t.Fatalf("Verify(legacy) = %v, %v, want true", ok, err)
+	}
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Fatal("legacy bcrypt hash must be rehashed into the peppered format")
+	}
+	rehashed, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := hasher.Verify(rehashed, "correct horse"); err != nil || !ok || hasher.NeedsRehash(rehashed) {
+		t.Fatalf("rehashed %q: Verify = %v, %v", rehashed, ok, err)
+	}
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"messenger-auth/internal/dto"
+)
+
+// Правила политики паролей (dto.PasswordViolation.Rule)
+const (
+	PasswordRuleMinLength  = "min_length"
+	PasswordRuleStrength   = "strength"
+	PasswordRuleBannedWord = "banned_word"
+	PasswordRuleBreached   = "breached"
+)
+
+// Слова короче не проверяются: иначе под запрет попадало бы слишком много паролей
+const minBannedWordLength = 4
+
+// Часто используемые в паролях слова, запрещенные всегда (дополняются PASSWORD_BANNED_WORDS_FILE)
+var defaultBannedWords = []string{
+	"password", "passw0rd", "qwerty", "qwertyuiop", "asdfgh", "zxcvbn", "letmein", "welcome", "admin",
+	"login", "iloveyou", "monkey", "dragon", "master", "sunshine", "princess", "football", "baseball",
+	"superman", "batman", "trustno1", "messenger", "secret", "123456", "654321", "111111", "123123",
+}
+
+// Замены символов, которыми обычно маскируют слова в паролях (p@ssw0rd)
+var leetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+type PasswordPolicyServiceLocal struct {
+	MinLength int
+	// Минимальная оценка стойкости пароля от 0 до 4 (0 - не проверять)
+	MinStrength int
+	BannedWords []string
+	// Отсортированный файл SHA-1 хешей утекших паролей (см. LoadBreachCorpus), nil - не проверять
+	Corpus *BreachCorpus
+	Log    *slog.Logger
+}
+
+func NewPasswordPolicyServiceLocal(minLength int, minStrength int, bannedWords []string, corpus *BreachCorpus, logger *slog.Logger) PasswordPolicyService {
+	words := make([]string, 0, len(defaultBannedWords)+len(bannedWords))
+	for _, word := range append(defaultBannedWords, bannedWords...) {
+		if word = strings.ToLower(strings.TrimSpace(word)); len(word) >= minBannedWordLength {
+			words = append(words, word)
+		}
+	}
+	return &PasswordPolicyServiceLocal{MinLength: minLength, MinStrength: minStrength, BannedWords: words, Corpus: corpus, Log: logger}
+}
+
+// Check проверяет пароль пользователя с логином login и почтой email по всем правилам.
+// Нарушения возвращаются все сразу в *PasswordPolicyError
+func (policyService *PasswordPolicyServiceLocal) Check(password string, login string, email string) error {
+	var violations []dto.PasswordViolation
+	if utf8.RuneCountInString(password) < policyService.MinLength {
+		violations = append(violations, dto.PasswordViolation{Rule: PasswordRuleMinLength, Message: fmt.Sprintf("password must be at least %d characters long", policyService.MinLength)})
+	}
+	if policyService.MinStrength > 0 && passwordStrength(password) < policyService.MinStrength {
+		violations = append(violations, dto.PasswordViolation{Rule: PasswordRuleStrength, Message: "password is too easy to guess, use a longer password with different kinds of characters"})
+	}
+	if word := policyService.bannedWord(password, login, email); word != "" {
+		violations = append(violations, dto.PasswordViolation{Rule: PasswordRuleBannedWord, Message: fmt.Sprintf("password must not contain %q", word)})
+	}
+	if policyService.Corpus != nil {
+		breached, err := policyService.Corpus.Contains(password)
+		if err != nil {
+			policyService.Log.Error(fmt.Sprintf("Failed to check breached passwords. Error: %s", err.Error()))
+			return err
+		}
+		if breached {
+			violations = append(violations, dto.PasswordViolation{Rule: PasswordRuleBreached, Message: "password has appeared in a data breach, choose a different one"})
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// bannedWord возвращает запрещенное слово, которое содержит пароль. Кроме словаря запрещены логин, почта и ее имя до @
+func (policyService *PasswordPolicyServiceLocal) bannedWord(password string, login string, email string) string {
+	lower := strings.ToLower(password)
+	normalized := leetReplacer.Replace(lower)
+	personal := []string{strings.ToLower(login), strings.ToLower(email)}
+	if local, _, found := strings.Cut(strings.ToLower(email), "@"); found {
+		personal = append(personal, local)
+	}
+	// Логин может быть короче minBannedWordLength (от 3 символов), но все равно запрещен
+	for _, word := range personal {
+		if len(word) >= 3 && (strings.Contains(lower, word) || strings.Contains(normalized, word)) {
+			return word
+		}
+	}
+	for _, word := range policyService.BannedWords {
+		if strings.Contains(lower, word) || strings.Contains(normalized, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// LoadBannedWords читает запрещенные слова из файла по одному в строке. Пустые строки и строки с # пропускаются
+func LoadBannedWords(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, nil
+}
+
+// passwordStrength оценивает стойкость пароля от 0 до 4 по энтропии: длина с учетом повторов и последовательностей
+// (aaaa, abcd, 4321), умноженная на log2 размера алфавита из использованных классов символов
+func passwordStrength(password string) int {
+	var lower, upper, digit, symbol, other bool
+	effectiveLength := 0
+	var prev rune
+	for i, r := range []rune(password) {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+		// Повтор и следующий по порядку символ почти не добавляют стойкости
+		if i == 0 || (r != prev && r != prev+1 && r != prev-1) {
+			effectiveLength++
+		}
+		prev = r
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	bits := float64(effectiveLength) * math.Log2(float64(pool))
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	}
+	return 4
+}
+
+// BreachCorpus - локальная база утекших паролей в формате Pwned Passwords: строки "SHA1:COUNT",
+// отсортированные по хешу (в верхнем регистре). Файл не загружается в память: как в k-anonymity API,
+// двоичным поиском находится диапазон хешей с тем же префиксом из 5 символов, и в нем ищется остаток хеша
+type BreachCorpus struct {
+	file *os.File
+	size int64
+	// Минимальное число утечек, с которого пароль считается скомпрометированным
+	MinCount int64
+}
+
+// Длина префикса хеша, по которому выбирается диапазон (как в k-anonymity API Pwned Passwords)
+const breachPrefixLength = 5
+
+func LoadBreachCorpus(path string, minCount int64) (*BreachCorpus, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &BreachCorpus{file: file, size: info.Size(), MinCount: minCount}, nil
+}
+
+// Contains сообщает, встречается ли пароль в базе не меньше MinCount раз
+func (corpus *BreachCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+
+	start, err := corpus.rangeStart(prefix)
+	if err != nil {
+		return false, err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(corpus.file, start, corpus.size-start))
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			if !strings.HasPrefix(line, prefix) {
+				return false, nil
+			}
+			lineSuffix, count, _ := strings.Cut(line[breachPrefixLength:], ":")
+			if strings.EqualFold(lineSuffix, suffix) {
+				return breachCount(count) >= corpus.MinCount, nil
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// rangeStart двоичным поиском по байтовым смещениям находит начало первой строки, хеш которой не меньше prefix
+func (corpus *BreachCorpus) rangeStart(prefix string) (int64, error) {
+	low, high := int64(0), corpus.size
+	for low < high {
+		middle := low + (high-low)/2
+		lineStart, err := corpus.lineStartAfter(middle)
+		if err != nil {
+			return 0, err
+		}
+		if lineStart >= corpus.size {
+			high = middle
+			continue
+		}
+		line, err := corpus.readAt(lineStart)
+		if err != nil {
+			return 0, err
+		}
+		if strings.ToUpper(string(line)) >= prefix {
+			high = middle
+		} else {
+			low = middle + 1
+		}
+	}
+	return corpus.lineStartAfter(low)
+}
+
+// lineStartAfter возвращает начало первой строки, которая начинается не раньше offset
+func (corpus *BreachCorpus) lineStartAfter(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	position := offset - 1
+	buffer := make([]byte, 128)
+	for position < corpus.size {
+		n, err := corpus.file.ReadAt(buffer, position)
+		if i := bytes.IndexByte(buffer[:n], '\n'); i >= 0 {
+			return position + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			return corpus.size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		position += int64(n)
+	}
+	return corpus.size, nil
+}
+
+// readAt читает начало строки: для сравнения с префиксом достаточно хеша
+func (corpus *BreachCorpus) readAt(offset int64) ([]byte, error) {
+	buffer := make([]byte, 2*sha1.Size)
+	n, err := corpus.file.ReadAt(buffer, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// breachCount разбирает число утечек. Строка без числа (просто хеш) считается одной утечкой
+func breachCount(count string) int64 {
+	value, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+	if err != nil {
+		return 1
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"messenger-auth/config"
+	"messenger-auth/internal/repositories"
+)
+
+// Виды клиентов, для которых задаются отдельные ограничения частоты запросов
+const (
+	PrincipalAnonymous = "anonymous" // Запрос без access токена, ограничивается по IP
+	PrincipalUser      = "user"      // Пользователь с access токеном
+	PrincipalService   = "service"   // Другой сервис мессенджера (gRPC)
+)
+
+// Сколько не обращаться к Redis после ошибки: иначе каждый запрос ждал бы таймаута подключения
+const rateLimitRedisRetryInterval = 5 * time.Second
+
+// RateLimitPrincipal - клиент, которому принадлежит bucket
+type RateLimitPrincipal struct {
+	Kind string
+	ID   string
+}
+
+type RateLimitServiceRedis struct {
+	Repo repositories.RateLimitRepository
+	// Ограничения в памяти процесса на время недоступности Redis
+	Fallback repositories.RateLimitRepository
+	// Ограничения по умолчанию для каждого вида клиента, общие для всех маршрутов без своих ограничений
+	Defaults map[string]config.RateLimit
+	// Ограничения отдельных маршрутов, у каждого маршрута свой bucket
+	Routes map[string]config.RouteRateLimits
+	Log    *slog.Logger
+
+	mu           sync.Mutex
+	redisRetryAt time.Time
+}
+
+func NewRateLimitServiceRedis(repo repositories.RateLimitRepository, fallback repositories.RateLimitRepository, defaults map[string]config.RateLimit, routes map[string]config.RouteRateLimits, logger *slog.Logger) RateLimitService {
+	return &RateLimitServiceRedis{Repo: repo, Fallback: fallback, Defaults: defaults, Routes: routes, Log: logger}
+}
+
+// Allow забирает токен из bucket клиента для маршрута route (HTTP маршрут или метод gRPC).
+// При исчерпании ограничения возвращает *TooManyRequestsError
+func (rateLimitService *RateLimitServiceRedis) Allow(route string, principal RateLimitPrincipal) error {
+	limit, ok := rateLimitService.Routes[route][principal.Kind]
+	bucketRoute := route
+	if !ok {
+		limit = rateLimitService.Defaults[principal.Kind]
+		bucketRoute = "*"
+	}
+	if limit.Requests <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Requests
+	}
+	perSecond := float64(limit.Requests) / time.Duration(limit.Period).Seconds()
+	key := "rate_limit_" + principal.Kind + "_" + principal.ID + "_" + bucketRoute
+
+	allowed, wait, err := rateLimitService.take(key, perSecond, burst)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &TooManyRequestsError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// take обращается к Redis, а при его недоступности - к ограничениям в памяти
+func (rateLimitService *RateLimitServiceRedis) take(key string, perSecond float64, burst int64) (bool, time.Duration, error) {
+	rateLimitService.mu.Lock()
+	useRedis := time.Now().After(rateLimitService.redisRetryAt)
+	rateLimitService.mu.Unlock()
+
+	if useRedis {
+		allowed, wait, err := rateLimitService.Repo.Take(key, perSecond, burst)
+		if err == nil {
+			return allowed, wait, nil
+		}
+		rateLimitService.mu.Lock()
+		rateLimitService.redisRetryAt = time.Now().Add(rateLimitRedisRetryInterval)
+		rateLimitService.mu.Unlock()
+		rateLimitService.Log.Warn(fmt.Sprintf("Failed to use Redis rate limiter, falling back to in-memory limits. Error: %s", err.Error()))
+	}
+	return rateLimitService.Fallback.Take(key, perSecond, burst)
+}
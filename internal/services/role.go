@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+// Роли и права меняются только миграциями, поэтому держим их в памяти и перечитываем раз в roleCacheTTL
+const roleCacheTTL = time.Minute
+
+type RoleServiceGORM struct {
+	Repo            repositories.RoleRepository
+	UserRepo        repositories.UserRepository
+	DefaultRoleName string
+	Log             *slog.Logger
+
+	mu       sync.RWMutex
+	roles    map[uint]*models.Role
+	loadedAt time.Time
+}
+
+func NewRoleServiceGORM(repo repositories.RoleRepository, userRepo repositories.UserRepository, defaultRoleName string, logger *slog.Logger) RoleService {
+	return &RoleServiceGORM{Repo: repo, UserRepo: userRepo, DefaultRoleName: defaultRoleName, Log: logger}
+}
+
+func (roleService *RoleServiceGORM) GetRoles() ([]models.Role, error) {
+	roles, err := roleService.Repo.FindAll()
+	if err != nil {
+		roleService.Log.Error(fmt.Sprintf("Failed to get roles. Error: %s", err.Error()))
+		return nil, err
+	}
+	return roles, nil
+}
+
+// DefaultRole возвращает роль, назначаемую при регистрации (DEFAULT_ROLE)
+func (roleService *RoleServiceGORM) DefaultRole() (*models.Role, error) {
+	return roleService.GetRoleByName(roleService.DefaultRoleName)
+}
+
+func (roleService *RoleServiceGORM) HasPermission(roleID uint, permission string) (bool, error) {
+	roles, err := roleService.cachedRoles()
+	if err != nil {
+		return false, err
+	}
+	role, ok := roles[roleID]
+	return ok && role.HasPermission(permission), nil
+}
+
+func (roleService *RoleServiceGORM) AssignRole(userID uint, roleID uint) error {
+	roles, err := roleService.cachedRoles()
+	if err != nil {
+		return err
+	}
+	if _, ok := roles[roleID]; !ok {
+		return ErrRoleNotFound
+	}
+	return roleService.setUserRole(userID, roleID)
+}
+
+// RevokeRole отзывает у пользователя роль roleID, после чего у него остается роль по умолчанию
+func (roleService *RoleServiceGORM) RevokeRole(userID uint, roleID uint) error {
+	defaultRole, err := roleService.DefaultRole()
+	if err != nil {
+		return err
+	}
+	if roleID == defaultRole.ID {
+		return ErrDefaultRoleRevoke
+	}
+
+	user, err := roleService.UserRepo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if user.ServiceRoleID != roleID {
+		return ErrRoleNotAssigned
+	}
+	return roleService.setUserRole(userID, defaultRole.ID)
+}
+
+func (roleService *RoleServiceGORM) setUserRole(userID uint, roleID uint) error {
+	user, err := roleService.UserRepo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	user.ServiceRoleID = roleID
+	if err := roleService.UserRepo.Update(user); err != nil {
+		roleService.Log.Error(fmt.Sprintf("Failed to set user role. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)), slog.Uint64("role_id", uint64(roleID)))
+		return err
+	}
+	roleService.Log.Info("User role was changed", slog.Uint64("user_id", uint64(userID)), slog.Uint64("role_id", uint64(roleID)))
+	return nil
+}
+
+func (roleService *RoleServiceGORM) GetRoleByName(name string) (*models.Role, error) {
+	roles, err := roleService.cachedRoles()
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRoleNotFound, name)
+}
+
+func (roleService *RoleServiceGORM) cachedRoles() (map[uint]*models.Role, error) {
+	roleService.mu.RLock()
+	roles, loadedAt := roleService.roles, roleService.loadedAt
+	roleService.mu.RUnlock()
+	if roles != nil && time.Since(loadedAt) < roleCacheTTL {
+		return roles, nil
+	}
+
+	list, err := roleService.Repo.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	roles = make(map[uint]*models.Role, len(list))
+	for i := range list {
+		roles[list[i].ID] = &list[i]
+	}
+
+	roleService.mu.Lock()
+	roleService.roles = roles
+	roleService.loadedAt = time.Now()
+	roleService.mu.Unlock()
+	return roles, nil
+}
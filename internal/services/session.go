@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+// Ограничения длины полей models.Session
+const (
+	maxDeviceNameLength = 255
+	maxUserAgentLength  = 512
+)
+
+type SessionServiceGORM struct {
+	Repo        repositories.SessionRepository
+	RefreshRepo repositories.RefreshTokenRepository
+	Denylist    repositories.TokenDenylistRepository
+	// Время жизни access токена: столько хранятся записи об отозванных токенах
+	AccessTokenTTL time.Duration
+	// Максимальное число одновременных сессий пользователя (0 - без ограничения)
+	MaxSessions int64
+	Log         *slog.Logger
+}
+
+func NewSessionServiceGORM(repo repositories.SessionRepository, refreshRepo repositories.RefreshTokenRepository, denylist repositories.TokenDenylistRepository, accessTokenTTL time.Duration, maxSessions int64, logger *slog.Logger) SessionService {
+	return &SessionServiceGORM{Repo: repo, RefreshRepo: refreshRepo, Denylist: denylist, AccessTokenTTL: accessTokenTTL, MaxSessions: maxSessions, Log: logger}
+}
+
+// StartSession создает сессию для нового семейства refresh токенов. Если сессий больше MaxSessions,
+// завершаются самые старые
+func (sessionService *SessionServiceGORM) StartSession(userID uint, familyID string, client *dto.ClientInfo, expiresAt time.Time) error {
+	now := time.Now()
+	if err := sessionService.Repo.Create(newSession(userID, familyID, client, now, expiresAt)); err != nil {
+		return err
+	}
+	if sessionService.MaxSessions <= 0 {
+		return nil
+	}
+
+	sessions, err := sessionService.Repo.FindActiveByUser(userID, now)
+	if err != nil {
+		return err
+	}
+	// Сессии отсортированы от старых к новым, новая сессия последняя и не вытесняется
+	for i := 0; int64(len(sessions)-i) > sessionService.MaxSessions; i++ {
+		if err := sessionService.EndSession(sessions[i].FamilyID); err != nil {
+			return err
+		}
+		sessionService.Log.Info("Oldest session was evicted", slog.Uint64("user_id", uint64(userID)), slog.Uint64("session_id", uint64(sessions[i].ID)))
+	}
+	return nil
+}
+
+// TouchSession отмечает обновление токенов сессии. У семейств, выданных до появления сессий, сессия создается
+func (sessionService *SessionServiceGORM) TouchSession(userID uint, familyID string, client *dto.ClientInfo, expiresAt time.Time) error {
+	now := time.Now()
+	session, err := sessionService.Repo.FindByFamily(familyID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return sessionService.Repo.Create(newSession(userID, familyID, client, now, expiresAt))
+	}
+	if err != nil {
+		sessionService.Log.Error(fmt.Sprintf("Failed to find session. Error: %s", err.Error()), slog.String("family_id", familyID))
+		return err
+	}
+	return sessionService.Repo.Touch(session.ID, truncate(client.UserAgent, maxUserAgentLength), client.IP, now, expiresAt)
+}
+
+// EndSession завершает сессию, отзывает refresh токены ее семейства и уже выданные в ней access токены
+func (sessionService *SessionServiceGORM) EndSession(familyID string) error {
+	now := time.Now()
+	if err := sessionService.RefreshRepo.RevokeFamily(familyID, now); err != nil {
+		return err
+	}
+	if err := sessionService.Repo.RevokeFamily(familyID, now); err != nil {
+		return err
+	}
+	return sessionService.Denylist.DenySession(familyID, sessionService.AccessTokenTTL)
+}
+
+// GetSessions возвращает активные сессии пользователя, начиная с последней использованной.
+// currentFamilyID - семейство токенов запроса, его сессия помечается как текущая
+func (sessionService *SessionServiceGORM) GetSessions(userID uint, currentFamilyID string) ([]dto.SessionInfo, error) {
+	sessions, err := sessionService.Repo.FindActiveByUser(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dto.SessionInfo, 0, len(sessions))
+	for i := len(sessions) - 1; i >= 0; i-- {
+		session := sessions[i]
+		result = append(result, dto.SessionInfo{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    currentFamilyID != "" && session.FamilyID == currentFamilyID,
+		})
+	}
+	return result, nil
+}
+
+// RevokeSession завершает сессию sessionID пользователя userID. Чужие сессии не отличаются от несуществующих
+func (sessionService *SessionServiceGORM) RevokeSession(userID uint, sessionID uint) error {
+	session, err := sessionService.Repo.FindByID(sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if session.UserID != userID || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+	if err := sessionService.EndSession(session.FamilyID); err != nil {
+		return err
+	}
+	sessionService.Log.Info("Session was revoked", slog.Uint64("user_id", uint64(userID)), slog.Uint64("session_id", uint64(sessionID)))
+	return nil
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме сессии семейства currentFamilyID
+func (sessionService *SessionServiceGORM) RevokeOtherSessions(userID uint, currentFamilyID string) error {
+	now := time.Now()
+	sessions, err := sessionService.Repo.FindActiveByUser(userID, now)
+	if err != nil {
+		return err
+	}
+	if err := sessionService.RefreshRepo.RevokeAllForUser(userID, currentFamilyID, now); err != nil {
+		return err
+	}
+	if err := sessionService.Repo.RevokeAllForUser(userID, currentFamilyID, now); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.FamilyID == currentFamilyID {
+			continue
+		}
+		if err := sessionService.Denylist.DenySession(session.FamilyID, sessionService.AccessTokenTTL); err != nil {
+			return err
+		}
+	}
+	sessionService.Log.Info("Other sessions were revoked", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+// RevokeAllSessions завершает все сессии пользователя и отзывает все выданные ему access токены
+// (сброс пароля, удаление пользователя). Токены открытых сессий отзываются по sid, а токены, выданные
+// в прошлые секунды, - по времени not before. Новые сессии, начатые после отзыва, не затрагиваются
+func (sessionService *SessionServiceGORM) RevokeAllSessions(userID uint) error {
+	now := time.Now()
+	sessions, err := sessionService.Repo.FindActiveByUser(userID, now)
+	if err != nil {
+		return err
+	}
+	if err := sessionService.RefreshRepo.RevokeAllForUser(userID, "", now); err != nil {
+		return err
+	}
+	if err := sessionService.Repo.RevokeAllForUser(userID, "", now); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := sessionService.Denylist.DenySession(session.FamilyID, sessionService.AccessTokenTTL); err != nil {
+			return err
+		}
+	}
+	if err := sessionService.Denylist.SetUserNotBefore(userID, now, sessionService.AccessTokenTTL); err != nil {
+		return err
+	}
+	sessionService.Log.Info("All sessions were revoked", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+func newSession(userID uint, familyID string, client *dto.ClientInfo, now time.Time, expiresAt time.Time) *models.Session {
+	deviceName := client.DeviceName
+	if deviceName == "" {
+		deviceName = describeUserAgent(client.UserAgent)
+	}
+	return &models.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		DeviceName: truncate(deviceName, maxDeviceNameLength),
+		UserAgent:  truncate(client.UserAgent, maxUserAgentLength),
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  expiresAt,
+	}
+}
+
+// describeUserAgent строит название устройства вида "Chrome on Windows" по User-Agent.
+// Порядок проверок важен: User-Agent Edge и Opera содержат Chrome, а Chrome содержит Safari
+func describeUserAgent(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	}
+	var browser, system string
+	for _, candidate := range browsers {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	for _, candidate := range systems {
+		if strings.Contains(userAgent, candidate.token) {
+			system = candidate.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	// Не браузер (мобильное приложение, curl и т.д.): название приложения - первое слово User-Agent
+	name, _, _ := strings.Cut(userAgent, " ")
+	name, _, _ = strings.Cut(name, "/")
+	return name
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return strings.ToValidUTF8(value[:length], "")
+}
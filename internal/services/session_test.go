@@ -0,0 +1,41 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"messenger-auth/internal/models"
+)
+
+func TestRevokeAllSessionsDeniesOpenSessions(t *testing.T) {
+	now := time.Now()
+	sessions := &fakeSessionRepo{sessions: []models.Session{
+		{UserID: 1, FamilyID: "phone", ExpiresAt: now.Add(time.Hour)},
+		{UserID: 1, FamilyID: "laptop", ExpiresAt: now.Add(time.Hour)},
+		{UserID: 2, FamilyID: "other-user", ExpiresAt: now.Add(time.Hour)},
+	}}
+	denylist := &fakeDenylist{}
+	service := NewSessionServiceGORM(sessions, &fakeRefreshRepo{}, denylist, time.Minute, 0, discardLogger())
+
+	started := time.Now()
+	if err := service.RevokeAllSessions(1); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	// Отзыв не ждет смены секунды: токены входа сразу после него отличаются по sid, а не по iat
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Fatalf("RevokeAllSessions took %s", elapsed)
+	}
+	slices.Sort(denylist.sessions)
+	if !slices.Equal(denylist.sessions, []string{"laptop", "phone"}) {
+		t.Fatalf("denied sessions = %v, want both sessions of the user", denylist.sessions)
+	}
+	if _, ok := denylist.notBefore[1]; !ok {
+		t.Fatal("not before time was not set")
+	}
+	for _, session := range sessions.sessions {
+		if revoked := session.RevokedAt != nil; revoked != (session.UserID == 1) {
+			t.Fatalf("session %s: revoked = %v", session.FamilyID, revoked)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+const (
+	// Access токены подписываются EdDSA. ID токены OIDC подписываются RS256, который обязаны поддерживать все клиенты OIDC
+	accessTokenAlgorithm = "EdDSA"
+	idTokenAlgorithm     = "RS256"
+	rsaKeyBits           = 2048
+	// Как часто проверять, не пора ли сменить ключ подписи, и подтягивать ключи, созданные другими репликами
+	keyCheckInterval = time.Minute
+	// Минимальный интервал между перечитываниями ключей из БД при встрече неизвестного kid
+	keyReloadCooldown = 10 * time.Second
+	// JWKSMaxAge - сколько клиенты кэшируют JWKS (Cache-Control: max-age)
+	JWKSMaxAge = 5 * time.Minute
+	// За сколько до начала подписи новый ключ публикуется в JWKS. Больше JWKSMaxAge на интервал проверки ключей,
+	// чтобы к моменту переключения закэшированный клиентами JWKS гарантированно содержал новый ключ
+	keyPrepublishPeriod = JWKSMaxAge + keyCheckInterval
+)
+
+// Алгоритмы, для каждого из которых поддерживается свой действующий ключ подписи
+var signingAlgorithms = []string{accessTokenAlgorithm, idTokenAlgorithm}
+
+// AccessClaims - содержимое access токена
+type AccessClaims struct {
+	Role      uint   `json:"role"`
+	ClientID  string `json:"client_id,omitempty"` // Клиент OAuth, которому выдан токен (пусто у токенов первой стороны)
+	Scope     string `json:"scope,omitempty"`     // Scope, выданные клиенту OAuth (пусто у токенов первой стороны)
+	AuthTime  int64  `json:"auth_time,omitempty"` // Время входа пользователя (Unix), сохраняется при обновлении токенов
+	SessionID string `json:"sid,omitempty"`       // Сессия (семейство refresh токенов), в которой выдан токен
+	jwt.RegisteredClaims
+}
+
+// ThirdParty сообщает, что токен выдан клиенту OAuth. Такой токен принимается только эндпоинтами,
+// которые проверяют scope (/userinfo), но не API первой стороны
+func (c *AccessClaims) ThirdParty() bool {
+	return c.ClientID != "" || c.Scope != ""
+}
+
+// UserID возвращает идентификатор пользователя из поля sub
+func (c *AccessClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid subject: %w", err)
+	}
+	return uint(id), nil
+}
+
+type signingKey struct {
+	kid         string
+	algorithm   string
+	private     crypto.Signer
+	public      crypto.PublicKey
+	activatesAt *time.Time
+	retiredAt   *time.Time
+}
+
+type TokenServiceJWT struct {
+	Repo             repositories.SigningKeyRepository
+	Log              *slog.Logger
+	Issuer           string
+	AccessTokenTTL   time.Duration
+	RotationInterval time.Duration
+
+	mu         sync.RWMutex
+	current    map[string]*signingKey // Действующий ключ для каждого алгоритма
+	keys       map[string]*signingKey
+	lastReload time.Time
+}
+
+func NewTokenServiceJWT(repo repositories.SigningKeyRepository, issuer string, accessTokenTTL, rotationInterval time.Duration, logger *slog.Logger) TokenService {
+	return &TokenServiceJWT{
+		Repo:             repo,
+		Log:              logger,
+		Issuer:           issuer,
+		AccessTokenTTL:   accessTokenTTL,
+		RotationInterval: rotationInterval,
+		current:          map[string]*signingKey{},
+		keys:             map[string]*signingKey{},
+	}
+}
+
+func (s *TokenServiceJWT) IssueAccessToken(userID uint, role uint, clientID string, scope string, authTime time.Time, sessionID string) (string, time.Time, error) {
+	// jti нужен, чтобы отозвать отдельный токен до истечения его срока (см. TokenDenylistRepository)
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(s.AccessTokenTTL)
+	claims := AccessClaims{
+		Role:      role,
+		ClientID:  clientID,
+		Scope:     scope,
+		AuthTime:  authTime.Unix(),
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.Issuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	signed, err := s.sign(accessTokenAlgorithm, jwt.SigningMethodEdDSA, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// SignIDToken подписывает ID токен OIDC. Заполнение полей (iss, aud, exp и т.д.) - задача вызывающего
+func (s *TokenServiceJWT) SignIDToken(claims jwt.Claims) (string, error) {
+	return s.sign(idTokenAlgorithm, jwt.SigningMethodRS256, claims)
+}
+
+// IDTokenTTL - время жизни ID токена, совпадает с временем жизни access токена
+func (s *TokenServiceJWT) IDTokenTTL() time.Duration {
+	return s.AccessTokenTTL
+}
+
+func (s *TokenServiceJWT) sign(algorithm string, method jwt.SigningMethod, claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.current[algorithm]
+	s.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("no active %s signing key", algorithm)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		s.Log.Error(fmt.Sprintf("Failed to sign token. Error: %s", err.Error()), slog.String("kid", key.kid))
+		return "", err
+	}
+	return signed, nil
+}
+
+func (s *TokenServiceJWT) ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, s.keyFunc,
+		jwt.WithValidMethods([]string{accessTokenAlgorithm}),
+		jwt.WithIssuer(s.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTokenMalformed, err.Error())
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTokenMalformed, err.Error())
+	}
+	return &claims, nil
+}
+
+func (s *TokenServiceJWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+	// Ключ должен быть того же алгоритма, что и токен: ID токен нельзя предъявить вместо access токена
+	if key := s.findKey(kid); key != nil && key.algorithm == token.Method.Alg() {
+		return key.public, nil
+	}
+
+	// Ключ мог быть создан другой репликой, перечитываем ключи из БД (не чаще keyReloadCooldown)
+	s.mu.RLock()
+	canReload := time.Since(s.lastReload) > keyReloadCooldown
+	s.mu.RUnlock()
+	if canReload {
+		if err := s.reloadKeys(); err != nil {
+			return nil, err
+		}
+		if key := s.findKey(kid); key != nil && key.algorithm == token.Method.Alg() {
+			return key.public, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (s *TokenServiceJWT) findKey(kid string) *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[kid]
+}
+
+// PublicKeys возвращает все опубликованные ключи: действующий и выведенные из оборота, токены которых еще не истекли
+func (s *TokenServiceJWT) PublicKeys() []dto.JWK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := make([]dto.JWK, 0, len(s.keys))
+	for _, key := range s.keys {
+		jwk := dto.JWK{KeyID: key.kid, Use: "sig", Algorithm: key.algorithm}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// RotateKeys поддерживает ключи подписи для каждого алгоритма. Если ключей нет совсем, новый ключ подписывает сразу.
+// Если действующий ключ старше RotationInterval, создается следующий ключ, который публикуется в JWKS за
+// keyPrepublishPeriod до начала подписи. Когда следующий ключ вступает в действие, предыдущие выводятся из оборота.
+// Ключи, которыми уже не может быть подписан ни один действующий токен, удаляются
+func (s *TokenServiceJWT) RotateKeys() error {
+	now := time.Now()
+	keys, err := s.Repo.FindPublished(now.Add(-s.AccessTokenTTL))
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range signingAlgorithms {
+		// Записи отсортированы от новых к старым
+		var active, pending *models.SigningKey
+		for i := range keys {
+			if keys[i].RetiredAt != nil || keys[i].Algorithm != algorithm {
+				continue
+			}
+			if keys[i].ActivatesAt != nil && keys[i].ActivatesAt.After(now) {
+				if pending == nil {
+					pending = &keys[i]
+				}
+			} else if active == nil {
+				active = &keys[i]
+			}
+		}
+
+		if active != nil {
+			// Следующий ключ вступил в действие: выводим из оборота ключи, созданные раньше него
+			if err := s.Repo.RetireCreatedBefore(algorithm, active.CreatedAt, now); err != nil {
+				return err
+			}
+		}
+		if pending != nil || (active != nil && now.Sub(keyActivation(active)) < s.RotationInterval) {
+			continue
+		}
+
+		key, err := s.generateKey(algorithm, now)
+		if err != nil {
+			return err
+		}
+		// Самый первый ключ алгоритма подписывает сразу, иначе проверяющие еще не видели бы никакого ключа
+		if active != nil {
+			activatesAt := now.Add(keyPrepublishPeriod)
+			key.ActivatesAt = &activatesAt
+		}
+		if err := s.Repo.Create(key); err != nil {
+			return err
+		}
+		s.Log.Info("Signing key was published", slog.String("kid", key.KID), slog.String("alg", algorithm), slog.Time("activatesAt", keyActivation(key)))
+	}
+
+	if err := s.Repo.DeleteRetiredBefore(now.Add(-s.AccessTokenTTL)); err != nil {
+		return err
+	}
+	return s.reloadKeys()
+}
+
+// keyActivation возвращает момент, с которого ключ подписывает токены
+func keyActivation(key *models.SigningKey) time.Time {
+	if key.ActivatesAt != nil {
+		return *key.ActivatesAt
+	}
+	return key.CreatedAt
+}
+
+// StartKeyRotation периодически вызывает RotateKeys до отмены ctx
+func (s *TokenServiceJWT) StartKeyRotation(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(keyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.RotateKeys(); err != nil {
+					s.Log.Error(fmt.Sprintf("Failed to rotate signing keys. Error: %s", err.Error()))
+				}
+			}
+		}
+	}()
+}
+
+func (s *TokenServiceJWT) generateKey(algorithm string, now time.Time) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case accessTokenAlgorithm:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case idTokenAlgorithm:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		KID:        kid,
+		Algorithm:  algorithm,
+		PrivateKey: der,
+		CreatedAt:  now,
+	}, nil
+}
+
+func (s *TokenServiceJWT) reloadKeys() error {
+	now := time.Now()
+	records, err := s.Repo.FindPublished(now.Add(-s.AccessTokenTTL))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*signingKey, len(records))
+	current := make(map[string]*signingKey, len(signingAlgorithms))
+	for _, record := range records {
+		parsed, err := x509.ParsePKCS8PrivateKey(record.PrivateKey)
+		if err != nil {
+			s.Log.Error(fmt.Sprintf("Failed to parse signing key. Error: %s", err.Error()), slog.String("kid", record.KID))
+			continue
+		}
+		var private crypto.Signer
+		switch parsed := parsed.(type) {
+		case ed25519.PrivateKey:
+			if record.Algorithm == accessTokenAlgorithm {
+				private = parsed
+			}
+		case *rsa.PrivateKey:
+			if record.Algorithm == idTokenAlgorithm {
+				private = parsed
+			}
+		}
+		if private == nil {
+			s.Log.Error("Failed to parse signing key. Error: unexpected key type", slog.String("kid", record.KID), slog.String("alg", record.Algorithm))
+			continue
+		}
+		key := &signingKey{
+			kid:         record.KID,
+			algorithm:   record.Algorithm,
+			private:     private,
+			public:      private.Public(),
+			activatesAt: record.ActivatesAt,
+			retiredAt:   record.RetiredAt,
+		}
+		keys[key.kid] = key
+		// Записи отсортированы от новых к старым, подписываем самым новым действующим ключом алгоритма.
+		// Опубликованный заранее ключ, время которого еще не наступило, только проверяет подписи
+		if current[key.algorithm] == nil && key.retiredAt == nil && (key.activatesAt == nil || !key.activatesAt.After(now)) {
+			current[key.algorithm] = key
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.current = current
+	s.lastReload = now
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+	"messenger-auth/internal/utils"
+)
+
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+type TwoFactorServiceGORM struct {
+	Repo          repositories.UserRepository
+	RecoveryRepo  repositories.RecoveryCodeRepository
+	EncryptionKey []byte
+	Issuer        string
+	Log           *slog.Logger
+}
+
+func NewTwoFactorServiceGORM(repo repositories.UserRepository, recoveryRepo repositories.RecoveryCodeRepository, encryptionKey []byte, issuer string, logger *slog.Logger) TwoFactorService {
+	return &TwoFactorServiceGORM{Repo: repo, RecoveryRepo: recoveryRepo, EncryptionKey: encryptionKey, Issuer: issuer, Log: logger}
+}
+
+// Enroll создает новый секрет TOTP. Двухфакторная аутентификация включается только после Confirm
+func (twoFactorService *TwoFactorServiceGORM) Enroll(userID uint) (*dto.TOTPEnrollmentData, error) {
+	user, err := twoFactorService.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(twoFactorService.EncryptionKey, secret)
+	if err != nil {
+		twoFactorService.Log.Error(fmt.Sprintf("Failed to encrypt TOTP secret. Error: %s", err.Error()), slog.Uint64("user_id", uint64(userID)))
+		return nil, err
+	}
+	user.TOTPSecret = &encrypted
+	user.TOTPLastStep = 0
+	if err := twoFactorService.Repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	account := ""
+	if user.Login != nil {
+		account = *user.Login
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", twoFactorService.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + twoFactorService.Issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	twoFactorService.Log.Info("TOTP enrollment was started", slog.Uint64("user_id", uint64(userID)))
+	return &dto.TOTPEnrollmentData{Secret: secret, OTPAuthURI: uri.String()}, nil
+}
+
+// Confirm включает двухфакторную аутентификацию после проверки первого кода и возвращает коды восстановления
+func (twoFactorService *TwoFactorServiceGORM) Confirm(userID uint, code string) ([]string, error) {
+	user, err := twoFactorService.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if ok, err := twoFactorService.checkTOTP(user, code); err != nil || !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	if err := twoFactorService.Repo.Update(user); err != nil {
+		return nil, err
+	}
+	codes, err := twoFactorService.replaceRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorService.Log.Info("Two-factor authentication was enabled", slog.Uint64("user_id", uint64(userID)))
+	return codes, nil
+}
+
+// Disable выключает двухфакторную аутентификацию. Требует действующий код TOTP или код восстановления
+func (twoFactorService *TwoFactorServiceGORM) Disable(userID uint, code string) error {
+	user, err := twoFactorService.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	ok, err := twoFactorService.VerifyCode(user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	user.TOTPSecret = nil
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	if err := twoFactorService.Repo.Update(user); err != nil {
+		return err
+	}
+	if err := twoFactorService.RecoveryRepo.DeleteForUser(userID); err != nil {
+		return err
+	}
+	twoFactorService.Log.Info("Two-factor authentication was disabled", slog.Uint64("user_id", uint64(userID)))
+	return nil
+}
+
+// RegenerateRecoveryCodes заменяет все коды восстановления новыми. Требует действующий код TOTP
+func (twoFactorService *TwoFactorServiceGORM) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := twoFactorService.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabledAt == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	ok, err := twoFactorService.checkTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, err := twoFactorService.replaceRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorService.Log.Info("Recovery codes were regenerated", slog.Uint64("user_id", uint64(userID)))
+	return codes, nil
+}
+
+// VerifyCode проверяет второй фактор: код TOTP или одноразовый код восстановления
+func (twoFactorService *TwoFactorServiceGORM) VerifyCode(user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return twoFactorService.checkTOTP(user, code)
+	}
+
+	used, err := twoFactorService.RecoveryRepo.MarkUsed(user.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return false, err
+	}
+	if used {
+		twoFactorService.Log.Info("Recovery code was used", slog.Uint64("user_id", uint64(user.ID)))
+	}
+	return used, nil
+}
+
+// checkTOTP проверяет код TOTP и запоминает его шаг, чтобы код нельзя было использовать повторно
+func (twoFactorService *TwoFactorServiceGORM) checkTOTP(user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+	secret, err := utils.Decrypt(twoFactorService.EncryptionKey, *user.TOTPSecret)
+	if err != nil {
+		twoFactorService.Log.Error(fmt.Sprintf("Failed to decrypt TOTP secret. Error: %s", err.Error()), slog.Uint64("user_id", uint64(user.ID)))
+		return false, err
+	}
+	step, ok := validateTOTP(secret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	// Шаг сдвигается условным UPDATE: из двух одновременных запросов с одним кодом пройдет только один
+	advanced, err := twoFactorService.Repo.AdvanceTOTPStep(user.ID, step)
+	if err != nil {
+		return false, err
+	}
+	if !advanced {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+func (twoFactorService *TwoFactorServiceGORM) replaceRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	if err := twoFactorService.RecoveryRepo.ReplaceForUser(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (twoFactorService *TwoFactorServiceGORM) findUser(userID uint) (*models.User, error) {
+	user, err := twoFactorService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Алфавит кодов восстановления без похожих символов (0/O, 1/I/L)
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// generateRecoveryCode создает код вида xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, r := range raw {
+		if i == recoveryCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(recoveryCodeAlphabet[int(r)%len(recoveryCodeAlphabet)])
+	}
+	return b.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -1,62 +1,152 @@
 package services
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
 
+	"messenger-auth/internal/domain"
 	"messenger-auth/internal/dto"
 	"messenger-auth/internal/models"
 	"messenger-auth/internal/repositories"
+	"messenger-auth/internal/utils"
 )
 
 type UserServiceGORM struct {
-	Repo repositories.UserRepository
-	Log  *slog.Logger
+	Repo     repositories.UserRepository
+	Verifier EmailVerificationService
+	Sessions SessionService
+	Roles    RoleService
+	Hasher   PasswordHasher
+	Policy   PasswordPolicyService
+	Log      *slog.Logger
 }
 
-func NewUserServiceGORM(repo repositories.UserRepository, logger *slog.Logger) UserService {
-	return &UserServiceGORM{Repo: repo, Log: logger}
+func NewUserServiceGORM(repo repositories.UserRepository, verifier EmailVerificationService, sessions SessionService, roles RoleService, hasher PasswordHasher, policy PasswordPolicyService, logger *slog.Logger) UserService {
+	return &UserServiceGORM{Repo: repo, Verifier: verifier, Sessions: sessions, Roles: roles, Hasher: hasher, Policy: policy, Log: logger}
 }
 
-func (userService *UserServiceGORM) CreateUser(userDTO *dto.UserData) error {
-	var user models.User
+// CreateUser создает пользователя с ролью по умолчанию. Пароль проверяется политикой паролей, как при регистрации,
+// а на адрес отправляется ссылка подтверждения
+func (userService *UserServiceGORM) CreateUser(userDTO *dto.CreateUserData) (*models.User, error) {
+	var missing []domain.FieldError
+	if userDTO.Login == nil {
+		missing = append(missing, domain.FieldError{Field: "login", Rule: "required", Message: "login is required"})
+	}
+	if userDTO.Password == nil {
+		missing = append(missing, domain.FieldError{Field: "password", Rule: "required", Message: "password is required"})
+	}
+	if userDTO.Name == nil {
+		missing = append(missing, domain.FieldError{Field: "name", Rule: "required", Message: "name is required"})
+	}
+	if userDTO.Email == nil {
+		missing = append(missing, domain.FieldError{Field: "email", Rule: "required", Message: "email is required"})
+	}
+	if len(missing) > 0 {
+		return nil, domain.InvalidFields(domain.CodeInvalidUserData, "login, password, name and email are required", missing, nil)
+	}
+	if err := userService.Policy.Check(*userDTO.Password, *userDTO.Login, *userDTO.Email); err != nil {
+		var policyErr *PasswordPolicyError
+		if !errors.As(err, &policyErr) {
+			return nil, err
+		}
+		fields := make([]domain.FieldError, 0, len(policyErr.Violations))
+		for _, violation := range policyErr.Violations {
+			fields = append(fields, domain.FieldError{Field: "password", Rule: violation.Rule, Message: violation.Message})
+		}
+		return nil, domain.InvalidFields(domain.CodeInvalidUserData, policyErr.Error(), fields, policyErr)
+	}
+	passwordHash, err := userService.Hasher.Hash(*userDTO.Password)
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to hash password. Error: %s", err.Error()), slog.String("login", *userDTO.Login))
+		return nil, err
+	}
+	defaultRole, err := userService.Roles.DefaultRole()
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to get default role. Error: %s", err.Error()), slog.String("login", *userDTO.Login))
+		return nil, err
+	}
+
+	user := models.User{Login: userDTO.Login, Password: &passwordHash, ServiceRoleID: defaultRole.ID}
 	// Маппинг данных из DTO в модель
 	if err := userDTO.Map(&user); err != nil {
-		userService.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		userService.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.String("login", *userDTO.Login))
+		return nil, err
 	}
 	if err := userService.Repo.Create(&user); err != nil {
-		userService.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		userService.Log.Error(fmt.Sprintf("Failed to create user. Error: %s", err.Error()), slog.String("login", *userDTO.Login))
+		return nil, err
 	}
-	userService.Log.Debug("User was created", slog.Any("user_data", user))
-	return nil
+	if err := userService.Verifier.SendVerification(&user); err != nil {
+		userService.Log.Warn("Couldn't send verification email", slog.Uint64("user_id", uint64(user.ID)), slog.String("error", err.Error()))
+	}
+	userService.Log.Debug("User was created", slog.Uint64("user_id", uint64(user.ID)))
+	return &user, nil
 }
 
-func (userService *UserServiceGORM) UpdateUser(id uint, userDTO *dto.UserData) error {
+func (userService *UserServiceGORM) UpdateUser(id uint, userDTO *dto.UserData) (*models.User, error) {
 	user, err := userService.Repo.FindByID(id)
 	if err != nil {
-		userService.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		userService.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.Uint64("user_id", uint64(id)))
+		return nil, err
 	}
+	oldEmail := user.Email
 	// Маппинг данных из DTO в модель
 	if err = userDTO.Map(user); err != nil {
 		userService.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		return nil, err
+	}
+	// Новый адрес заменяет старый только после подтверждения по ссылке. Занят ли адрес, не проверяется здесь:
+	// ответ на смену почты одинаков для свободных и занятых адресов (см. EmailVerificationService.SendEmailChange)
+	emailChanged := user.Email != nil && (oldEmail == nil || *oldEmail != *user.Email)
+	if emailChanged {
+		user.PendingEmail = user.Email
+		user.Email = oldEmail
 	}
 	if err = userService.Repo.Update(user); err != nil {
 		userService.Log.Error(fmt.Sprintf("Failed to update user. Error: %s", err.Error()), slog.Any("user_data", user))
-		return err
+		return nil, err
+	}
+	if emailChanged {
+		if err := userService.Verifier.SendEmailChange(user); err != nil {
+			userService.Log.Warn("Couldn't send email change confirmation", slog.Uint64("user_id", uint64(user.ID)), slog.String("error", err.Error()))
+		}
 	}
 	userService.Log.Debug("User was updated", slog.Any("user_data", user))
-	return nil
+	return user, nil
 }
 
+// DeleteUser удаляет пользователя и отзывает его токены. Пользователей, которые могут управлять ролями, удалить нельзя:
+// иначе администратор мог бы удалить себя или последнего другого администратора. Сначала у них нужно отозвать роль
 func (userService *UserServiceGORM) DeleteUser(id uint) error {
+	user, err := userService.Repo.FindByID(id)
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to delete user. Error: %s", err.Error()), slog.Any("user_id", id))
+		return err
+	}
+	canManageRoles, err := userService.Roles.HasPermission(user.ServiceRoleID, models.PermissionRolesManage)
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to delete user. Error: %s", err.Error()), slog.Any("user_id", id))
+		return err
+	}
+	if canManageRoles {
+		return domain.Forbidden(domain.CodeUserProtected, "users who can manage roles cannot be deleted, revoke their role first", nil)
+	}
 	if err := userService.Repo.Delete(id); err != nil {
 		userService.Log.Error(fmt.Sprintf("Failed to delete user. Error: %s", err.Error()), slog.Any("user_id", id))
 		return err
 	}
+	// Уже выданные токены удаленного пользователя перестают приниматься сразу, а не по истечении срока
+	if err := userService.Sessions.RevokeAllSessions(id); err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to revoke sessions of deleted user. Error: %s", err.Error()), slog.Any("user_id", id))
+		return err
+	}
 	userService.Log.Debug("User was deleted", slog.Any("user_id", id))
 	return nil
 }
@@ -70,13 +160,180 @@ func (userService *UserServiceGORM) GetUserByID(id uint) (*models.User, error) {
 	return user, err
 }
 
-func (userService *UserServiceGORM) GetUsers() ([]models.User, error) {
-	users, err := userService.Repo.FindAll()
+func (userService *UserServiceGORM) GetUsersByIDs(ids []uint) ([]models.User, error) {
+	users, err := userService.Repo.FindByIDs(ids)
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to get users by ids. Error: %s", err.Error()), slog.Int("count", len(ids)))
+		return nil, err
+	}
+	userService.Log.Debug("Users were received", slog.Int("requested", len(ids)), slog.Int("found", len(users)))
+	return users, nil
+}
+
+// GetUsers возвращает страницу списка пользователей по фильтрам запроса и курсор следующей страницы
+func (userService *UserServiceGORM) GetUsers(query *dto.UserListQuery) ([]models.User, *dto.PageMeta, error) {
+	pageQuery, err := userPageQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	page, err := userService.Repo.FindPage(*pageQuery)
 	if err != nil {
-		userService.Log.Error(fmt.Sprintf("Failed to get all users. Error: %s", err.Error()))
+		userService.Log.Error(fmt.Sprintf("Failed to get users. Error: %s", err.Error()))
+		return nil, nil, err
 	}
-	userService.Log.Debug("Users was received from DB")
-	return users, err
+
+	meta := &dto.PageMeta{Total: page.Total}
+	if page.HasMore {
+		cursor, err := encodeUserCursor(query.Sort, pageQuery.SortBy, &page.Users[len(page.Users)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		meta.NextCursor = &cursor
+	}
+	userService.Log.Debug("Users were received", slog.Int("count", len(page.Users)), slog.Bool("has_more", page.HasMore))
+	return page.Users, meta, nil
+}
+
+// SearchUsers ищет контакты для пользователя callerID. Поиск по почте выполняется, только если запрос - полный адрес,
+// и возвращает лишь точное совпадение: по части адреса перебрать почты пользователей нельзя
+func (userService *UserServiceGORM) SearchUsers(callerID uint, query *dto.UserSearchQuery) ([]dto.UserSearchResult, error) {
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultUserSearchSize
+	}
+	text := strings.TrimSpace(query.Q)
+
+	var users []models.User
+	var err error
+	if isFullEmail(text) {
+		users, err = userService.Repo.SearchByEmail(text, callerID)
+	} else {
+		users, err = userService.Repo.Search(text, callerID, limit)
+	}
+	if err != nil {
+		userService.Log.Error(fmt.Sprintf("Failed to search users. Error: %s", err.Error()), slog.Uint64("user_id", uint64(callerID)))
+		return nil, err
+	}
+
+	results := make([]dto.UserSearchResult, 0, len(users))
+	for _, user := range users {
+		results = append(results, dto.UserSearchResult{ID: user.ID, Login: user.Login, Name: user.Name, FirstName: user.FirstName, LastName: user.LastName})
+	}
+	userService.Log.Debug("Users were found", slog.Uint64("user_id", uint64(callerID)), slog.Int("count", len(results)))
+	return results, nil
+}
+
+// Число результатов поиска контактов, если limit не задан
+const defaultUserSearchSize = 10
+
+// isFullEmail проверяет, что запрос - целый адрес почты (local@domain.tld), а не его часть
+func isFullEmail(text string) bool {
+	address, err := mail.ParseAddress(text)
+	if err != nil || address.Address != text {
+		return false
+	}
+	_, host, _ := strings.Cut(address.Address, "@")
+	return strings.Contains(strings.Trim(host, "."), ".")
+}
+
+// Размер страницы списка пользователей, если limit не задан
+const defaultUserPageSize = 20
+
+// userCursor - содержимое курсора списка пользователей: ключ последней записи страницы. Сортировка
+// сохраняется в курсоре, чтобы его нельзя было применить к списку с другой сортировкой
+type userCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+// userPageQuery проверяет параметры списка и переводит их в запрос к репозиторию
+func userPageQuery(query *dto.UserListQuery) (*repositories.UserPageQuery, error) {
+	pageQuery := &repositories.UserPageQuery{
+		Filter: repositories.UserFilter{
+			EmailDomain:   strings.TrimPrefix(query.EmailDomain, "@"),
+			CreatedAfter:  query.CreatedAfter,
+			CreatedBefore: query.CreatedBefore,
+			RoleID:        query.RoleID,
+			Deleted:       query.Deleted,
+		},
+		SortBy:    "id",
+		Limit:     query.Limit,
+		WithTotal: query.IncludeTotal,
+	}
+	if pageQuery.Limit == 0 && !query.Unpaginated {
+		pageQuery.Limit = defaultUserPageSize
+	}
+	if query.Sort != "" {
+		pageQuery.SortBy, pageQuery.Desc = strings.TrimPrefix(query.Sort, "-"), strings.HasPrefix(query.Sort, "-")
+		if _, ok := repositories.UserSortColumns[pageQuery.SortBy]; !ok {
+			return nil, invalidListParam("sort", "sort", fmt.Sprintf("sort must be one of: %s", strings.Join(userSortFields(), ", ")))
+		}
+	}
+
+	if query.Cursor == "" {
+		return pageQuery, nil
+	}
+	var cursor userCursor
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID == 0 {
+		return nil, invalidListParam("cursor", "cursor", "cursor is invalid")
+	}
+	if cursor.Sort != query.Sort {
+		return nil, invalidListParam("cursor", "cursor", "cursor was issued for another sort order")
+	}
+	pageQuery.AfterID = cursor.ID
+	switch pageQuery.SortBy {
+	case "id":
+		// Ключом служит только id
+	case "created_at":
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, invalidListParam("cursor", "cursor", "cursor is invalid")
+		}
+		pageQuery.AfterValue = createdAt
+	default:
+		pageQuery.AfterValue = cursor.Value
+	}
+	return pageQuery, nil
+}
+
+// encodeUserCursor строит курсор страницы, следующей за user
+func encodeUserCursor(sort string, sortBy string, user *models.User) (string, error) {
+	cursor := userCursor{Sort: sort, ID: user.ID}
+	switch sortBy {
+	case "created_at":
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	case "login":
+		cursor.Value = utils.ValueOrZero(user.Login)
+	case "name":
+		cursor.Value = utils.ValueOrZero(user.Name)
+	case "email":
+		cursor.Value = utils.ValueOrZero(user.Email)
+	}
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// userSortFields возвращает допустимые поля сортировки в стабильном порядке
+func userSortFields() []string {
+	fields := make([]string, 0, len(repositories.UserSortColumns))
+	for field := range repositories.UserSortColumns {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// invalidListParam - ошибка проверки одного параметра списка
+func invalidListParam(field string, rule string, message string) error {
+	return domain.InvalidFields(domain.CodeInvalidRequest, message, []domain.FieldError{{Field: field, Rule: rule, Message: message}}, nil)
 }
 
 func (s *UserServiceGORM) GetRepo() repositories.UserRepository {
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/testutil"
+)
+
+func newTestUserService(users *testutil.UserRepo) (*UserServiceGORM, *fakeVerifier, *fakeSessionService) {
+	verifier := &fakeVerifier{}
+	sessions := &fakeSessionService{}
+	policy := NewPasswordPolicyServiceLocal(8, 0, nil, nil, discardLogger())
+	service := NewUserServiceGORM(users, verifier, sessions, &fakeRoleService{}, fakeHasher{}, policy, discardLogger()).(*UserServiceGORM)
+	return service, verifier, sessions
+}
+
+func createUserData(login, password, name, email string) *dto.CreateUserData {
+	data := &dto.CreateUserData{Login: optionalString(login), Password: optionalString(password)}
+	data.Name = optionalString(name)
+	data.Email = optionalString(email)
+	return data
+}
+
+// optionalString возвращает nil для пустой строки, как для поля, которого нет в запросе
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func TestCreateUser(t *testing.T) {
+	users := testutil.NewUserRepo()
+	service, verifier, _ := newTestUserService(users)
+
+	user, err := service.CreateUser(createUserData("bob", "correct-battery-staple", "Bob", "bob@messenger.test"))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stored, err := users.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("created user was not stored: %v", err)
+	}
+	if *stored.Login != "bob" || *stored.Password != "hashed:correct-battery-staple" {
+		t.Fatalf("stored user = login %q password %q", *stored.Login, *stored.Password)
+	}
+	if stored.ServiceRoleID != fakeUserRoleID {
+		t.Fatalf("role = %d, want the default role", stored.ServiceRoleID)
+	}
+	if len(verifier.sentTo) != 1 || verifier.sentTo[0] != user.ID {
+		t.Fatalf("verification sent to %v, want the new user", verifier.sentTo)
+	}
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	service, _, _ := newTestUserService(testutil.NewUserRepo())
+
+	cases := []struct {
+		name   string
+		data   *dto.CreateUserData
+		fields []string
+	}{
+		{"missing login and password", createUserData("", "", "Bob", "bob@messenger.test"), []string{"login", "password"}},
+		{"password against the policy", createUserData("bob", "bob-12345", "Bob", "bob@messenger.test"), []string{"password"}},
+	}
+	for _, tc := range cases {
+		_, err := service.CreateUser(tc.data)
+		var domainErr *domain.Error
+		if !errors.Is(err, domain.ErrValidation) || !errors.As(err, &domainErr) {
+			t.Fatalf("%s: err = %v, want a validation error", tc.name, err)
+		}
+		if len(domainErr.Fields) != len(tc.fields) {
+			t.Fatalf("%s: fields = %+v, want %v", tc.name, domainErr.Fields, tc.fields)
+		}
+		for i, field := range tc.fields {
+			if domainErr.Fields[i].Field != field {
+				t.Fatalf("%s: fields = %+v, want %v", tc.name, domainErr.Fields, tc.fields)
+			}
+		}
+	}
+}
+
+func TestDeleteUserProtectsRoleManagers(t *testing.T) {
+	admin := newTestUser("admin")
+	admin.ServiceRoleID = fakeAdminRoleID
+	users := testutil.NewUserRepo(admin, newTestUser("bob"))
+	service, _, sessions := newTestUserService(users)
+
+	err := service.DeleteUser(1)
+	var domainErr *domain.Error
+	if !errors.Is(err, domain.ErrForbidden) || !errors.As(err, &domainErr) || domainErr.Code != domain.CodeUserProtected {
+		t.Fatalf("delete admin: err = %v, want ErrForbidden with %s", err, domain.CodeUserProtected)
+	}
+	if _, err := users.FindByID(1); err != nil {
+		t.Fatalf("admin was deleted: %v", err)
+	}
+
+	if err := service.DeleteUser(2); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	if _, err := users.FindByID(2); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("user was not deleted: %v", err)
+	}
+	if len(sessions.revoked) != 1 || sessions.revoked[0] != 2 {
+		t.Fatalf("revoked sessions of %v, want the deleted user", sessions.revoked)
+	}
+}
+
+func TestGetUsersUnpaginated(t *testing.T) {
+	var many []*models.User
+	for i := 0; i < defaultUserPageSize+5; i++ {
+		many = append(many, newTestUser(fmt.Sprintf("user%d", i)))
+	}
+	users := testutil.NewUserRepo(many...)
+	service, _, _ := newTestUserService(users)
+
+	list, meta, err := service.GetUsers(&dto.UserListQuery{Unpaginated: true})
+	if err != nil {
+		t.Fatalf("GetUsers(unpaginated): %v", err)
+	}
+	if users.LastPageQuery().Limit != 0 || len(list) != len(many) || meta.NextCursor != nil {
+		t.Fatalf("unpaginated: limit %d, got %d users, cursor %v, want all users", users.LastPageQuery().Limit, len(list), meta.NextCursor)
+	}
+
+	list, meta, err = service.GetUsers(&dto.UserListQuery{})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(list) != defaultUserPageSize || meta.NextCursor == nil {
+		t.Fatalf("paginated: got %d users, cursor %v, want a page of %d with a cursor", len(list), meta.NextCursor, defaultUserPageSize)
+	}
+}
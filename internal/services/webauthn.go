@@ -0,0 +1,324 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+	"messenger-auth/internal/utils"
+)
+
+const (
+	webAuthnCeremonyRegistration = "registration"
+	webAuthnCeremonyLogin        = "login"
+)
+
+// webAuthnSession - состояние начатой церемонии, хранится в Redis по challenge
+type webAuthnSession struct {
+	UserID   uint   `json:"userId"` // 0 - вход без логина (ключ выбирается на устройстве)
+	Ceremony string `json:"ceremony"`
+}
+
+type WebAuthnServiceGORM struct {
+	Repo            repositories.UserRepository
+	CredentialsRepo repositories.WebAuthnCredentialRepository
+	KeyValues       repositories.KeyValueRepository
+	RPID            string
+	RPName          string
+	Origins         []string
+	ChallengeTTL    time.Duration
+	// Ключ, на котором вычисляются фиктивные ключи доступа для неизвестных логинов
+	CredentialSecret []byte
+	Log              *slog.Logger
+}
+
+func NewWebAuthnServiceGORM(repo repositories.UserRepository, credentialsRepo repositories.WebAuthnCredentialRepository, keyValues repositories.KeyValueRepository, rpID string, rpName string, origins []string, challengeTTL time.Duration, credentialSecret []byte, logger *slog.Logger) WebAuthnService {
+	return &WebAuthnServiceGORM{
+		Repo:             repo,
+		CredentialsRepo:  credentialsRepo,
+		KeyValues:        keyValues,
+		RPID:             rpID,
+		RPName:           rpName,
+		Origins:          origins,
+		ChallengeTTL:     challengeTTL,
+		CredentialSecret: credentialSecret,
+		Log:              logger,
+	}
+}
+
+// BeginRegistration начинает регистрацию ключа доступа для аутентифицированного пользователя
+func (webAuthnService *WebAuthnServiceGORM) BeginRegistration(userID uint) (*dto.WebAuthnCreationOptions, error) {
+	user, err := webAuthnService.Repo.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	credentials, err := webAuthnService.CredentialsRepo.FindByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := webAuthnService.startSession(webAuthnSession{UserID: userID, Ceremony: webAuthnCeremonyRegistration})
+	if err != nil {
+		return nil, err
+	}
+
+	options := &dto.WebAuthnCreationOptions{}
+	options.PublicKey.RelyingParty = dto.WebAuthnRelyingParty{ID: webAuthnService.RPID, Name: webAuthnService.RPName}
+	options.PublicKey.User = dto.WebAuthnUser{ID: userHandle(user.ID), Name: utils.ValueOrZero(user.Login), DisplayName: utils.ValueOrZero(user.Name)}
+	options.PublicKey.Challenge = challenge
+	options.PublicKey.PubKeyCredParams = []dto.WebAuthnCredentialParameter{
+		{Type: "public-key", Algorithm: coseAlgES256},
+		{Type: "public-key", Algorithm: coseAlgEdDSA},
+		{Type: "public-key", Algorithm: coseAlgRS256},
+	}
+	options.PublicKey.Timeout = webAuthnService.ChallengeTTL.Milliseconds()
+	options.PublicKey.ExcludeCredentials = credentialDescriptors(credentials)
+	// Ключ сохраняется на устройстве, чтобы входить без ввода логина
+	options.PublicKey.AuthenticatorSelection = dto.WebAuthnAuthenticatorSelection{ResidentKey: "required", UserVerification: "required"}
+	options.PublicKey.Attestation = "none"
+	return options, nil
+}
+
+// FinishRegistration проверяет ответ аутентификатора и сохраняет новый ключ доступа
+func (webAuthnService *WebAuthnServiceGORM) FinishRegistration(userID uint, registrationDTO *dto.WebAuthnRegistrationData) (*models.WebAuthnCredential, error) {
+	clientDataJSON, err := decodeWebAuthnField(registrationDTO.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	rawAttestation, err := decodeWebAuthnField(registrationDTO.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	client, err := parseClientData(clientDataJSON, clientDataTypeCreate, webAuthnService.Origins)
+	if err != nil {
+		return nil, err
+	}
+	session, err := webAuthnService.takeSession(client.Challenge, webAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	attestation, err := parseAttestationObject(rawAttestation)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := parseAuthenticatorData(attestation.AuthData, webAuthnService.RPID, true)
+	if err != nil {
+		return nil, err
+	}
+	if authData.Flags&authDataFlagUserVerified == 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	algorithm, _, err := parsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := webAuthnEncoding.EncodeToString(authData.CredentialID)
+	if _, err := webAuthnService.CredentialsRepo.FindByCredentialID(credentialID); err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	credential := &models.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    algorithm,
+		SignCount:    int64(authData.SignCount),
+		Name:         registrationDTO.Name,
+		CreatedAt:    time.Now(),
+	}
+	if err := webAuthnService.CredentialsRepo.Create(credential); err != nil {
+		return nil, err
+	}
+	webAuthnService.Log.Info("Webauthn credential was registered", slog.Uint64("user_id", uint64(userID)), slog.Uint64("credential_id", uint64(credential.ID)))
+	return credential, nil
+}
+
+// BeginLogin начинает вход по ключу доступа. Чтобы по ответу нельзя было проверить существование аккаунта,
+// для неизвестного логина и для пользователя без ключей возвращается фиктивный ключ, постоянный для логина
+func (webAuthnService *WebAuthnServiceGORM) BeginLogin(login string) (*dto.WebAuthnRequestOptions, error) {
+	session := webAuthnSession{Ceremony: webAuthnCeremonyLogin}
+	var descriptors []dto.WebAuthnCredentialDescriptor
+	if login != "" {
+		user, err := webAuthnService.Repo.FindByLogin(login)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			session.UserID = user.ID
+			credentials, err := webAuthnService.CredentialsRepo.FindByUser(user.ID)
+			if err != nil {
+				return nil, err
+			}
+			descriptors = credentialDescriptors(credentials)
+		}
+		if len(descriptors) == 0 {
+			descriptors = []dto.WebAuthnCredentialDescriptor{webAuthnService.fakeCredentialDescriptor(login)}
+		}
+	}
+	challenge, err := webAuthnService.startSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &dto.WebAuthnRequestOptions{}
+	options.PublicKey.Challenge = challenge
+	options.PublicKey.Timeout = webAuthnService.ChallengeTTL.Milliseconds()
+	options.PublicKey.RelyingPartyID = webAuthnService.RPID
+	options.PublicKey.AllowCredentials = descriptors
+	if options.PublicKey.AllowCredentials == nil {
+		options.PublicKey.AllowCredentials = []dto.WebAuthnCredentialDescriptor{}
+	}
+	options.PublicKey.UserVerification = "required"
+	return options, nil
+}
+
+// fakeCredentialDescriptor возвращает идентификатор несуществующего ключа: HMAC логина на CredentialSecret.
+// Для одного логина он одинаков между запросами и репликами, поэтому его нельзя отличить от настоящего
+func (webAuthnService *WebAuthnServiceGORM) fakeCredentialDescriptor(login string) dto.WebAuthnCredentialDescriptor {
+	mac := hmac.New(sha256.New, webAuthnService.CredentialSecret)
+	mac.Write([]byte(login))
+	return dto.WebAuthnCredentialDescriptor{Type: "public-key", ID: webAuthnEncoding.EncodeToString(mac.Sum(nil))}
+}
+
+// FinishLogin проверяет подпись аутентификатора и возвращает владельца ключа
+func (webAuthnService *WebAuthnServiceGORM) FinishLogin(assertionDTO *dto.WebAuthnAssertionData) (*models.User, error) {
+	clientDataJSON, err := decodeWebAuthnField(assertionDTO.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	rawAuthData, err := decodeWebAuthnField(assertionDTO.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := decodeWebAuthnField(assertionDTO.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	client, err := parseClientData(clientDataJSON, clientDataTypeGet, webAuthnService.Origins)
+	if err != nil {
+		return nil, err
+	}
+	session, err := webAuthnService.takeSession(client.Challenge, webAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := webAuthnService.CredentialsRepo.FindByCredentialID(assertionDTO.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		return nil, err
+	}
+	if session.UserID != 0 && session.UserID != credential.UserID {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if assertionDTO.Response.UserHandle != "" && assertionDTO.Response.UserHandle != userHandle(credential.UserID) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData, webAuthnService.RPID, false)
+	if err != nil {
+		return nil, err
+	}
+	if authData.Flags&authDataFlagUserVerified == 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if err := verifyAssertionSignature(credential.PublicKey, rawAuthData, clientDataJSON, signature); err != nil {
+		return nil, err
+	}
+
+	// Счетчик подписей должен расти. Если он не вырос, у ключа есть копия: ключ помечается
+	// и больше не принимается, пользователь должен зарегистрировать его заново.
+	// Аутентификаторы без счетчика (всегда 0) проверку пропускают. Счетчик сохраняется условным UPDATE,
+	// поэтому из двух одновременных входов с одинаковым счетчиком пройдет только один
+	signCount := int64(authData.SignCount)
+	cloned := credential.CloneWarning || ((signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount)
+	if !cloned {
+		updated, err := webAuthnService.CredentialsRepo.UpdateSignCount(credential.ID, signCount, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		cloned = !updated
+	}
+	if cloned {
+		webAuthnService.Log.Warn("Webauthn sign count did not increase, credential is possibly cloned", slog.Uint64("user_id", uint64(credential.UserID)), slog.Uint64("credential_id", uint64(credential.ID)))
+		if err := webAuthnService.CredentialsRepo.MarkCloned(credential.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrWebAuthnCredentialCloned
+	}
+
+	user, err := webAuthnService.Repo.FindByID(credential.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		return nil, err
+	}
+	webAuthnService.Log.Debug("Webauthn assertion was verified", slog.Uint64("user_id", uint64(user.ID)), slog.Uint64("credential_id", uint64(credential.ID)))
+	return user, nil
+}
+
+// startSession создает challenge и сохраняет состояние церемонии до его истечения
+func (webAuthnService *WebAuthnServiceGORM) startSession(session webAuthnSession) (string, error) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := webAuthnService.KeyValues.Set(webAuthnSessionKey(challenge), session, webAuthnService.ChallengeTTL); err != nil {
+		webAuthnService.Log.Error(fmt.Sprintf("Failed to store webauthn challenge. Error: %s", err.Error()))
+		return "", err
+	}
+	return challenge, nil
+}
+
+// takeSession забирает состояние церемонии. Challenge одноразовый
+func (webAuthnService *WebAuthnServiceGORM) takeSession(challenge string, ceremony string) (*webAuthnSession, error) {
+	var session webAuthnSession
+	if err := webAuthnService.KeyValues.Take(webAuthnSessionKey(challenge), &session); err != nil {
+		if errors.Is(err, repositories.ErrKeyNotFound) {
+			return nil, ErrInvalidWebAuthnChallenge
+		}
+		return nil, err
+	}
+	if session.Ceremony != ceremony {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+	return &session, nil
+}
+
+func webAuthnSessionKey(challenge string) string {
+	return "webauthn_" + challenge
+}
+
+// userHandle - идентификатор пользователя для аутентификатора. Не содержит персональных данных
+func userHandle(userID uint) string {
+	return webAuthnEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(userID), 10)))
+}
+
+func credentialDescriptors(credentials []models.WebAuthnCredential) []dto.WebAuthnCredentialDescriptor {
+	descriptors := make([]dto.WebAuthnCredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, dto.WebAuthnCredentialDescriptor{Type: "public-key", ID: credential.CredentialID})
+	}
+	return descriptors
+}
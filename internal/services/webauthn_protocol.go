@@ -0,0 +1,209 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Алгоритмы COSE, которые принимаются для ключей доступа
+const (
+	coseAlgES256 int64 = -7
+	coseAlgEdDSA int64 = -8
+	coseAlgRS256 int64 = -257
+)
+
+// Флаги данных аутентификатора (WebAuthn §6.1)
+const (
+	authDataFlagUserPresent      byte = 0x01
+	authDataFlagUserVerified     byte = 0x04
+	authDataFlagAttestedCredData byte = 0x40
+)
+
+const (
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+)
+
+var webAuthnEncoding = base64.RawURLEncoding
+
+// clientData - разобранный clientDataJSON
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData - разобранные данные аутентификатора. Поля ключа заполнены только при регистрации
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+type attestationObject struct {
+	Format    string          `cbor:"fmt"`
+	Statement cbor.RawMessage `cbor:"attStmt"`
+	AuthData  []byte          `cbor:"authData"`
+}
+
+// parseClientData разбирает clientDataJSON и сверяет тип церемонии и источник запроса
+func parseClientData(raw []byte, ceremony string, origins []string) (*clientData, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if data.Type != ceremony || data.Challenge == "" || !slices.Contains(origins, data.Origin) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return &data, nil
+}
+
+// parseAuthenticatorData разбирает данные аутентификатора и проверяет, что они выданы для нашего RP ID
+// и что пользователь подтвердил присутствие
+func parseAuthenticatorData(raw []byte, rpID string, withCredential bool) (*authenticatorData, error) {
+	// rpIdHash (32) + flags (1) + signCount (4)
+	if len(raw) < 37 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	data := &authenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if string(data.RPIDHash) != string(rpIDHash[:]) || data.Flags&authDataFlagUserPresent == 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if !withCredential {
+		return data, nil
+	}
+
+	// aaguid (16) + credentialIdLength (2) + credentialId + credentialPublicKey (COSE)
+	if data.Flags&authDataFlagAttestedCredData == 0 || len(raw) < 55 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	idLength := int(binary.BigEndian.Uint16(raw[53:55]))
+	if idLength == 0 || len(raw) < 55+idLength {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	data.CredentialID = raw[55 : 55+idLength]
+	// За ключом могут идти расширения, поэтому берется только первый CBOR объект
+	var key cbor.RawMessage
+	if _, err := cbor.UnmarshalFirst(raw[55+idLength:], &key); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	data.PublicKey = key
+	return data, nil
+}
+
+// parseAttestationObject разбирает ответ на регистрацию. Мы запрашиваем attestation "none",
+// поэтому аттестация аутентификатора не проверяется и другие форматы не принимаются
+func parseAttestationObject(raw []byte) (*attestationObject, error) {
+	var object attestationObject
+	if err := cbor.Unmarshal(raw, &object); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if object.Format != "none" {
+		return nil, ErrUnsupportedAttestation
+	}
+	return &object, nil
+}
+
+// coseKey - открытый ключ COSE (RFC 9053). Поля -1..-3 зависят от типа ключа
+type coseKey struct {
+	KeyType   int64           `cbor:"1,keyasint"`
+	Algorithm int64           `cbor:"3,keyasint"`
+	Param1    cbor.RawMessage `cbor:"-1,keyasint"` // crv (EC2, OKP) или n (RSA)
+	Param2    []byte          `cbor:"-2,keyasint"` // x (EC2, OKP) или e (RSA)
+	Param3    []byte          `cbor:"-3,keyasint"` // y (EC2)
+}
+
+// parsePublicKey разбирает COSE ключ и возвращает его алгоритм и ключ для проверки подписи
+func parsePublicKey(raw []byte) (int64, crypto.PublicKey, error) {
+	var key coseKey
+	if err := cbor.Unmarshal(raw, &key); err != nil {
+		return 0, nil, ErrInvalidWebAuthnResponse
+	}
+
+	switch {
+	case key.KeyType == 2 && key.Algorithm == coseAlgES256:
+		var curve int64
+		if err := cbor.Unmarshal(key.Param1, &curve); err != nil || curve != 1 || len(key.Param2) != 32 || len(key.Param3) != 32 {
+			return 0, nil, ErrUnsupportedWebAuthnKey
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(key.Param2),
+			Y:     new(big.Int).SetBytes(key.Param3),
+		}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return 0, nil, ErrUnsupportedWebAuthnKey
+		}
+		return key.Algorithm, publicKey, nil
+	case key.KeyType == 1 && key.Algorithm == coseAlgEdDSA:
+		var curve int64
+		if err := cbor.Unmarshal(key.Param1, &curve); err != nil || curve != 6 || len(key.Param2) != ed25519.PublicKeySize {
+			return 0, nil, ErrUnsupportedWebAuthnKey
+		}
+		return key.Algorithm, ed25519.PublicKey(key.Param2), nil
+	case key.KeyType == 3 && key.Algorithm == coseAlgRS256:
+		var modulus []byte
+		if err := cbor.Unmarshal(key.Param1, &modulus); err != nil || len(modulus) < 256 || len(key.Param2) == 0 || len(key.Param2) > 4 {
+			return 0, nil, ErrUnsupportedWebAuthnKey
+		}
+		exponent := 0
+		for _, b := range key.Param2 {
+			exponent = exponent<<8 | int(b)
+		}
+		return key.Algorithm, &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: exponent}, nil
+	}
+	return 0, nil, ErrUnsupportedWebAuthnKey
+}
+
+// verifyAssertionSignature проверяет подпись аутентификатора над authenticatorData || SHA-256(clientDataJSON)
+func verifyAssertionSignature(rawKey []byte, authData []byte, clientDataJSON []byte, signature []byte) error {
+	algorithm, publicKey, err := parsePublicKey(rawKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	valid := false
+	switch algorithm {
+	case coseAlgES256:
+		digest := sha256.Sum256(signed)
+		valid = ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature)
+	case coseAlgEdDSA:
+		valid = ed25519.Verify(publicKey.(ed25519.PublicKey), signed, signature)
+	case coseAlgRS256:
+		digest := sha256.Sum256(signed)
+		valid = rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return ErrInvalidWebAuthnResponse
+	}
+	return nil
+}
+
+// decodeWebAuthnField декодирует бинарное поле ответа браузера (base64url без дополнения)
+func decodeWebAuthnField(value string) ([]byte, error) {
+	decoded, err := webAuthnEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return decoded, nil
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"messenger-auth/internal/dto"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/testutil"
+)
+
+const (
+	testRPID   = "messenger.test"
+	testOrigin = "https://messenger.test"
+)
+
+// softAuthenticator - программный аутентификатор с ключом ES256 и счетчиком подписей
+type softAuthenticator struct {
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	signCount    uint32
+	// Аутентификатор без счетчика всегда сообщает 0
+	noCounter bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{credentialID: credentialID, key: key}
+}
+
+// clone возвращает копию аутентификатора с тем же ключом и счетчиком
+func (a *softAuthenticator) clone() *softAuthenticator {
+	copied := *a
+	return &copied
+}
+
+func (a *softAuthenticator) id() string {
+	return webAuthnEncoding.EncodeToString(a.credentialID)
+}
+
+func (a *softAuthenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *dto.WebAuthnCreationOptions) *dto.WebAuthnRegistrationData {
+	t.Helper()
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	publicKey, err := cbor.Marshal(map[int]interface{}{1: 2, 3: coseAlgES256, -1: 1, -2: x, -3: y})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authData(authDataFlagUserPresent | authDataFlagUserVerified | authDataFlagAttestedCredData)
+	authData = append(authData, make([]byte, 16)...) // aaguid
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+	attestation, err := cbor.Marshal(map[string]interface{}{"fmt": "none", "attStmt": map[string]interface{}{}, "authData": authData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration := &dto.WebAuthnRegistrationData{ID: a.id(), Type: "public-key", Name: "Test key"}
+	registration.Response.ClientDataJSON = clientDataJSON(t, clientDataTypeCreate, options.PublicKey.Challenge)
+	registration.Response.AttestationObject = webAuthnEncoding.EncodeToString(attestation)
+	return registration
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *dto.WebAuthnRequestOptions, userID uint) *dto.WebAuthnAssertionData {
+	t.Helper()
+	if !a.noCounter {
+		a.signCount++
+	}
+	authData := a.authData(authDataFlagUserPresent | authDataFlagUserVerified)
+	clientData := clientDataJSON(t, clientDataTypeGet, options.PublicKey.Challenge)
+	rawClientData, _ := webAuthnEncoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := &dto.WebAuthnAssertionData{ID: a.id(), Type: "public-key"}
+	assertion.Response.ClientDataJSON = clientData
+	assertion.Response.AuthenticatorData = webAuthnEncoding.EncodeToString(authData)
+	assertion.Response.Signature = webAuthnEncoding.EncodeToString(signature)
+	assertion.Response.UserHandle = userHandle(userID)
+	return assertion
+}
+
+func clientDataJSON(t *testing.T, ceremony string, challenge string) string {
+	t.Helper()
+	data, err := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webAuthnEncoding.EncodeToString(data)
+}
+
+func newTestWebAuthnService(users *testutil.UserRepo) (*WebAuthnServiceGORM, *fakeWebAuthnCredentialRepo) {
+	credentials := &fakeWebAuthnCredentialRepo{}
+	service := NewWebAuthnServiceGORM(users, credentials, newFakeKeyValueRepo(), testRPID, "Messenger", []string{testOrigin}, time.Minute, []byte("test-webauthn-credential-secret-32-bytes"), discardLogger())
+	return service.(*WebAuthnServiceGORM), credentials
+}
+
+func newTestUser(login string) *models.User {
+	email := login + "@messenger.test"
+	return &models.User{Login: &login, Name: &login, Email: &email}
+}
+
+// registerSoftAuthenticator регистрирует новый программный аутентификатор пользователю
+func registerSoftAuthenticator(t *testing.T, service *WebAuthnServiceGORM, userID uint) *softAuthenticator {
+	t.Helper()
+	authenticator := newSoftAuthenticator(t)
+	options, err := service.BeginRegistration(userID)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	credential, err := service.FinishRegistration(userID, authenticator.create(t, options))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if credential.CredentialID != authenticator.id() || credential.UserID != userID || credential.Algorithm != coseAlgES256 {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	return authenticator
+}
+
+func loginWith(t *testing.T, service *WebAuthnServiceGORM, authenticator *softAuthenticator, login string, userID uint) (*models.User, error) {
+	t.Helper()
+	options, err := service.BeginLogin(login)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return service.FinishLogin(authenticator.get(t, options, userID))
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, credentials := newTestWebAuthnService(users)
+	authenticator := registerSoftAuthenticator(t, service, 1)
+
+	options, err := service.BeginLogin("alice")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	allowed := options.PublicKey.AllowCredentials
+	if len(allowed) != 1 || allowed[0].ID != authenticator.id() {
+		t.Fatalf("allowCredentials = %+v, want the registered credential", allowed)
+	}
+	user, err := service.FinishLogin(authenticator.get(t, options, 1))
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if user.ID != 1 {
+		t.Fatalf("logged in as user %d, want 1", user.ID)
+	}
+
+	// Вход без логина (ключ выбран на устройстве)
+	if _, err := loginWith(t, service, authenticator, "", 1); err != nil {
+		t.Fatalf("FinishLogin without login: %v", err)
+	}
+	stored, _ := credentials.FindByCredentialID(authenticator.id())
+	if stored.SignCount != 2 || stored.LastUsedAt == nil || stored.CloneWarning {
+		t.Fatalf("credential after two logins = %+v", stored)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsDuplicateCredential(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, _ := newTestWebAuthnService(users)
+	authenticator := registerSoftAuthenticator(t, service, 1)
+
+	options, err := service.BeginRegistration(1)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	if len(options.PublicKey.ExcludeCredentials) != 1 || options.PublicKey.ExcludeCredentials[0].ID != authenticator.id() {
+		t.Fatalf("excludeCredentials = %+v, want the registered credential", options.PublicKey.ExcludeCredentials)
+	}
+	if _, err := service.FinishRegistration(1, authenticator.create(t, options)); !errors.Is(err, ErrWebAuthnCredentialExists) {
+		t.Fatalf("FinishRegistration of the same key: got %v, want ErrWebAuthnCredentialExists", err)
+	}
+}
+
+func TestWebAuthnLoginRejectsReplayedChallenge(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, _ := newTestWebAuthnService(users)
+	authenticator := registerSoftAuthenticator(t, service, 1)
+
+	options, err := service.BeginLogin("alice")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	assertion := authenticator.get(t, options, 1)
+	if _, err := service.FinishLogin(assertion); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if _, err := service.FinishLogin(assertion); !errors.Is(err, ErrInvalidWebAuthnChallenge) {
+		t.Fatalf("replayed assertion: got %v, want ErrInvalidWebAuthnChallenge", err)
+	}
+}
+
+func TestWebAuthnLoginDetectsClonedAuthenticator(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, credentials := newTestWebAuthnService(users)
+	authenticator := registerSoftAuthenticator(t, service, 1)
+	clone := authenticator.clone()
+
+	if _, err := loginWith(t, service, authenticator, "alice", 1); err != nil {
+		t.Fatalf("FinishLogin with the original: %v", err)
+	}
+	// Копия сообщает тот же счетчик, что уже был принят
+	if _, err := loginWith(t, service, clone, "alice", 1); !errors.Is(err, ErrWebAuthnCredentialCloned) {
+		t.Fatalf("FinishLogin with the clone: got %v, want ErrWebAuthnCredentialCloned", err)
+	}
+	// После обнаружения копии ключ не принимается, даже если счетчик вырос
+	if _, err := loginWith(t, service, authenticator, "alice", 1); !errors.Is(err, ErrWebAuthnCredentialCloned) {
+		t.Fatalf("FinishLogin after clone detection: got %v, want ErrWebAuthnCredentialCloned", err)
+	}
+	stored, _ := credentials.FindByCredentialID(authenticator.id())
+	if !stored.CloneWarning || stored.SignCount != 1 {
+		t.Fatalf("credential after clone detection = %+v", stored)
+	}
+}
+
+func TestWebAuthnLoginRejectsConcurrentAssertionsWithSameCounter(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, _ := newTestWebAuthnService(users)
+	authenticator := registerSoftAuthenticator(t, service, 1)
+	clone := authenticator.clone()
+
+	// Обе проверки прочитали ключ со старым счетчиком, сохранить новый может только первая
+	first, err := service.BeginLogin("alice")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	second, err := service.BeginLogin("alice")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	firstAssertion := authenticator.get(t, first, 1)
+	secondAssertion := clone.get(t, second, 1)
+	if _, err := service.FinishLogin(firstAssertion); err != nil {
+		t.Fatalf("first FinishLogin: %v", err)
+	}
+	if _, err := service.FinishLogin(secondAssertion); !errors.Is(err, ErrWebAuthnCredentialCloned) {
+		t.Fatalf("second FinishLogin: got %v, want ErrWebAuthnCredentialCloned", err)
+	}
+}
+
+func TestWebAuthnLoginAcceptsAuthenticatorWithoutCounter(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"))
+	service, _ := newTestWebAuthnService(users)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.noCounter = true
+	options, err := service.BeginRegistration(1)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	if _, err := service.FinishRegistration(1, authenticator.create(t, options)); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := loginWith(t, service, authenticator, "alice", 1); err != nil {
+			t.Fatalf("FinishLogin #%d: %v", i+1, err)
+		}
+	}
+}
+
+func TestWebAuthnBeginLoginDoesNotRevealAccounts(t *testing.T) {
+	users := testutil.NewUserRepo(newTestUser("alice"), newTestUser("bob"))
+	service, _ := newTestWebAuthnService(users)
+	registerSoftAuthenticator(t, service, 1)
+
+	allowed := func(login string) []dto.WebAuthnCredentialDescriptor {
+		t.Helper()
+		options, err := service.BeginLogin(login)
+		if err != nil {
+			t.Fatalf("BeginLogin(%q): %v", login, err)
+		}
+		return options.PublicKey.AllowCredentials
+	}
+
+	alice := allowed("alice")
+	for _, login := range []string{"bob", "mallory"} {
+		fake := allowed(login)
+		if len(fake) != len(alice) || fake[0].Type != alice[0].Type {
+			t.Fatalf("allowCredentials for %q = %+v, want the same shape as for a user with a passkey", login, fake)
+		}
+		if fake[0].ID == alice[0].ID {
+			t.Fatalf("allowCredentials for %q repeats another user's credential", login)
+		}
+		if again := allowed(login); again[0].ID != fake[0].ID {
+			t.Fatalf("allowCredentials for %q changed between requests: %q, %q", login, fake[0].ID, again[0].ID)
+		}
+	}
+	if allowed("bob")[0].ID == allowed("mallory")[0].ID {
+		t.Fatal("different logins got the same fake credential")
+	}
+	if discoverable := allowed(""); len(discoverable) != 0 {
+		t.Fatalf("allowCredentials without login = %+v, want empty", discoverable)
+	}
+}
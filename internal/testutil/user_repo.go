@@ -0,0 +1,168 @@
+// Package testutil содержит общие заглушки для тестов сервисов и обработчиков
+package testutil
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"messenger-auth/internal/domain"
+	"messenger-auth/internal/models"
+	"messenger-auth/internal/repositories"
+)
+
+// UserRepo хранит пользователей в памяти и повторяет семантику UserRepoPostgres в том, что важно тестам:
+// ошибку "не найдено", конфликт логина или почты при создании. Методы, не нужные тестам, вызывают панику
+// (встроенный nil интерфейс). Пользователи возвращаются копиями, поэтому изменения вне Update не сохраняются
+type UserRepo struct {
+	repositories.UserRepository
+
+	mu            sync.Mutex
+	users         map[uint]*models.User
+	nextID        uint
+	lastPageQuery repositories.UserPageQuery
+}
+
+func NewUserRepo(users ...*models.User) *UserRepo {
+	repo := &UserRepo{users: map[uint]*models.User{}}
+	for _, user := range users {
+		repo.add(user)
+	}
+	return repo
+}
+
+func (repo *UserRepo) add(user *models.User) {
+	repo.nextID++
+	if user.ID == 0 {
+		user.ID = repo.nextID
+	}
+	stored := *user
+	repo.users[user.ID] = &stored
+}
+
+func (repo *UserRepo) Create(user *models.User) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, existing := range repo.users {
+		if sameString(existing.Login, user.Login) || sameString(existing.Email, user.Email) {
+			return domain.Conflict(domain.CodeUserAlreadyExists, "user with this login or email already exists", nil)
+		}
+	}
+	repo.add(user)
+	return nil
+}
+
+func (repo *UserRepo) Update(user *models.User) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored := *user
+	repo.users[user.ID] = &stored
+	return nil
+}
+
+func (repo *UserRepo) Delete(id uint) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if _, ok := repo.users[id]; !ok {
+		return userNotFound()
+	}
+	delete(repo.users, id)
+	return nil
+}
+
+func (repo *UserRepo) FindByID(id uint) (*models.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if user, ok := repo.users[id]; ok {
+		found := *user
+		return &found, nil
+	}
+	return nil, userNotFound()
+}
+
+func (repo *UserRepo) FindByLogin(login string) (*models.User, error) {
+	return repo.find(func(user *models.User) bool { return sameString(user.Login, &login) })
+}
+
+func (repo *UserRepo) FindByEmail(email string) (*models.User, error) {
+	return repo.find(func(user *models.User) bool { return sameString(user.Email, &email) })
+}
+
+// FindPage запоминает последний запрос страницы и возвращает всех пользователей в пределах Limit (фильтры не учитываются)
+func (repo *UserRepo) FindPage(query repositories.UserPageQuery) (*repositories.UserPage, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.lastPageQuery = query
+	page := &repositories.UserPage{}
+	for id := uint(1); id <= repo.nextID; id++ {
+		if user, ok := repo.users[id]; ok {
+			page.Users = append(page.Users, *user)
+		}
+	}
+	if query.Limit > 0 && len(page.Users) > query.Limit {
+		page.Users, page.HasMore = page.Users[:query.Limit], true
+	}
+	return page, nil
+}
+
+// LastPageQuery возвращает запрос последнего вызова FindPage
+func (repo *UserRepo) LastPageQuery() repositories.UserPageQuery {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.lastPageQuery
+}
+
+// Search ищет пользователей по префиксу логина или имени без учета регистра (триграммное сходство не учитывается).
+// Как и в Postgres, пользователи с HideFromSearch и excludeID в результат не попадают
+func (repo *UserRepo) Search(text string, excludeID uint, limit int) ([]models.User, error) {
+	text = strings.ToLower(text)
+	return repo.search(excludeID, limit, func(user *models.User) bool {
+		return !user.HideFromSearch && (hasPrefixFold(user.Login, text) || hasPrefixFold(user.Name, text))
+	})
+}
+
+// SearchByEmail ищет пользователя по точному адресу почты без учета регистра.
+// Как и в Postgres, пользователи с HideFromEmailSearch и excludeID в результат не попадают
+func (repo *UserRepo) SearchByEmail(email string, excludeID uint) ([]models.User, error) {
+	return repo.search(excludeID, 1, func(user *models.User) bool {
+		return !user.HideFromEmailSearch && user.Email != nil && strings.EqualFold(*user.Email, email)
+	})
+}
+
+// search возвращает подходящих пользователей в порядке id
+func (repo *UserRepo) search(excludeID uint, limit int, match func(user *models.User) bool) ([]models.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var users []models.User
+	for id := uint(1); id <= repo.nextID && len(users) < limit; id++ {
+		if user, ok := repo.users[id]; ok && id != excludeID && match(user) {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (repo *UserRepo) find(match func(user *models.User) bool) (*models.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, user := range repo.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, userNotFound()
+}
+
+func userNotFound() error {
+	return domain.NotFound(domain.CodeUserNotFound, "user not found", gorm.ErrRecordNotFound)
+}
+
+func hasPrefixFold(value *string, prefix string) bool {
+	return value != nil && strings.HasPrefix(strings.ToLower(*value), prefix)
+}
+
+// sameString сравнивает необязательные поля: пустое (nil) поле не совпадает ни с чем
+func sameString(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
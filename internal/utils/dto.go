@@ -5,4 +5,12 @@ func CopyIfNotNil[T any](dst **T, src *T) {
 	if src != nil {
 		*dst = src
 	}
-}
\ No newline at end of file
+}
+// ValueOrZero разыменовывает указатель, для nil возвращает нулевое значение типа
+func ValueOrZero[T any](src *T) T {
+	var zero T
+	if src == nil {
+		return zero
+	}
+	return *src
+}